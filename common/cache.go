@@ -0,0 +1,345 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iotaledger/iota.go/consts"
+	"github.com/iotaledger/iota.go/guards"
+)
+
+const (
+	// the cache sub directory holding getLedgerState responses.
+	ledgerStateCacheKind = "ledgerState"
+	// the cache sub directory holding getLedgerDiffExt responses.
+	ledgerDiffExtCacheKind = "ledgerDiffExt"
+
+	// the size of the header of a cache entry holding the hex encoded SHA-256 checksum of the response and a newline.
+	ledgerCacheEntryHeaderSize = sha256.Size*2 + 1
+)
+
+// LedgerCacheOption is a function setting a LedgerCache option.
+type LedgerCacheOption func(lc *LedgerCache)
+
+// WithLedgerCacheMaxLedgerStates sets the max amount of ledger states kept in the cache.
+// Once exceeded, the ledger states of the lowest milestone indexes are evicted.
+// A full ledger state takes up a lot of space, therefore ledger states are not cached by default.
+func WithLedgerCacheMaxLedgerStates(maxLedgerStates int) LedgerCacheOption {
+	return func(lc *LedgerCache) {
+		lc.maxLedgerStates = maxLedgerStates
+	}
+}
+
+// NewLedgerCache creates a new LedgerCache storing its entries within the given directory.
+func NewLedgerCache(dir string, opts ...LedgerCacheOption) (*LedgerCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create ledger cache directory: %w", err)
+	}
+	lc := &LedgerCache{dir: dir}
+	for _, opt := range opts {
+		opt(lc)
+	}
+	return lc, nil
+}
+
+// LedgerCache stores the raw getLedgerDiffExt and optionally getLedgerState responses of a legacy node on disk,
+// keyed by the coordinator address of the node's network and their milestone index. Since the ledger diff of
+// a confirmed milestone never changes, ledger diffs never expire. Ledger states are only kept for the configured
+// amount of milestones. Every entry carries a checksum of the response, corrupted entries are fetched again.
+type LedgerCache struct {
+	dir             string
+	maxLedgerStates int
+}
+
+// tells whether responses of the given kind are cached.
+func (lc *LedgerCache) caches(kind string) bool {
+	return kind != ledgerStateCacheKind || lc.maxLedgerStates > 0
+}
+
+// removes the ledger states of the lowest milestone indexes exceeding the max amount of ledger states.
+func (lc *LedgerCache) evictLedgerStates(network string) error {
+	entries, err := os.ReadDir(filepath.Join(lc.dir, network, ledgerStateCacheKind))
+	if err != nil {
+		return fmt.Errorf("unable to list ledger cache entries: %w", err)
+	}
+
+	var milestoneIndexes []int
+	for _, entry := range entries {
+		// skips the temporary files of entries being written
+		milestoneIndex, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		milestoneIndexes = append(milestoneIndexes, milestoneIndex)
+	}
+	if len(milestoneIndexes) <= lc.maxLedgerStates {
+		return nil
+	}
+
+	sort.Ints(milestoneIndexes)
+	for _, milestoneIndex := range milestoneIndexes[:len(milestoneIndexes)-lc.maxLedgerStates] {
+		if err := lc.remove(network, ledgerStateCacheKind, milestoneIndex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns the path of the cache entry of the given network, kind and milestone index.
+func (lc *LedgerCache) path(network string, kind string, milestoneIndex int) string {
+	return filepath.Join(lc.dir, network, kind, strconv.Itoa(milestoneIndex)+".json")
+}
+
+// opens the cache entry of the given network, kind and milestone index and verifies its checksum.
+// the returned file is positioned at the start of the response. returns false if there is no such entry
+// or if the entry is corrupted, in which case it is removed.
+func (lc *LedgerCache) open(network string, kind string, milestoneIndex int) (*os.File, bool, error) {
+	f, err := os.Open(lc.path(network, kind, milestoneIndex))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("unable to open ledger cache entry: %w", err)
+	}
+
+	valid, err := verifyLedgerCacheEntry(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, false, err
+	}
+	if !valid {
+		_ = f.Close()
+		return nil, false, lc.remove(network, kind, milestoneIndex)
+	}
+
+	if _, err := f.Seek(ledgerCacheEntryHeaderSize, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, false, fmt.Errorf("unable to seek ledger cache entry: %w", err)
+	}
+	return f, true, nil
+}
+
+// tells whether the checksum in the header of the given cache entry matches its content.
+func verifyLedgerCacheEntry(f *os.File) (bool, error) {
+	header := make([]byte, ledgerCacheEntryHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to read ledger cache entry: %w", err)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, fmt.Errorf("unable to read ledger cache entry: %w", err)
+	}
+
+	checksum := make([]byte, hex.EncodedLen(sha256.Size))
+	hex.Encode(checksum, h.Sum(nil))
+	return bytes.Equal(header, append(checksum, '\n')), nil
+}
+
+// removes the cache entry of the given network, kind and milestone index.
+func (lc *LedgerCache) remove(network string, kind string, milestoneIndex int) error {
+	if err := os.Remove(lc.path(network, kind, milestoneIndex)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to remove ledger cache entry: %w", err)
+	}
+	return nil
+}
+
+// creates a writer for a new cache entry of the given network, kind and milestone index.
+// the entry only becomes visible once the writer is committed.
+func (lc *LedgerCache) create(network string, kind string, milestoneIndex int) (*ledgerCacheWriter, error) {
+	dir := filepath.Join(lc.dir, network, kind)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create ledger cache directory: %w", err)
+	}
+
+	f, err := os.CreateTemp(dir, strconv.Itoa(milestoneIndex)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create ledger cache entry: %w", err)
+	}
+
+	// reserves the space of the header, the checksum is only known once the entire response was written
+	if _, err := f.Write(make([]byte, ledgerCacheEntryHeaderSize)); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, fmt.Errorf("unable to write ledger cache entry: %w", err)
+	}
+
+	return &ledgerCacheWriter{file: f, hash: sha256.New(), target: lc.path(network, kind, milestoneIndex)}, nil
+}
+
+// writes a cache entry to a temporary file which is moved to its final location on commit.
+type ledgerCacheWriter struct {
+	file   *os.File
+	hash   hash.Hash
+	target string
+}
+
+func (w *ledgerCacheWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.hash.Write(p[:n])
+	return n, err
+}
+
+// writes the checksum header, syncs the written data and atomically moves it to the cache entry's location.
+func (w *ledgerCacheWriter) commit() error {
+	header := make([]byte, ledgerCacheEntryHeaderSize)
+	hex.Encode(header, w.hash.Sum(nil))
+	header[len(header)-1] = '\n'
+	if _, err := w.file.WriteAt(header, 0); err != nil {
+		w.abort()
+		return fmt.Errorf("unable to write ledger cache entry header: %w", err)
+	}
+
+	if err := w.file.Sync(); err != nil {
+		w.abort()
+		return fmt.Errorf("unable to sync ledger cache entry: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		_ = os.Remove(w.file.Name())
+		return fmt.Errorf("unable to close ledger cache entry: %w", err)
+	}
+	if err := os.Rename(w.file.Name(), w.target); err != nil {
+		_ = os.Remove(w.file.Name())
+		return fmt.Errorf("unable to move ledger cache entry: %w", err)
+	}
+	return nil
+}
+
+// discards the written data.
+func (w *ledgerCacheWriter) abort() {
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
+
+// returns the network the legacy node belongs to, identified by its coordinator address.
+// returns an empty network if the node doesn't report a valid coordinator address.
+func (c *LegacyClient) ledgerCacheNetwork(ctx context.Context) (string, error) {
+	c.networkMu.Lock()
+	defer c.networkMu.Unlock()
+
+	if c.network != nil {
+		return *c.network, nil
+	}
+
+	info, err := c.NodeInfo(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to determine the network of the legacy node: %w", err)
+	}
+
+	var network string
+	if guards.IsTrytesOfExactLength(info.CoordinatorAddress, consts.HashTrytesSize) {
+		network = info.CoordinatorAddress
+	}
+	c.network = &network
+	return network, nil
+}
+
+// decodes the response of the given cacheable command either from the cache or from the legacy node.
+// responses fetched from the legacy node are written to the cache if they were decoded successfully.
+func (c *LegacyClient) cachedQuery(ctx context.Context, httpClient *http.Client, kind string, milestoneIndex int, cmd string, decode func(r io.Reader) error) error {
+	if c.cache == nil || !c.cache.caches(kind) {
+		return c.uncachedQuery(ctx, httpClient, cmd, decode)
+	}
+
+	network, err := c.ledgerCacheNetwork(ctx)
+	if err != nil {
+		return err
+	}
+	if len(network) == 0 {
+		// without knowing the network, the cache could serve the responses of another network
+		return c.uncachedQuery(ctx, httpClient, cmd, decode)
+	}
+
+	f, ok, err := c.cache.open(network, kind, milestoneIndex)
+	if err != nil {
+		return err
+	}
+	if ok {
+		defer f.Close()
+		if err := decode(f); err != nil {
+			var abortErr *decodeAbortError
+			if errors.As(err, &abortErr) {
+				return err
+			}
+			// the checksum of the entry matched, so the entry was written by a version decoding responses differently.
+			// the caller might have already consumed parts of the entry, so we can't transparently
+			// fall back to the legacy node but only get rid of the outdated entry
+			if rmErr := c.cache.remove(network, kind, milestoneIndex); rmErr != nil {
+				return rmErr
+			}
+			return fmt.Errorf("removed undecodable ledger cache entry: %w", err)
+		}
+		return nil
+	}
+
+	res, err := c.do(ctx, httpClient, cmd)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	w, err := c.cache.create(network, kind, milestoneIndex)
+	if err != nil {
+		return err
+	}
+
+	tee := io.TeeReader(res.Body, w)
+	if err := decode(tee); err != nil {
+		w.abort()
+		return err
+	}
+
+	// the decoder might not have consumed trailing whitespace
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		w.abort()
+		return fmt.Errorf("unable to read remaining response body: %w", err)
+	}
+
+	if err := w.commit(); err != nil {
+		return err
+	}
+
+	if kind == ledgerStateCacheKind {
+		return c.cache.evictLedgerStates(network)
+	}
+	return nil
+}
+
+// decodes the response of the given command from the legacy node.
+func (c *LegacyClient) uncachedQuery(ctx context.Context, httpClient *http.Client, cmd string, decode func(r io.Reader) error) error {
+	res, err := c.do(ctx, httpClient, cmd)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return decode(res.Body)
+}
+
+// wraps an error returned by a caller supplied callback during decoding,
+// in order to differentiate it from an error caused by a malformed response.
+type decodeAbortError struct {
+	err error
+}
+
+func (e *decodeAbortError) Error() string {
+	return e.err.Error()
+}
+
+func (e *decodeAbortError) Unwrap() error {
+	return e.err
+}
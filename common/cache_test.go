@@ -0,0 +1,183 @@
+package common
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testCoordinatorAddress      = "EQSAUZXULTTYZCLNJNTXQTQHOMOFZERHTCGTXOLTVAHKSA9OGAZDEKECURBRIXIJWNPFCQIOVFVVXJVD9"
+	testOtherCoordinatorAddress = "UDYXTZBE9GZGPM9SSQV9LTZNDLJIZMPUVVXYXFYVBLIEUHLSEWFTKZZLXYRHHWVQV9MNNX9KZC9D9UZWZ"
+)
+
+// creates a legacy client using a ledger cache in the given directory, which queries a legacy node
+// answering every command but getNodeInfo with the given body. the returned counter excludes getNodeInfo calls.
+func newCachedTestClientInDir(t *testing.T, dir string, coordinatorAddress string, body string, opts ...LedgerCacheOption) (*LegacyClient, *LedgerCache, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cmd, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if strings.Contains(string(cmd), "getNodeInfo") {
+			_, _ = w.Write([]byte(`{"coordinatorAddress": "` + coordinatorAddress + `"}`))
+			return
+		}
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	cache, err := NewLedgerCache(dir, opts...)
+	require.NoError(t, err)
+
+	c, err := NewLegacyClient(srv.URL, WithLegacyClientLedgerCache(cache), WithLegacyClientRetries(0, 0))
+	require.NoError(t, err)
+	return c, cache, &calls
+}
+
+func newCachedTestClient(t *testing.T, body string, opts ...LedgerCacheOption) (*LegacyClient, *LedgerCache, *int32) {
+	return newCachedTestClientInDir(t, t.TempDir(), testCoordinatorAddress, body, opts...)
+}
+
+func TestLedgerCacheLedgerDiffExtended(t *testing.T) {
+	c, _, calls := newCachedTestClient(t, string(readLedgerDiffExtFixture(t, "ledger_diff_ext_1337000.json")))
+
+	for i := 0; i < 3; i++ {
+		res, err := c.LedgerDiffExtended(context.Background(), 1337000)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1337000, res.MilestoneIndex)
+		assert.Len(t, res.ConfirmedTxWithValue, 5)
+		assert.Len(t, res.ConfirmedBundlesWithValue, 2)
+		assert.Equal(t, map[trinary.Hash]int64{
+			"TRANSFERC9J9QAXADAKARBYBEBLBSCZCFCMCTD9DGDNEHVOWVWBWIWPXWXCXJXQYXYDYKYRZTDRD99VD9": 1000000000,
+			"IVOODROUXTEDLMPTGBBIDYYKZTOEKJXRVPCXFAPQUGPQKNQCIGGKFKQMJKP99QKYCXRYNIXWMLGBBAZDZ": -1000000000,
+			"TRANSFERZ9Y9X9W9V9U9T9S9R9Q9P9O9N9MZLZKZJZIZHZGZFZEZDZCZBZAZ9ZZZYZXZWZVZUCWYYCRX9": 5000000,
+			"TZZHHWQZOQFSNTKZWGTOFBILLSSDPLS9BFP9EYKPMTZKJQOXOLEVYIEHVYBKNWMZEIIUGAM9TACWL9HLZ": -25000000,
+			"RF9STIALIKHQCPCVLUZQZWREQAXZNXFQQTEBLPXQBKULZXRHHBTOHNDMBGJPJNELRIVXXQLRTOUXALPR9": 20000000,
+		}, res.Diff)
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(calls))
+}
+
+func TestLedgerCacheStreamLedgerState(t *testing.T) {
+	c, _, calls := newCachedTestClient(t, `{"balances": {"AAA": 10, "BBB": 20}, "milestoneIndex": 7}`, WithLedgerCacheMaxLedgerStates(1))
+
+	for i := 0; i < 2; i++ {
+		var total uint64
+		_, err := c.StreamLedgerState(context.Background(), 7, func(_ trinary.Hash, balance uint64) error {
+			total += balance
+			return nil
+		})
+		require.NoError(t, err)
+		assert.EqualValues(t, 30, total)
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(calls))
+
+	// the streamed and the non-streamed variant share the cache entry
+	res, err := c.LedgerState(context.Background(), 7)
+	require.NoError(t, err)
+	assert.Len(t, res.Balances, 2)
+	assert.EqualValues(t, 1, atomic.LoadInt32(calls))
+}
+
+func TestLedgerCacheLedgerStatesDisabledByDefault(t *testing.T) {
+	c, cache, calls := newCachedTestClient(t, `{"balances": {"AAA": 10}, "milestoneIndex": 7}`)
+
+	for i := 0; i < 2; i++ {
+		_, err := c.LedgerState(context.Background(), 7)
+		require.NoError(t, err)
+	}
+	assert.EqualValues(t, 2, atomic.LoadInt32(calls))
+	assert.NoDirExists(t, filepath.Join(cache.dir, testCoordinatorAddress, ledgerStateCacheKind))
+}
+
+func TestLedgerCacheEvictsLedgerStates(t *testing.T) {
+	c, cache, _ := newCachedTestClient(t, `{"balances": {"AAA": 10}, "milestoneIndex": 7}`, WithLedgerCacheMaxLedgerStates(2))
+
+	for _, lsmi := range []int{9, 7, 8, 10} {
+		_, err := c.LedgerState(context.Background(), lsmi)
+		require.NoError(t, err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(cache.dir, testCoordinatorAddress, ledgerStateCacheKind))
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{"9.json", "10.json"}, names)
+}
+
+func TestLedgerCacheSkipsErrors(t *testing.T) {
+	c, cache, calls := newCachedTestClient(t, `{"error": "milestone not found"}`)
+
+	for i := 0; i < 2; i++ {
+		_, err := c.LedgerDiffExtended(context.Background(), 7)
+		require.Error(t, err)
+	}
+	assert.EqualValues(t, 2, atomic.LoadInt32(calls))
+
+	entries, err := os.ReadDir(filepath.Join(cache.dir, testCoordinatorAddress, ledgerDiffExtCacheKind))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestLedgerCacheCorruptedEntry(t *testing.T) {
+	c, cache, calls := newCachedTestClient(t, `{"diff": {}, "milestoneIndex": 7}`)
+
+	_, err := c.LedgerDiffExtended(context.Background(), 7)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(calls))
+
+	// truncates the entry
+	path := cache.path(testCoordinatorAddress, ledgerDiffExtCacheKind, 7)
+	entry, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, entry[:len(entry)-5], 0644))
+
+	// the corrupted entry is treated as a miss and fetched again
+	res, err := c.LedgerDiffExtended(context.Background(), 7)
+	require.NoError(t, err)
+	assert.EqualValues(t, 7, res.MilestoneIndex)
+	assert.EqualValues(t, 2, atomic.LoadInt32(calls))
+
+	_, err = c.LedgerDiffExtended(context.Background(), 7)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, atomic.LoadInt32(calls))
+}
+
+func TestLedgerCacheSeparatesNetworks(t *testing.T) {
+	dir := t.TempDir()
+
+	c, _, calls := newCachedTestClientInDir(t, dir, testCoordinatorAddress, `{"diff": {}, "milestoneIndex": 7}`)
+	_, err := c.LedgerDiffExtended(context.Background(), 7)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(calls))
+
+	// a node of another network doesn't get served the entries of the first one
+	other, _, otherCalls := newCachedTestClientInDir(t, dir, testOtherCoordinatorAddress, `{"diff": {"`+testOtherCoordinatorAddress+`": 0}, "milestoneIndex": 7}`)
+	res, err := other.LedgerDiffExtended(context.Background(), 7)
+	require.NoError(t, err)
+	assert.Contains(t, res.Diff, testOtherCoordinatorAddress)
+	assert.EqualValues(t, 1, atomic.LoadInt32(otherCalls))
+}
+
+func TestLedgerCacheUnknownNetwork(t *testing.T) {
+	c, _, calls := newCachedTestClientInDir(t, t.TempDir(), "", `{"diff": {}, "milestoneIndex": 7}`)
+
+	for i := 0; i < 2; i++ {
+		_, err := c.LedgerDiffExtended(context.Background(), 7)
+		require.NoError(t, err)
+	}
+	assert.EqualValues(t, 2, atomic.LoadInt32(calls))
+}
@@ -0,0 +1,309 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLegacyClientMaxRetries defines the default amount of retries for a failed legacy node request.
+	DefaultLegacyClientMaxRetries = 3
+	// DefaultLegacyClientRetryBackoff defines the default initial backoff between retries, it doubles on every retry.
+	DefaultLegacyClientRetryBackoff = 500 * time.Millisecond
+	// DefaultLegacyClientTimeout defines the default timeout of a request to the legacy node.
+	DefaultLegacyClientTimeout = 30 * time.Second
+	// DefaultLegacyClientLedgerStateTimeout defines the default timeout of a getLedgerState request.
+	// Downloading the entire ledger takes minutes, therefore it is way longer than the timeout of the other requests.
+	DefaultLegacyClientLedgerStateTimeout = 30 * time.Minute
+
+	// the max amount of bytes read from an erroneous response body.
+	maxErrorBodySize = 4096
+)
+
+var (
+	// ErrInvalidLegacyNodeURI is returned when the legacy node URI can not be used to build requests.
+	ErrInvalidLegacyNodeURI = errors.New("invalid legacy node URI")
+)
+
+// HTTPStatusError is returned when a legacy node answers with a non 200 HTTP status code
+// without a legacy error body.
+type HTTPStatusError struct {
+	// The HTTP status code of the response.
+	StatusCode int
+	// The (possibly cut off) body of the response.
+	Body string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("legacy node responded with HTTP status %d: %s", e.StatusCode, e.Body)
+}
+
+// APIError is returned when a legacy node answers with a legacy {"error": ...} body.
+type APIError struct {
+	// The HTTP status code of the response.
+	StatusCode int
+	// The error message returned by the legacy node.
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("legacy node responded with error (HTTP status %d): %s", e.StatusCode, e.Message)
+}
+
+// defines the body of an erroneous legacy node response.
+type legacyErrorReturn struct {
+	Error string `json:"error"`
+}
+
+func (e *legacyErrorReturn) legacyError() string {
+	return e.Error
+}
+
+// a response object embedding legacyErrorReturn, so that a legacy error body
+// is detected within the same pass decoding the actual response.
+type legacyResponse interface {
+	legacyError() string
+}
+
+// LegacyClientOption is a function setting a LegacyClient option.
+type LegacyClientOption func(c *LegacyClient)
+
+// WithLegacyClientHTTPClient sets the HTTP client used by the LegacyClient for all commands but getLedgerState.
+func WithLegacyClientHTTPClient(httpClient *http.Client) LegacyClientOption {
+	return func(c *LegacyClient) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithLegacyClientLedgerStateHTTPClient sets the HTTP client used by the LegacyClient to query ledger states.
+// Downloading the entire ledger takes minutes, so by default ledger states are queried with the longer
+// DefaultLegacyClientLedgerStateTimeout instead of the DefaultLegacyClientTimeout of the other commands.
+func WithLegacyClientLedgerStateHTTPClient(httpClient *http.Client) LegacyClientOption {
+	return func(c *LegacyClient) {
+		c.ledgerStateHTTPClient = httpClient
+	}
+}
+
+// WithLegacyClientRetries sets the max amount of retries and the initial backoff between them.
+// The backoff doubles with every retry.
+func WithLegacyClientRetries(maxRetries int, backoff time.Duration) LegacyClientOption {
+	return func(c *LegacyClient) {
+		c.maxRetries = maxRetries
+		c.retryBackoff = backoff
+	}
+}
+
+// WithLegacyClientLedgerCache sets the LedgerCache used to serve ledger diffs and, if enabled in the cache,
+// ledger states of already queried milestones without querying the legacy node again.
+// The cache entries are kept per network, identified by the coordinator address the node reports.
+// Nodes not reporting a coordinator address are always queried without the cache.
+func WithLegacyClientLedgerCache(cache *LedgerCache) LegacyClientOption {
+	return func(c *LegacyClient) {
+		c.cache = cache
+	}
+}
+
+// NewLegacyClient creates a new LegacyClient querying the legacy node at the given URI.
+func NewLegacyClient(legacyNodeURI string, opts ...LegacyClientOption) (*LegacyClient, error) {
+	u, err := url.Parse(legacyNodeURI)
+	if err != nil {
+		return nil, fmt.Errorf("%w '%s': %v", ErrInvalidLegacyNodeURI, legacyNodeURI, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return nil, fmt.Errorf("%w '%s': must be an absolute http(s) URI", ErrInvalidLegacyNodeURI, legacyNodeURI)
+	}
+
+	c := &LegacyClient{
+		uri:                   u.String(),
+		httpClient:            &http.Client{Timeout: DefaultLegacyClientTimeout},
+		ledgerStateHTTPClient: &http.Client{Timeout: DefaultLegacyClientLedgerStateTimeout},
+		maxRetries:            DefaultLegacyClientMaxRetries,
+		retryBackoff:          DefaultLegacyClientRetryBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// LegacyClient queries a legacy node for the HTTP API commands which are not covered by the iota.go API.
+type LegacyClient struct {
+	uri                   string
+	httpClient            *http.Client
+	ledgerStateHTTPClient *http.Client
+	maxRetries            int
+	retryBackoff          time.Duration
+	cache                 *LedgerCache
+
+	// the network the legacy node belongs to, resolved on the first cached query.
+	networkMu sync.Mutex
+	network   *string
+}
+
+// URI returns the URI of the legacy node this client queries.
+func (c *LegacyClient) URI() string {
+	return c.uri
+}
+
+// LedgerState queries for the ledger state at the given target LSMI.
+func (c *LegacyClient) LedgerState(ctx context.Context, lsmi int) (*GetLedgerStateReturn, error) {
+	resObj := &struct {
+		legacyErrorReturn
+		*GetLedgerStateReturn
+	}{GetLedgerStateReturn: &GetLedgerStateReturn{}}
+	cmd := fmt.Sprintf(`{"command": "getLedgerState", "targetIndex": %d}`, lsmi)
+	if err := c.cachedQuery(ctx, c.ledgerStateHTTPClient, ledgerStateCacheKind, lsmi, cmd, decodeJSON(resObj)); err != nil {
+		return nil, fmt.Errorf("unable to query ledger state: %w", err)
+	}
+	return resObj.GetLedgerStateReturn, nil
+}
+
+// LedgerDiffExtended queries for the extended ledger diff of the given milestone.
+func (c *LegacyClient) LedgerDiffExtended(ctx context.Context, milestoneIndex int) (*GetLedgerDiffExtReturn, error) {
+	var resObj *GetLedgerDiffExtReturn
+	cmd := fmt.Sprintf(`{"command": "getLedgerDiffExt", "milestoneIndex": %d}`, milestoneIndex)
+	if err := c.cachedQuery(ctx, c.httpClient, ledgerDiffExtCacheKind, milestoneIndex, cmd, func(r io.Reader) error {
+		var err error
+		if resObj, err = DecodeLedgerDiffExt(r); err != nil {
+			return err
+		}
+		if int(resObj.MilestoneIndex) != milestoneIndex {
+			return fmt.Errorf("%w: requested milestone %d but got %d", ErrInvalidLedgerDiffExt, milestoneIndex, resObj.MilestoneIndex)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("unable to query ledger extended diff: %w", err)
+	}
+	return resObj, nil
+}
+
+// NodeInfo queries for the node info.
+func (c *LegacyClient) NodeInfo(ctx context.Context) (*GetNodeInfoResponse, error) {
+	resObj := &struct {
+		legacyErrorReturn
+		*GetNodeInfoResponse
+	}{GetNodeInfoResponse: &GetNodeInfoResponse{}}
+	if err := c.query(ctx, `{"command": "getNodeInfo"}`, resObj); err != nil {
+		return nil, fmt.Errorf("unable to query node info: %w", err)
+	}
+	return resObj.GetNodeInfoResponse, nil
+}
+
+// WhiteFlagConfirmation queries for the white-flag confirmation of the given milestone.
+func (c *LegacyClient) WhiteFlagConfirmation(ctx context.Context, milestoneIndex int) (*GetWhiteFlagConfirmationResponse, error) {
+	resObj := &struct {
+		legacyErrorReturn
+		*GetWhiteFlagConfirmationResponse
+	}{GetWhiteFlagConfirmationResponse: &GetWhiteFlagConfirmationResponse{}}
+	if err := c.query(ctx, fmt.Sprintf(`{"command": "getWhiteFlagConfirmation", "milestoneIndex": %d}`, milestoneIndex), resObj); err != nil {
+		return nil, fmt.Errorf("unable to query white-flag confirmation: %w", err)
+	}
+	return resObj.GetWhiteFlagConfirmationResponse, nil
+}
+
+// executes the given command and JSON decodes the response into resObj.
+func (c *LegacyClient) query(ctx context.Context, cmd string, resObj legacyResponse) error {
+	res, err := c.do(ctx, c.httpClient, cmd)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return decodeJSON(resObj)(res.Body)
+}
+
+// returns a function which JSON decodes a response into resObj.
+// a legacy error body is returned as an APIError even if the legacy node answered with HTTP status 200.
+func decodeJSON(resObj legacyResponse) func(r io.Reader) error {
+	return func(r io.Reader) error {
+		if err := json.NewDecoder(r).Decode(resObj); err != nil {
+			return fmt.Errorf("unable to JSON decode response: %w", err)
+		}
+		if msg := resObj.legacyError(); len(msg) > 0 {
+			return &APIError{StatusCode: http.StatusOK, Message: msg}
+		}
+		return nil
+	}
+}
+
+// executes the given command against the legacy node using the given HTTP client and retries on transient errors.
+// the returned response always has a HTTP status code of 200 and its body must be closed by the caller.
+func (c *LegacyClient) do(ctx context.Context, httpClient *http.Client, cmd string) (*http.Response, error) {
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		res, err := c.doOnce(ctx, httpClient, cmd)
+		if err == nil {
+			return res, nil
+		}
+
+		if attempt >= c.maxRetries || !isRetryable(ctx, err) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// executes a single request against the legacy node.
+func (c *LegacyClient) doOnce(ctx context.Context, httpClient *http.Client, cmd string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.uri, bytes.NewReader([]byte(cmd)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-IOTA-API-Version", "1")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusOK {
+		return res, nil
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read body of response with HTTP status %d: %w", res.StatusCode, err)
+	}
+
+	errRes := &legacyErrorReturn{}
+	if err := json.Unmarshal(body, errRes); err == nil && len(errRes.Error) > 0 {
+		return nil, &APIError{StatusCode: res.StatusCode, Message: errRes.Error}
+	}
+	return nil, &HTTPStatusError{StatusCode: res.StatusCode, Body: string(body)}
+}
+
+// tells whether the given error of a request is worth retrying.
+func isRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	// network errors
+	return true
+}
@@ -0,0 +1,170 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLegacyClientInvalidURI(t *testing.T) {
+	for _, uri := range []string{"", "localhost:14265", "ftp://localhost", "http://", "http://%zz"} {
+		t.Run(uri, func(t *testing.T) {
+			_, err := NewLegacyClient(uri)
+			assert.ErrorIs(t, err, ErrInvalidLegacyNodeURI)
+		})
+	}
+}
+
+func TestLegacyClientWhiteFlagConfirmation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"command": "getWhiteFlagConfirmation", "milestoneIndex": 5}`, string(body))
+		assert.Equal(t, "1", r.Header.Get("X-IOTA-API-Version"))
+		_, _ = w.Write([]byte(`{"milestoneBundle": ["A", "B"], "includedBundles": [["C"]]}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewLegacyClient(srv.URL)
+	require.NoError(t, err)
+
+	res, err := c.WhiteFlagConfirmation(context.Background(), 5)
+	require.NoError(t, err)
+	assert.Len(t, res.MilestoneBundle, 2)
+	assert.Len(t, res.IncludedBundles, 1)
+}
+
+func TestLegacyClientErrors(t *testing.T) {
+	var tests = []struct {
+		name       string
+		status     int
+		body       string
+		expCalls   int32
+		expAPIErr  bool
+		expMessage string
+	}{
+		{name: "legacy error body", status: http.StatusBadRequest, body: `{"error": "milestone not found"}`, expCalls: 1, expAPIErr: true, expMessage: "milestone not found"},
+		{name: "plain bad request", status: http.StatusBadRequest, body: "bad", expCalls: 1},
+		{name: "retried server error", status: http.StatusServiceUnavailable, body: "unavailable", expCalls: 3},
+		{name: "retried legacy error body", status: http.StatusInternalServerError, body: `{"error": "internal"}`, expCalls: 3, expAPIErr: true, expMessage: "internal"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			c, err := NewLegacyClient(srv.URL, WithLegacyClientRetries(2, time.Millisecond))
+			require.NoError(t, err)
+
+			_, err = c.LedgerDiffExtended(context.Background(), 1)
+			require.Error(t, err)
+			assert.Equal(t, tt.expCalls, atomic.LoadInt32(&calls))
+
+			var apiErr *APIError
+			var statusErr *HTTPStatusError
+			if tt.expAPIErr {
+				require.True(t, errors.As(err, &apiErr))
+				assert.Equal(t, tt.status, apiErr.StatusCode)
+				assert.Equal(t, tt.expMessage, apiErr.Message)
+				return
+			}
+			require.True(t, errors.As(err, &statusErr))
+			assert.Equal(t, tt.status, statusErr.StatusCode)
+			assert.Equal(t, tt.body, statusErr.Body)
+		})
+	}
+}
+
+func TestLegacyClientRetryRecovers(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{"balances": {"A": 10}, "milestoneIndex": 3}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewLegacyClient(srv.URL, WithLegacyClientRetries(1, time.Millisecond))
+	require.NoError(t, err)
+
+	res, err := c.LedgerState(context.Background(), 3)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, res.MilestoneIndex)
+	assert.EqualValues(t, 10, res.Balances["A"])
+}
+
+func TestLegacyClientContextCanceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c, err := NewLegacyClient(srv.URL, WithLegacyClientRetries(10, time.Hour))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = c.LedgerState(ctx, 1)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), time.Minute)
+}
+
+func TestLegacyClientLedgerStateWithoutTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"balances": {"A": 10}, "milestoneIndex": 3, "latestMilestoneIndex": 3}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewLegacyClient(srv.URL, WithLegacyClientRetries(0, 0), WithLegacyClientHTTPClient(&http.Client{Timeout: 10 * time.Millisecond}))
+	require.NoError(t, err)
+
+	// the short timeout only applies to the other commands
+	_, err = c.NodeInfo(context.Background())
+	require.Error(t, err)
+
+	res, err := c.LedgerState(context.Background(), 3)
+	require.NoError(t, err)
+	assert.EqualValues(t, 10, res.Balances["A"])
+}
+
+func TestLegacyClientErrorBodyWithStatusOK(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"error": "milestone not found"}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewLegacyClient(srv.URL, WithLegacyClientRetries(0, 0))
+	require.NoError(t, err)
+
+	_, err = c.NodeInfo(context.Background())
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusOK, apiErr.StatusCode)
+	assert.Equal(t, "milestone not found", apiErr.Message)
+}
+
+func TestNewLegacyClientDefaultTimeouts(t *testing.T) {
+	c, err := NewLegacyClient("http://localhost:14265")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultLegacyClientTimeout, c.httpClient.Timeout)
+	assert.Equal(t, DefaultLegacyClientLedgerStateTimeout, c.ledgerStateHTTPClient.Timeout)
+}
@@ -1,12 +1,7 @@
 package common
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-	"net/url"
+	"context"
 
 	"github.com/iotaledger/iota.go/trinary"
 )
@@ -20,81 +15,80 @@ type (
 )
 
 // QueryLedgerState queries for the ledger state given the legacy node URI and target LSMI.
+//
+// Deprecated: use LegacyClient.LedgerState instead.
 func QueryLedgerState(legacyNodeURI string, lsmi int) (*GetLedgerStateReturn, error) {
-	req := buildLegacyRequest(legacyNodeURI, fmt.Sprintf(`{"command": "getLedgerState", "targetIndex": %d}`, lsmi))
-	http.DefaultClient.Timeout = 0
-	res, err := http.DefaultClient.Do(req)
+	c, err := NewLegacyClient(legacyNodeURI)
 	if err != nil {
-		return nil, fmt.Errorf("unable to query ledger state: %w", err)
+		return nil, err
 	}
-	defer res.Body.Close()
-
-	var resObj GetLedgerStateReturn
-	jsonRes, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("unable to read response body from ledger state query response: %w", err)
-	}
-
-	if err := json.Unmarshal(jsonRes, &resObj); err != nil {
-		return nil, fmt.Errorf("unable to JSON unmarshal ledger state query response: %w", err)
-	}
-
-	return &resObj, nil
+	return c.LedgerState(context.Background(), lsmi)
 }
 
 type (
+	// GetLedgerDiffExtReturn defines the response of a getLedgerDiffExt HTTP API call.
 	GetLedgerDiffExtReturn struct {
-		ConfirmedTxWithValue      []*TxHashWithValue     `json:"confirmedTxWithValue"`
-		ConfirmedBundlesWithValue []*BundleWithValue     `json:"confirmedBundlesWithValue"`
-		Diff                      map[trinary.Hash]int64 `json:"diff"`
-		MilestoneIndex            uint32                 `json:"milestoneIndex"`
-		Duration                  int                    `json:"duration"`
-	}
-
+		// The value transactions confirmed by the milestone.
+		ConfirmedTxWithValue []*TxHashWithValue `json:"confirmedTxWithValue" mapstructure:"confirmedTxWithValue"`
+		// The bundles containing value transactions confirmed by the milestone.
+		ConfirmedBundlesWithValue []*BundleWithValue `json:"confirmedBundlesWithValue" mapstructure:"confirmedBundlesWithValue"`
+		// The balance changes per address caused by the milestone.
+		Diff map[trinary.Hash]int64 `json:"diff" mapstructure:"diff"`
+		// The index of the milestone.
+		MilestoneIndex uint32 `json:"milestoneIndex" mapstructure:"milestoneIndex"`
+		// The time in milliseconds it took the node to compute the response.
+		Duration int `json:"duration" mapstructure:"duration"`
+	}
+
+	// TxHashWithValue is a value transaction confirmed by a milestone.
 	TxHashWithValue struct {
-		TxHash     trinary.Hash `mapstructure:"txHash"`
-		TailTxHash trinary.Hash `mapstructure:"tailTxHash"`
-		BundleHash trinary.Hash `mapstructure:"bundleHash"`
-		Address    trinary.Hash `mapstructure:"address"`
-		Value      int64        `mapstructure:"value"`
-	}
-
+		// The hash of the transaction.
+		TxHash trinary.Hash `json:"txHash" mapstructure:"txHash"`
+		// The hash of the tail transaction of the bundle the transaction belongs to.
+		TailTxHash trinary.Hash `json:"tailTxHash" mapstructure:"tailTxHash"`
+		// The hash of the bundle the transaction belongs to.
+		BundleHash trinary.Hash `json:"bundleHash" mapstructure:"bundleHash"`
+		// The address (without checksum) of the transaction.
+		Address trinary.Hash `json:"address" mapstructure:"address"`
+		// The value of the transaction, negative for inputs.
+		Value int64 `json:"value" mapstructure:"value"`
+	}
+
+	// BundleWithValue is a bundle containing value transactions confirmed by a milestone.
 	BundleWithValue struct {
-		BundleHash trinary.Hash   `mapstructure:"bundleHash"`
-		TailTxHash trinary.Hash   `mapstructure:"tailTxHash"`
-		LastIndex  uint64         `mapstructure:"lastIndex"`
-		Txs        []*TxWithValue `mapstructure:"txs"`
-	}
-
+		// The hash of the bundle.
+		BundleHash trinary.Hash `json:"bundleHash" mapstructure:"bundleHash"`
+		// The hash of the tail transaction of the bundle.
+		TailTxHash trinary.Hash `json:"tailTxHash" mapstructure:"tailTxHash"`
+		// The index of the last transaction within the bundle, the bundle therefore consists of LastIndex+1 transactions.
+		// Since Txs only holds the value transactions, it can contain less entries.
+		LastIndex uint64 `json:"lastIndex" mapstructure:"lastIndex"`
+		// The value transactions of the bundle.
+		Txs []*TxWithValue `json:"txs" mapstructure:"txs"`
+	}
+
+	// TxWithValue is a value transaction within a BundleWithValue.
 	TxWithValue struct {
-		TxHash  trinary.Hash `mapstructure:"txHash"`
-		Address trinary.Hash `mapstructure:"address"`
-		Index   uint64       `mapstructure:"index"`
-		Value   int64        `mapstructure:"value"`
+		// The hash of the transaction.
+		TxHash trinary.Hash `json:"txHash" mapstructure:"txHash"`
+		// The address (without checksum) of the transaction.
+		Address trinary.Hash `json:"address" mapstructure:"address"`
+		// The index of the transaction within its bundle.
+		Index uint64 `json:"index" mapstructure:"index"`
+		// The value of the transaction, negative for inputs.
+		Value int64 `json:"value" mapstructure:"value"`
 	}
 )
 
 // QueryLedgerDiffExtended queries for an extended ledger diff of a given milestone.
+//
+// Deprecated: use LegacyClient.LedgerDiffExtended instead.
 func QueryLedgerDiffExtended(legacyNodeURI string, milestoneIndex int) (*GetLedgerDiffExtReturn, error) {
-	req := buildLegacyRequest(legacyNodeURI, fmt.Sprintf(`{"command": "getLedgerDiffExt", "milestoneIndex": %d}`, milestoneIndex))
-	http.DefaultClient.Timeout = 0
-	res, err := http.DefaultClient.Do(req)
+	c, err := NewLegacyClient(legacyNodeURI)
 	if err != nil {
-		return nil, fmt.Errorf("unable to query ledger extended diff: %w", err)
+		return nil, err
 	}
-	defer res.Body.Close()
-
-	var resObj GetLedgerDiffExtReturn
-	jsonRes, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("unable to read response body from ledger extended diff response: %w", err)
-	}
-
-	if err := json.Unmarshal(jsonRes, &resObj); err != nil {
-		return nil, fmt.Errorf("unable to JSON unmarshal ledger extended diff response: %w", err)
-	}
-
-	return &resObj, nil
+	return c.LedgerDiffExtended(context.Background(), milestoneIndex)
 }
 
 type (
@@ -108,46 +102,28 @@ type (
 )
 
 // QueryWhiteFlagConfirmation queries for the white-flag confirmation of given milestone.
+//
+// Deprecated: use LegacyClient.WhiteFlagConfirmation instead.
 func QueryWhiteFlagConfirmation(legacyNodeURI string, milestoneIndex int) (*GetWhiteFlagConfirmationResponse, error) {
-	req := buildLegacyRequest(legacyNodeURI, fmt.Sprintf(`{"command": "getWhiteFlagConfirmation", "milestoneIndex": %d}`, milestoneIndex))
-	http.DefaultClient.Timeout = 0
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("unable to query white-flag confirmation: %w", err)
-	}
-	defer res.Body.Close()
-
-	var resObj GetWhiteFlagConfirmationResponse
-	jsonRes, err := io.ReadAll(res.Body)
+	c, err := NewLegacyClient(legacyNodeURI)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read response body from white-flag confirmation response: %w", err)
+		return nil, err
 	}
-
-	if err := json.Unmarshal(jsonRes, &resObj); err != nil {
-		return nil, fmt.Errorf("unable to JSON unmarshal white-flag confirmation response: %w", err)
-	}
-
-	return &resObj, nil
+	return c.WhiteFlagConfirmation(context.Background(), milestoneIndex)
 }
 
-// builds up a legacy node API request
-func buildLegacyRequest(legacyNodeURI string, body string) *http.Request {
-	return &http.Request{
-		Method: http.MethodPost,
-		URL: func() *url.URL {
-			u, err := url.Parse(legacyNodeURI)
-			if err != nil {
-				panic(err)
-			}
-			return u
-		}(),
-		Header: map[string][]string{
-			"Content-Type":       {"application/json"},
-			"X-IOTA-API-Version": {"1"},
-		},
-		Body: func() io.ReadCloser {
-			cmd := []byte(body)
-			return io.NopCloser(bytes.NewReader(cmd))
-		}(),
+type (
+	// GetNodeInfoResponse defines the subset of the response of a getNodeInfo HTTP API call used by the tools.
+	GetNodeInfoResponse struct {
+		// The index of the latest known milestone.
+		LatestMilestoneIndex uint32 `json:"latestMilestoneIndex"`
+		// The index of the latest solid milestone.
+		LatestSolidSubtangleMilestoneIndex uint32 `json:"latestSolidSubtangleMilestoneIndex"`
+		// The index of the milestone up to which (including) the node pruned its database.
+		MilestoneStartIndex uint32 `json:"milestoneStartIndex"`
+		// The index of the milestone of the last local snapshot.
+		LastSnapshottedMilestoneIndex uint32 `json:"lastSnapshottedMilestoneIndex"`
+		// The address of the coordinator issuing the milestones of the node's network.
+		CoordinatorAddress trinary.Hash `json:"coordinatorAddress"`
 	}
-}
+)
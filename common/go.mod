@@ -2,6 +2,14 @@ module github.com/iotaledger/chrysalis-tools/common
 
 go 1.20
 
-require github.com/iotaledger/iota.go v1.0.0
+require (
+	github.com/iotaledger/iota.go v1.0.0
+	github.com/stretchr/testify v1.8.4
+)
 
-require github.com/pkg/errors v0.8.1 // indirect
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pkg/errors v0.8.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
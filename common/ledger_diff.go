@@ -0,0 +1,184 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/iotaledger/iota.go/consts"
+	"github.com/iotaledger/iota.go/guards"
+	"github.com/iotaledger/iota.go/trinary"
+)
+
+var (
+	// ErrInvalidLedgerDiffExt is returned when a getLedgerDiffExt response is malformed.
+	ErrInvalidLedgerDiffExt = errors.New("invalid extended ledger diff")
+	// ErrInconsistentLedgerDiffExt is returned when a syntactically valid getLedgerDiffExt response contradicts itself,
+	// e.g. when its diff doesn't match its confirmed transactions. Querying the same milestone again won't resolve it.
+	ErrInconsistentLedgerDiffExt = errors.New("inconsistent extended ledger diff")
+)
+
+// DecodeLedgerDiffExt decodes and validates a getLedgerDiffExt response from the given reader.
+// Fields not part of GetLedgerDiffExtReturn are ignored, a legacy error body is returned as an APIError.
+func DecodeLedgerDiffExt(r io.Reader) (*GetLedgerDiffExtReturn, error) {
+	resObj := &struct {
+		legacyErrorReturn
+		*GetLedgerDiffExtReturn
+	}{GetLedgerDiffExtReturn: &GetLedgerDiffExtReturn{}}
+	if err := json.NewDecoder(r).Decode(resObj); err != nil {
+		return nil, fmt.Errorf("%w: unable to JSON decode: %v", ErrInvalidLedgerDiffExt, err)
+	}
+
+	if len(resObj.Error) > 0 {
+		return nil, &APIError{StatusCode: http.StatusOK, Message: resObj.Error}
+	}
+
+	if err := resObj.Validate(); err != nil {
+		return nil, err
+	}
+
+	return resObj.GetLedgerDiffExtReturn, nil
+}
+
+// Validate checks the syntactical validity of all hashes and addresses and the semantic consistency
+// between the confirmed transactions, the confirmed bundles and the diff.
+// Syntactical errors wrap ErrInvalidLedgerDiffExt, inconsistencies of a syntactically valid response wrap ErrInconsistentLedgerDiffExt.
+func (r *GetLedgerDiffExtReturn) Validate() error {
+	bundles := make(map[trinary.Hash]*BundleWithValue, len(r.ConfirmedBundlesWithValue))
+	bundleTxs := make(map[trinary.Hash]*TxWithValue)
+	for _, bndl := range r.ConfirmedBundlesWithValue {
+		if bndl == nil {
+			return fmt.Errorf("%w: nil bundle", ErrInvalidLedgerDiffExt)
+		}
+		if err := bndl.Validate(); err != nil {
+			return err
+		}
+		if _, has := bundles[bndl.TailTxHash]; has {
+			return fmt.Errorf("%w: duplicate bundle with tail tx %s", ErrInvalidLedgerDiffExt, bndl.TailTxHash)
+		}
+		bundles[bndl.TailTxHash] = bndl
+		for _, tx := range bndl.Txs {
+			bundleTxs[tx.TxHash] = tx
+		}
+	}
+
+	seenTxs := make(map[trinary.Hash]struct{}, len(r.ConfirmedTxWithValue))
+	for _, tx := range r.ConfirmedTxWithValue {
+		if tx == nil {
+			return fmt.Errorf("%w: nil transaction", ErrInvalidLedgerDiffExt)
+		}
+		if err := tx.Validate(); err != nil {
+			return err
+		}
+		if _, has := seenTxs[tx.TxHash]; has {
+			return fmt.Errorf("%w: duplicate tx %s", ErrInvalidLedgerDiffExt, tx.TxHash)
+		}
+		seenTxs[tx.TxHash] = struct{}{}
+	}
+
+	for addr := range r.Diff {
+		if !guards.IsTrytesOfExactLength(addr, consts.HashTrytesSize) {
+			return fmt.Errorf("%w: invalid diff address %s", ErrInvalidLedgerDiffExt, addr)
+		}
+	}
+
+	// the response is syntactically valid, the remaining checks are about its consistency
+	computedDiff := make(map[trinary.Hash]int64)
+	for _, tx := range r.ConfirmedTxWithValue {
+		bndl, has := bundles[tx.TailTxHash]
+		if !has {
+			return fmt.Errorf("%w: tx %s references unknown bundle with tail tx %s", ErrInconsistentLedgerDiffExt, tx.TxHash, tx.TailTxHash)
+		}
+		if bndl.BundleHash != tx.BundleHash {
+			return fmt.Errorf("%w: tx %s has bundle hash %s but its bundle has %s", ErrInconsistentLedgerDiffExt, tx.TxHash, tx.BundleHash, bndl.BundleHash)
+		}
+		bundleTx, has := bundleTxs[tx.TxHash]
+		if !has || bundleTx.Address != tx.Address || bundleTx.Value != tx.Value {
+			return fmt.Errorf("%w: tx %s does not match any tx of bundle %s", ErrInconsistentLedgerDiffExt, tx.TxHash, tx.BundleHash)
+		}
+
+		computedDiff[tx.Address] += tx.Value
+	}
+
+	if len(seenTxs) != len(bundleTxs) {
+		return fmt.Errorf("%w: bundles contain %d value txs but %d txs were confirmed", ErrInconsistentLedgerDiffExt, len(bundleTxs), len(seenTxs))
+	}
+
+	var diffSum int64
+	for addr, change := range r.Diff {
+		if computedDiff[addr] != change {
+			return fmt.Errorf("%w: diff of %s is %d but confirmed txs sum up to %d", ErrInconsistentLedgerDiffExt, addr, change, computedDiff[addr])
+		}
+		diffSum += change
+	}
+	for addr, change := range computedDiff {
+		if _, has := r.Diff[addr]; !has && change != 0 {
+			return fmt.Errorf("%w: diff misses %s with a change of %d", ErrInconsistentLedgerDiffExt, addr, change)
+		}
+	}
+	if diffSum != 0 {
+		return fmt.Errorf("%w: diff sums up to %d instead of 0", ErrInconsistentLedgerDiffExt, diffSum)
+	}
+
+	return nil
+}
+
+// Validate checks the syntactical validity of the transaction.
+func (tx *TxHashWithValue) Validate() error {
+	for _, hash := range []trinary.Hash{tx.TxHash, tx.TailTxHash, tx.BundleHash, tx.Address} {
+		if !guards.IsTrytesOfExactLength(hash, consts.HashTrytesSize) {
+			return fmt.Errorf("%w: invalid hash/address %s in tx %s", ErrInvalidLedgerDiffExt, hash, tx.TxHash)
+		}
+	}
+	if tx.Value == 0 {
+		return fmt.Errorf("%w: tx %s has no value", ErrInvalidLedgerDiffExt, tx.TxHash)
+	}
+	return nil
+}
+
+// Validate checks the syntactical validity of the bundle and that its value transactions are balanced.
+func (b *BundleWithValue) Validate() error {
+	for _, hash := range []trinary.Hash{b.BundleHash, b.TailTxHash} {
+		if !guards.IsTrytesOfExactLength(hash, consts.HashTrytesSize) {
+			return fmt.Errorf("%w: invalid hash %s in bundle %s", ErrInvalidLedgerDiffExt, hash, b.BundleHash)
+		}
+	}
+	if len(b.Txs) == 0 {
+		return fmt.Errorf("%w: bundle %s has no value txs", ErrInvalidLedgerDiffExt, b.BundleHash)
+	}
+
+	var sum int64
+	seenIndices := make(map[uint64]struct{}, len(b.Txs))
+	for _, tx := range b.Txs {
+		if tx == nil {
+			return fmt.Errorf("%w: nil tx in bundle %s", ErrInvalidLedgerDiffExt, b.BundleHash)
+		}
+		for _, hash := range []trinary.Hash{tx.TxHash, tx.Address} {
+			if !guards.IsTrytesOfExactLength(hash, consts.HashTrytesSize) {
+				return fmt.Errorf("%w: invalid hash/address %s in bundle %s", ErrInvalidLedgerDiffExt, hash, b.BundleHash)
+			}
+		}
+		if tx.Value == 0 {
+			return fmt.Errorf("%w: tx %s of bundle %s has no value", ErrInvalidLedgerDiffExt, tx.TxHash, b.BundleHash)
+		}
+		if tx.Index > b.LastIndex {
+			return fmt.Errorf("%w: tx %s of bundle %s has index %d beyond last index %d", ErrInvalidLedgerDiffExt, tx.TxHash, b.BundleHash, tx.Index, b.LastIndex)
+		}
+		if _, has := seenIndices[tx.Index]; has {
+			return fmt.Errorf("%w: bundle %s has multiple txs with index %d", ErrInvalidLedgerDiffExt, b.BundleHash, tx.Index)
+		}
+		seenIndices[tx.Index] = struct{}{}
+		if tx.Index == 0 && tx.TxHash != b.TailTxHash {
+			return fmt.Errorf("%w: tx %s at index 0 of bundle %s is not its tail tx %s", ErrInvalidLedgerDiffExt, tx.TxHash, b.BundleHash, b.TailTxHash)
+		}
+		sum += tx.Value
+	}
+
+	if sum != 0 {
+		return fmt.Errorf("%w: value txs of bundle %s sum up to %d instead of 0", ErrInvalidLedgerDiffExt, b.BundleHash, sum)
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readLedgerDiffExtFixture(t *testing.T, name string) []byte {
+	fixture, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return fixture
+}
+
+func TestDecodeLedgerDiffExtRoundTrip(t *testing.T) {
+	for _, name := range []string{"ledger_diff_ext_1337000.json", "ledger_diff_ext_1337001.json"} {
+		t.Run(name, func(t *testing.T) {
+			fixture := readLedgerDiffExtFixture(t, name)
+
+			res, err := DecodeLedgerDiffExt(bytes.NewReader(fixture))
+			require.NoError(t, err)
+
+			reEncoded, err := json.Marshal(res)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(fixture), string(reEncoded))
+		})
+	}
+}
+
+func TestDecodeLedgerDiffExtFields(t *testing.T) {
+	res, err := DecodeLedgerDiffExt(bytes.NewReader(readLedgerDiffExtFixture(t, "ledger_diff_ext_1337000.json")))
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 1337000, res.MilestoneIndex)
+	require.Len(t, res.ConfirmedTxWithValue, 5)
+	require.Len(t, res.ConfirmedBundlesWithValue, 2)
+
+	tx := res.ConfirmedTxWithValue[0]
+	assert.Equal(t, "GEJDJTLASCMYOCP9JNADEYHNKAHKPRHWDNRUUZQHQHCEJGZMQQE9HDQHKBZPDSCDQOFWVQQUGUSJBES9B", tx.TailTxHash)
+	assert.Equal(t, "TRANSFERC9J9QAXADAKARBYBEBLBSCZCFCMCTD9DGDNEHVOWVWBWIWPXWXCXJXQYXYDYKYRZTDRD99VD9", tx.Address)
+	assert.EqualValues(t, 1000000000, tx.Value)
+
+	bndl := res.ConfirmedBundlesWithValue[1]
+	assert.EqualValues(t, 3, bndl.LastIndex)
+	require.Len(t, bndl.Txs, 3)
+	assert.EqualValues(t, 3, bndl.Txs[2].Index)
+}
+
+func TestDecodeLedgerDiffExtIgnoresUnknownFields(t *testing.T) {
+	res := make(map[string]interface{})
+	require.NoError(t, json.Unmarshal(readLedgerDiffExtFixture(t, "ledger_diff_ext_1337000.json"), &res))
+	res["newField"] = 1
+	tx(res, 0)["newField"] = "A"
+
+	withUnknown, err := json.Marshal(res)
+	require.NoError(t, err)
+
+	diff, err := DecodeLedgerDiffExt(bytes.NewReader(withUnknown))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1337000, diff.MilestoneIndex)
+}
+
+func TestDecodeLedgerDiffExtInvalid(t *testing.T) {
+	var tests = []struct {
+		name    string
+		mutate  func(res map[string]interface{})
+		wantErr error
+	}{
+		{
+			name: "tx hash too short",
+			mutate: func(res map[string]interface{}) {
+				tx(res, 0)["txHash"] = "ABC"
+			},
+			wantErr: ErrInvalidLedgerDiffExt,
+		},
+		{
+			name: "address with invalid chars",
+			mutate: func(res map[string]interface{}) {
+				tx(res, 1)["address"] = "ivoodrouxtedlmptgbbidyykztoekjxrvpcxfapqugpqknqciggkfkqmjkp99qkycxrynixwmlgbbazdz"
+			},
+			wantErr: ErrInvalidLedgerDiffExt,
+		},
+		{
+			name: "tx value does not match bundle",
+			mutate: func(res map[string]interface{}) {
+				tx(res, 0)["value"] = 1
+			},
+			wantErr: ErrInconsistentLedgerDiffExt,
+		},
+		{
+			name: "tx references unknown bundle",
+			mutate: func(res map[string]interface{}) {
+				tx(res, 0)["tailTxHash"] = tx(res, 2)["tailTxHash"]
+			},
+			wantErr: ErrInconsistentLedgerDiffExt,
+		},
+		{
+			name: "tx index beyond last index",
+			mutate: func(res map[string]interface{}) {
+				bundle(res, 0)["lastIndex"] = 0
+			},
+			wantErr: ErrInvalidLedgerDiffExt,
+		},
+		{
+			name: "unbalanced bundle",
+			mutate: func(res map[string]interface{}) {
+				bundleTx(res, 1, 2)["value"] = 1
+			},
+			wantErr: ErrInvalidLedgerDiffExt,
+		},
+		{
+			name: "diff does not match txs",
+			mutate: func(res map[string]interface{}) {
+				res["diff"].(map[string]interface{})["TRANSFERC9J9QAXADAKARBYBEBLBSCZCFCMCTD9DGDNEHVOWVWBWIWPXWXCXJXQYXYDYKYRZTDRD99VD9"] = 1
+			},
+			wantErr: ErrInconsistentLedgerDiffExt,
+		},
+		{
+			name: "invalid diff address",
+			mutate: func(res map[string]interface{}) {
+				res["diff"].(map[string]interface{})["ABC"] = 0
+			},
+			wantErr: ErrInvalidLedgerDiffExt,
+		},
+		{
+			name: "missing confirmed tx",
+			mutate: func(res map[string]interface{}) {
+				res["confirmedTxWithValue"] = res["confirmedTxWithValue"].([]interface{})[:4]
+			},
+			wantErr: ErrInconsistentLedgerDiffExt,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := make(map[string]interface{})
+			require.NoError(t, json.Unmarshal(readLedgerDiffExtFixture(t, "ledger_diff_ext_1337000.json"), &res))
+			tt.mutate(res)
+
+			mutated, err := json.Marshal(res)
+			require.NoError(t, err)
+
+			_, err = DecodeLedgerDiffExt(bytes.NewReader(mutated))
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func tx(res map[string]interface{}, i int) map[string]interface{} {
+	return res["confirmedTxWithValue"].([]interface{})[i].(map[string]interface{})
+}
+
+func bundle(res map[string]interface{}, i int) map[string]interface{} {
+	return res["confirmedBundlesWithValue"].([]interface{})[i].(map[string]interface{})
+}
+
+func bundleTx(res map[string]interface{}, i int, j int) map[string]interface{} {
+	return bundle(res, i)["txs"].([]interface{})[j].(map[string]interface{})
+}
@@ -0,0 +1,137 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/iotaledger/iota.go/trinary"
+)
+
+// LedgerStateEntryFunc is called for every address/balance entry of a streamed ledger state.
+// Returning an error aborts the decoding of the ledger state.
+type LedgerStateEntryFunc func(addr trinary.Hash, balance uint64) error
+
+// StreamLedgerState queries for the ledger state at the given target LSMI and passes every balance entry
+// to the given function without holding the entire ledger in memory.
+// The returned GetLedgerStateReturn only carries the meta data of the response, its Balances are nil.
+func (c *LegacyClient) StreamLedgerState(ctx context.Context, lsmi int, entryFunc LedgerStateEntryFunc) (*GetLedgerStateReturn, error) {
+	var resObj *GetLedgerStateReturn
+	cmd := fmt.Sprintf(`{"command": "getLedgerState", "targetIndex": %d}`, lsmi)
+	if err := c.cachedQuery(ctx, c.ledgerStateHTTPClient, ledgerStateCacheKind, lsmi, cmd, func(r io.Reader) error {
+		var err error
+		resObj, err = DecodeLedgerState(r, func(addr trinary.Hash, balance uint64) error {
+			if err := entryFunc(addr, balance); err != nil {
+				return &decodeAbortError{err: err}
+			}
+			return nil
+		})
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("unable to stream ledger state: %w", err)
+	}
+	return resObj, nil
+}
+
+// DecodeLedgerState decodes a getLedgerState response from the given reader entry by entry
+// and passes every balance entry to the given function.
+// The returned GetLedgerStateReturn only carries the meta data of the response, its Balances are nil.
+func DecodeLedgerState(r io.Reader, entryFunc LedgerStateEntryFunc) (*GetLedgerStateReturn, error) {
+	dec := json.NewDecoder(r)
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	resObj := &GetLedgerStateReturn{}
+	for dec.More() {
+		key, err := decodeKey(dec)
+		if err != nil {
+			return nil, err
+		}
+
+		switch key {
+		case "balances":
+			if err := decodeBalances(dec, entryFunc); err != nil {
+				return nil, err
+			}
+		case "milestoneIndex":
+			if err := dec.Decode(&resObj.MilestoneIndex); err != nil {
+				return nil, fmt.Errorf("unable to decode milestone index: %w", err)
+			}
+		case "duration":
+			if err := dec.Decode(&resObj.Duration); err != nil {
+				return nil, fmt.Errorf("unable to decode duration: %w", err)
+			}
+		case "error":
+			var msg string
+			if err := dec.Decode(&msg); err != nil {
+				return nil, fmt.Errorf("unable to decode error message: %w", err)
+			}
+			return nil, &APIError{StatusCode: http.StatusOK, Message: msg}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, fmt.Errorf("unable to skip field %s: %w", key, err)
+			}
+		}
+	}
+
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+
+	return resObj, nil
+}
+
+// decodes the balances object of a getLedgerState response.
+func decodeBalances(dec *json.Decoder, entryFunc LedgerStateEntryFunc) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		addr, err := decodeKey(dec)
+		if err != nil {
+			return err
+		}
+
+		var balance uint64
+		if err := dec.Decode(&balance); err != nil {
+			return fmt.Errorf("unable to decode balance of %s: %w", addr, err)
+		}
+
+		if err := entryFunc(addr, balance); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, '}')
+}
+
+// decodes the next token as an object key.
+func decodeKey(dec *json.Decoder) (string, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return "", fmt.Errorf("unable to read object key: %w", err)
+	}
+	key, ok := tok.(string)
+	if !ok {
+		return "", fmt.Errorf("expected object key but got %v", tok)
+	}
+	return key, nil
+}
+
+// decodes the next token and checks whether it is the given delimiter.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("unable to read token, expected '%v': %w", delim, err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("expected '%v' but got %v", delim, tok)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package common
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeLedgerState(t *testing.T) {
+	const res = `{"balances": {"AAA": 10, "BBB": 20, "CCC": 0}, "unknown": {"a": [1, 2]}, "milestoneIndex": 1337, "duration": 42}`
+
+	balances := make(map[trinary.Hash]uint64)
+	resObj, err := DecodeLedgerState(strings.NewReader(res), func(addr trinary.Hash, balance uint64) error {
+		balances[addr] = balance
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 1337, resObj.MilestoneIndex)
+	assert.EqualValues(t, 42, resObj.Duration)
+	assert.Nil(t, resObj.Balances)
+	assert.Equal(t, map[trinary.Hash]uint64{"AAA": 10, "BBB": 20, "CCC": 0}, balances)
+}
+
+func TestDecodeLedgerStateAbort(t *testing.T) {
+	errAbort := errors.New("abort")
+
+	var seen int
+	_, err := DecodeLedgerState(strings.NewReader(`{"balances": {"AAA": 10, "BBB": 20}}`), func(_ trinary.Hash, _ uint64) error {
+		seen++
+		return errAbort
+	})
+	assert.ErrorIs(t, err, errAbort)
+	assert.Equal(t, 1, seen)
+}
+
+func TestDecodeLedgerStateErrors(t *testing.T) {
+	noop := func(_ trinary.Hash, _ uint64) error { return nil }
+
+	var apiErr *APIError
+	_, err := DecodeLedgerState(strings.NewReader(`{"error": "target index is too old"}`), noop)
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "target index is too old", apiErr.Message)
+
+	for _, res := range []string{``, `[]`, `{"balances": {"AAA": -1}}`, `{"balances": {"AAA": 10}`} {
+		_, err := DecodeLedgerState(strings.NewReader(res), noop)
+		assert.Error(t, err, res)
+	}
+}
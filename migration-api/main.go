@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
+	"time"
 
 	"github.com/iotaledger/chrysalis-tools/migration-api/migration"
 	"github.com/labstack/echo/v4"
@@ -19,8 +22,16 @@ func must(err error) {
 }
 
 func main() {
-	cfg, err := migration.ReadConfig()
+	defaultConfigFilePath := "config.json"
+	if filePath, has := os.LookupEnv(migration.ConfigEnvVar("config")); has {
+		defaultConfigFilePath = filePath
+	}
+	configFilePath := flag.String("config", defaultConfigFilePath, "the path of the config file, can also be set via "+migration.ConfigEnvVar("config"))
+	flag.Parse()
+
+	cfg, err := migration.ReadConfig(*configFilePath)
 	must(err)
+	cfg.Watch()
 
 	log.Printf("booting up server with following config: %s", cfg.JSONString())
 
@@ -28,74 +39,75 @@ func main() {
 	e.Debug = true
 	e.HideBanner = true
 
-	var services []service
-	services = append(services, migration.NewHTTPAPIService(e, cfg.SharedListenAddress, &cfg.HTTPAPIService))
-	if cfg.PromMetricsService.Enabled {
-		services = append(services, migration.NewPromMetricsService(e, &cfg.PromMetricsService))
+	index := migration.NewMigrationIndex()
+
+	var history *migration.HistoryStore
+	if len(cfg.HTTPAPIService.History.FilePath) > 0 {
+		history, err = migration.OpenHistoryStore(cfg.HTTPAPIService.History.FilePath)
+		must(err)
 	}
 
-	done := registerShutdownHooks(cfg, services...)
-	initServices(services...)
-	runServices(services...)
+	indexer := migration.NewIndexerService(&cfg.HTTPAPIService, index, history)
+	httpAPI := migration.NewHTTPAPIService(e, cfg.SharedListenAddress, &cfg.HTTPAPIService, index, history)
+	httpAPI.RegisterHealthReporter("indexerService", indexer)
 
+	// background services are restarted forever, the HTTP API is only given a few chances to recover
+	// (e.g. from its listen address being occupied) before the process exits.
+	backgroundPolicy := migration.RestartPolicy{MaxRestarts: -1, InitialBackoff: time.Second, MaxBackoff: time.Minute}
+	httpAPIPolicy := migration.RestartPolicy{MaxRestarts: 3, InitialBackoff: time.Second, MaxBackoff: 10 * time.Second, Critical: true}
+
+	supervisor := migration.NewSupervisor()
+	supervisor.Add("indexer service", indexer, backgroundPolicy)
+	if cfg.PromMetricsService.Enabled {
+		promMetrics := migration.NewPromMetricsService(e, &cfg.PromMetricsService)
+		httpAPI.RegisterHealthReporter("promMetricsService", promMetrics)
+		supervisor.Add("prometheus metrics service", promMetrics, backgroundPolicy)
+	}
+	// added last so that all routes are registered before and the server is shut down first
+	supervisor.Add("HTTP API service", httpAPI, httpAPIPolicy)
+
+	err = run(cfg, supervisor)
+	if history != nil {
+		if closeErr := history.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close history store: %w", closeErr))
+		}
+	}
+	if err != nil {
+		log.Printf("service shutdown with errors: %v", err)
+		os.Exit(1)
+	}
 	log.Println("service shutdown successfully")
-	<-done
 }
 
-// service can be initialized, run and be shut down.
-type service interface {
-	// Init inits the service.
-	Init() error
-	// Run runs the service.
-	Run() error
-	// Shutdown shuts down the service.
-	Shutdown(context.Context) error
-}
+// inits and runs the services of the given supervisor until a system signal is received or
+// a critical service failed, then shuts them down.
+func run(cfg *migration.Config, supervisor *migration.Supervisor) error {
+	if err := supervisor.Init(); err != nil {
+		return err
+	}
 
-// listen for system signals in order to shutdown the services.
-func registerShutdownHooks(cfg *migration.Config, services ...service) <-chan struct{} {
 	sigs := make(chan os.Signal, 1)
-	done := make(chan struct{}, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
+	runErr := make(chan error, 1)
 	go func() {
-		<-sigs
-		log.Printf("got sigint/sigterm. shutting down... (max await before process kill: %v)", cfg.ShutdownMaxAwait)
-
-		ctx, cancelFunc := context.WithTimeout(context.Background(), cfg.ShutdownMaxAwait)
-		defer cancelFunc()
-
-		for _, service := range services {
-			if err := service.Shutdown(ctx); err != nil {
-				log.Panic(err)
-			}
-		}
-
-		done <- struct{}{}
+		runErr <- supervisor.Run()
 	}()
 
-	return done
-}
-
-func initServices(services ...service) {
-	for _, srv := range services {
-		if err := srv.Init(); err != nil {
-			log.Panic(err)
+	var err error
+	select {
+	case <-sigs:
+		log.Printf("got sigint/sigterm. shutting down... (max await before process kill: %v)", cfg.ShutdownMaxAwait)
+	case err = <-runErr:
+		if err == nil {
+			// all services stopped by themselves
+			return nil
 		}
+		log.Printf("%v. shutting down... (max await before process kill: %v)", err, cfg.ShutdownMaxAwait)
 	}
-}
 
-// runs all given services in their own goroutine.
-func runServices(services ...service) {
-	var wg sync.WaitGroup
-	wg.Add(len(services))
-	for _, srv := range services {
-		go func(srv service) {
-			defer wg.Done()
-			if err := srv.Run(); err != nil {
-				log.Panic(err)
-			}
-		}(srv)
-	}
-	wg.Wait()
+	ctx, cancelFunc := context.WithTimeout(context.Background(), cfg.ShutdownMaxAwait)
+	defer cancelFunc()
+
+	return errors.Join(err, supervisor.Shutdown(ctx))
 }
@@ -2,25 +2,171 @@ package migration
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 )
 
-// ReadConfig reads the config.
-func ReadConfig() (*Config, error) {
-	viper.SetConfigFile("config.json")
-	if err := viper.ReadInConfig(); err != nil {
+const (
+	// the prefix of the environment variables overriding config fields.
+	configEnvPrefix = "MIGRATION_API"
+)
+
+var (
+	// ErrInvalidConfig is returned when the config contains invalid values.
+	ErrInvalidConfig = errors.New("invalid config")
+
+	// guards the fields which are reloaded while the services are running.
+	reloadMu sync.RWMutex
+)
+
+// ReadConfig reads the config from the given JSON file and validates it.
+// Every field can be overridden by an environment variable named after the path of its JSON keys,
+// e.g. MIGRATION_API_PROMMETRICSSERVICE_LEGACYNODE_URI overrides promMetricsService.legacyNode.uri.
+func ReadConfig(filePath string) (*Config, error) {
+	v := viper.New()
+	v.SetConfigFile(filePath)
+	if err := v.ReadInConfig(); err != nil {
 		return nil, err
 	}
-	c := &Config{}
-	if err := viper.Unmarshal(c); err != nil {
+	for _, key := range configKeys(reflect.TypeOf(Config{}), "") {
+		if err := v.BindEnv(key, ConfigEnvVar(key)); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &Config{v: v}
+	if err := c.unmarshal(); err != nil {
 		return nil, err
 	}
 	return c, nil
 }
 
+// ConfigEnvVar returns the name of the environment variable overriding the config field with the given key.
+func ConfigEnvVar(key string) string {
+	return configEnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// returns the keys of all fields of the given config struct type, built out of their JSON tags.
+func configKeys(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || len(name) == 0 || name == "-" {
+			continue
+		}
+
+		key := prefix + name
+		if field.Type.Kind() == reflect.Struct {
+			keys = append(keys, configKeys(field.Type, key+".")...)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// unmarshals and validates the config read by the underlying viper instance.
+func (c *Config) unmarshal() error {
+	if err := c.v.Unmarshal(c); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Watch reloads the config whenever the config file changes. Only FetchInterval of the indexer and
+// the prom metrics service and MaxMilestonesToQueryForEntries are applied at runtime, changes of
+// other fields require a restart. Invalid changes are ignored.
+func (c *Config) Watch() {
+	c.v.OnConfigChange(func(_ fsnotify.Event) {
+		reloaded := &Config{v: c.v}
+		if err := reloaded.unmarshal(); err != nil {
+			log.Printf("ignoring changed config: %v", err)
+			return
+		}
+
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+		c.HTTPAPIService.Indexer.FetchInterval = reloaded.HTTPAPIService.Indexer.FetchInterval
+		c.HTTPAPIService.MaxMilestonesToQueryForEntries = reloaded.HTTPAPIService.MaxMilestonesToQueryForEntries
+		c.PromMetricsService.FetchInterval = reloaded.PromMetricsService.FetchInterval
+		log.Printf("reloaded config: indexer fetch interval %v, prom metrics service fetch interval %v, max milestones to query for entries %d",
+			c.HTTPAPIService.Indexer.FetchInterval, c.PromMetricsService.FetchInterval, c.HTTPAPIService.MaxMilestonesToQueryForEntries)
+	})
+	c.v.WatchConfig()
+}
+
+// Validate checks that all required fields are set and all values are within their bounds.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidConfig}, args...)...))
+		}
+	}
+
+	check(len(c.SharedListenAddress) > 0, "sharedListenAddress must be set")
+	check(c.ShutdownMaxAwait > 0, "shutdownMaxAwait must be positive")
+
+	api := &c.HTTPAPIService
+	check(api.MaxMilestonesToQueryForEntries > 0 || api.Indexer.LegacyMilestoneStartIndex > 0,
+		"httpAPIService.maxMilestonesToQueryForEntries must be positive if httpAPIService.indexer.legacyMilestoneStartIndex is not set")
+	check(api.MinTokenAmountForMigration >= 0, "httpAPIService.minTokenAmountForMigration must not be negative")
+	check(api.LedgerCacheMaxLedgerStates >= 0, "httpAPIService.ledgerCacheMaxLedgerStates must not be negative")
+	check(api.Indexer.LegacyMilestoneStartIndex >= 0, "httpAPIService.indexer.legacyMilestoneStartIndex must not be negative")
+	check(api.Indexer.FetchInterval >= 0, "httpAPIService.indexer.fetchInterval must not be negative")
+	errs = append(errs, validateNodeConfig("httpAPIService.legacyNode", api.LegacyNode.Endpoints(), api.LegacyNode.Timeout, api.LegacyNode.HealthCheckInterval)...)
+	errs = append(errs, validateNodeConfig("httpAPIService.c2Node", api.C2Node.Endpoints(), api.C2Node.Timeout, api.C2Node.HealthCheckInterval)...)
+
+	if prom := &c.PromMetricsService; prom.Enabled {
+		check(prom.LegacyMilestoneStartIndex >= 0, "promMetricsService.legacyMilestoneStartIndex must not be negative")
+		check(prom.C2MilestoneStartIndex >= 0, "promMetricsService.c2MilestoneStartIndex must not be negative")
+		check(len(prom.StateFilePath) > 0, "promMetricsService.stateFilePath must be set")
+		check(prom.QueryConcurrency >= 0, "promMetricsService.queryConcurrency must not be negative")
+		check(prom.CheckpointInterval >= 0, "promMetricsService.checkpointInterval must not be negative")
+		check(prom.MaxMilestonesPerUpdate >= 0, "promMetricsService.maxMilestonesPerUpdate must not be negative")
+		check(len(prom.CounterNames.ServiceErrors) > 0, "promMetricsService.counterNames.serviceErrors must be set")
+		check(len(prom.CounterNames.IncludedLegacyTails) > 0, "promMetricsService.counterNames.includedLegacyTails must be set")
+		check(len(prom.CounterNames.AppliedReceiptEntries) > 0, "promMetricsService.counterNames.appliedReceiptEntries must be set")
+		check(prom.FetchInterval > 0, "promMetricsService.fetchInterval must be positive")
+		errs = append(errs, validateNodeConfig("promMetricsService.legacyNode", prom.LegacyNode.Endpoints(), prom.LegacyNode.Timeout, prom.LegacyNode.HealthCheckInterval)...)
+		errs = append(errs, validateNodeConfig("promMetricsService.c2Node", prom.C2Node.Endpoints(), prom.C2Node.Timeout, prom.C2Node.HealthCheckInterval)...)
+	}
+
+	return errors.Join(errs...)
+}
+
+// validates the node config under the given key.
+func validateNodeConfig(key string, uris []string, timeout time.Duration, healthCheckInterval time.Duration) []error {
+	var errs []error
+	if len(uris) == 0 {
+		errs = append(errs, fmt.Errorf("%w: %s.uri or %s.uris must be set", ErrInvalidConfig, key, key))
+	}
+	for _, uri := range uris {
+		if u, err := url.Parse(uri); err != nil || !u.IsAbs() || len(u.Host) == 0 {
+			errs = append(errs, fmt.Errorf("%w: %s contains the invalid URI '%s'", ErrInvalidConfig, key, uri))
+		}
+	}
+	if timeout <= 0 {
+		errs = append(errs, fmt.Errorf("%w: %s.timeout must be positive", ErrInvalidConfig, key))
+	}
+	if healthCheckInterval < 0 {
+		errs = append(errs, fmt.Errorf("%w: %s.healthCheckInterval must not be negative", ErrInvalidConfig, key))
+	}
+	return errs
+}
+
 type Config struct {
+	v                   *viper.Viper
 	SharedListenAddress string                   `json:"sharedListenAddress"`
 	ShutdownMaxAwait    time.Duration            `json:"shutdownMaxAwait"`
 	HTTPAPIService      HTTPAPIServiceConfig     `json:"httpAPIService"`
@@ -36,20 +182,80 @@ func (c *Config) JSONString() string {
 }
 
 type HTTPAPIServiceConfig struct {
-	MaxMilestonesToQueryForEntries int              `json:"maxMilestonesToQueryForEntries"`
-	MinTokenAmountForMigration     int              `json:"minTokenAmountForMigration"`
-	LegacyNode                     LegacyNodeConfig `json:"legacyNode"`
-	C2Node                         C2NodeConfig     `json:"c2Node"`
+	MaxMilestonesToQueryForEntries int    `json:"maxMilestonesToQueryForEntries"`
+	MinTokenAmountForMigration     int    `json:"minTokenAmountForMigration"`
+	LedgerCacheDir                 string `json:"ledgerCacheDir"`
+	// The amount of ledger states kept in the ledger cache, if zero ledger states are not cached.
+	LedgerCacheMaxLedgerStates int              `json:"ledgerCacheMaxLedgerStates"`
+	Indexer                    IndexerConfig    `json:"indexer"`
+	History                    HistoryConfig    `json:"history"`
+	LegacyNode                 LegacyNodeConfig `json:"legacyNode"`
+	C2Node                     C2NodeConfig     `json:"c2Node"`
+}
+
+// maxMilestonesToQueryForEntries returns MaxMilestonesToQueryForEntries, safe to call while the config is reloaded.
+func (c *HTTPAPIServiceConfig) maxMilestonesToQueryForEntries() int {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.MaxMilestonesToQueryForEntries
+}
+
+type IndexerConfig struct {
+	// The legacy milestone after which lockings are indexed,
+	// if zero only the last MaxMilestonesToQueryForEntries milestones are indexed.
+	LegacyMilestoneStartIndex int           `json:"legacyMilestoneStartIndex"`
+	FetchInterval             time.Duration `json:"fetchInterval"`
+}
+
+// fetchInterval returns FetchInterval, safe to call while the config is reloaded.
+func (c *IndexerConfig) fetchInterval() time.Duration {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.FetchInterval
+}
+
+type HistoryConfig struct {
+	// The file into which the history of the migration is recorded, if empty no history is recorded.
+	FilePath string `json:"filePath"`
 }
 
 type LegacyNodeConfig struct {
-	URI     string        `json:"uri"`
-	Timeout time.Duration `json:"timeout"`
+	URI  string   `json:"uri"`
+	URIs []string `json:"uris"`
+	// The timeout of the requests to the node. Not applied to getLedgerState, as downloading
+	// the entire ledger takes minutes, which is bounded by common.DefaultLegacyClientLedgerStateTimeout instead.
+	Timeout             time.Duration `json:"timeout"`
+	HealthCheckInterval time.Duration `json:"healthCheckInterval"`
+}
+
+// Endpoints returns the deduplicated union of URI and URIs.
+func (c *LegacyNodeConfig) Endpoints() []string {
+	return endpoints(c.URI, c.URIs)
 }
 
 type C2NodeConfig struct {
-	URI     string        `json:"uri"`
-	Timeout time.Duration `json:"timeout"`
+	URI                 string        `json:"uri"`
+	URIs                []string      `json:"uris"`
+	Timeout             time.Duration `json:"timeout"`
+	HealthCheckInterval time.Duration `json:"healthCheckInterval"`
+}
+
+// Endpoints returns the deduplicated union of URI and URIs.
+func (c *C2NodeConfig) Endpoints() []string {
+	return endpoints(c.URI, c.URIs)
+}
+
+func endpoints(uri string, uris []string) []string {
+	var all []string
+	seen := make(map[string]struct{})
+	for _, u := range append([]string{uri}, uris...) {
+		if _, has := seen[u]; has || len(u) == 0 {
+			continue
+		}
+		seen[u] = struct{}{}
+		all = append(all, u)
+	}
+	return all
 }
 
 type PromMetricsServiceConfig struct {
@@ -58,12 +264,34 @@ type PromMetricsServiceConfig struct {
 	LegacyMilestoneStartIndex int    `json:"legacyMilestoneStartIndex"`
 	C2MilestoneStartIndex     int    `json:"c2MilestoneStartIndex"`
 	StateFilePath             string `json:"stateFilePath"`
+	RecoverCorruptedState     bool   `json:"recoverCorruptedState"`
+	QueryConcurrency          int    `json:"queryConcurrency"`
+	CheckpointInterval        int    `json:"checkpointInterval"`
+	MaxMilestonesPerUpdate    int    `json:"maxMilestonesPerUpdate"`
 	CounterNames              struct {
 		ServiceErrors         string `json:"serviceErrors"`
 		IncludedLegacyTails   string `json:"includedLegacyTails"`
 		AppliedReceiptEntries string `json:"appliedReceiptEntries"`
+		// The names of the following metrics fall back to a default name if not configured.
+		MigratedTokens              string `json:"migratedTokens"`
+		TreasuryTokens              string `json:"treasuryTokens"`
+		LockedUnmintedTokens        string `json:"lockedUnmintedTokens"`
+		LegacyMilestoneIndex        string `json:"legacyMilestoneIndex"`
+		C2MilestoneIndex            string `json:"c2MilestoneIndex"`
+		MilestoneLag                string `json:"milestoneLag"`
+		LegacyMilestoneQueryLatency string `json:"legacyMilestoneQueryLatency"`
+		C2MilestoneQueryLatency     string `json:"c2MilestoneQueryLatency"`
+		SkippedLegacyMilestones     string `json:"skippedLegacyMilestones"`
+		SkippedC2Milestones         string `json:"skippedC2Milestones"`
 	} `json:"counterNames"`
 	FetchInterval time.Duration    `json:"fetchInterval"`
 	LegacyNode    LegacyNodeConfig `json:"legacyNode"`
 	C2Node        C2NodeConfig     `json:"c2Node"`
 }
+
+// fetchInterval returns FetchInterval, safe to call while the config is reloaded.
+func (c *PromMetricsServiceConfig) fetchInterval() time.Duration {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.FetchInterval
+}
@@ -0,0 +1,95 @@
+package migration
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `{
+  "sharedListenAddress": "0.0.0.0:8484",
+  "shutdownMaxAwait": "10s",
+  "httpAPIService": {
+    "maxMilestonesToQueryForEntries": 20,
+    "indexer": {"fetchInterval": "10s"},
+    "legacyNode": {"uri": "http://127.0.0.1:14265", "timeout": "10s"},
+    "c2Node": {"uri": "http://127.0.0.1:14266", "timeout": "10s"}
+  },
+  "promMetricsService": {
+    "enabled": false
+  }
+}`
+
+func writeTestConfig(t *testing.T, content string) string {
+	filePath := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(filePath, []byte(content), 0o644))
+	return filePath
+}
+
+func TestReadConfigEnvOverrides(t *testing.T) {
+	filePath := writeTestConfig(t, testConfig)
+
+	t.Setenv("MIGRATION_API_SHAREDLISTENADDRESS", "127.0.0.1:9000")
+	t.Setenv("MIGRATION_API_HTTPAPISERVICE_INDEXER_FETCHINTERVAL", "5s")
+	t.Setenv("MIGRATION_API_HTTPAPISERVICE_LEGACYNODE_URIS", "http://a:14265,http://b:14265")
+	t.Setenv("MIGRATION_API_PROMMETRICSSERVICE_COUNTERNAMES_SERVICEERRORS", "errors")
+
+	cfg, err := ReadConfig(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:9000", cfg.SharedListenAddress)
+	assert.Equal(t, 5*time.Second, cfg.HTTPAPIService.Indexer.FetchInterval)
+	assert.Equal(t, []string{"http://a:14265", "http://b:14265"}, cfg.HTTPAPIService.LegacyNode.URIs)
+	assert.Equal(t, "http://127.0.0.1:14265", cfg.HTTPAPIService.LegacyNode.URI)
+	assert.Equal(t, "errors", cfg.PromMetricsService.CounterNames.ServiceErrors)
+}
+
+func TestConfigValidate(t *testing.T) {
+	filePath := writeTestConfig(t, testConfig)
+
+	t.Setenv("MIGRATION_API_HTTPAPISERVICE_C2NODE_URI", "not a uri")
+	t.Setenv("MIGRATION_API_HTTPAPISERVICE_LEGACYNODE_TIMEOUT", "0s")
+	t.Setenv("MIGRATION_API_PROMMETRICSSERVICE_ENABLED", "true")
+
+	_, err := ReadConfig(filePath)
+	require.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "httpAPIService.c2Node contains the invalid URI 'not a uri'")
+	assert.Contains(t, err.Error(), "httpAPIService.legacyNode.timeout must be positive")
+	assert.Contains(t, err.Error(), "promMetricsService.stateFilePath must be set")
+	assert.Contains(t, err.Error(), "promMetricsService.counterNames.serviceErrors must be set")
+	assert.Contains(t, err.Error(), "promMetricsService.legacyNode.uri or promMetricsService.legacyNode.uris must be set")
+}
+
+func TestConfigWatch(t *testing.T) {
+	filePath := writeTestConfig(t, testConfig)
+
+	cfg, err := ReadConfig(filePath)
+	require.NoError(t, err)
+	cfg.Watch()
+
+	// invalid changes are ignored
+	require.NoError(t, os.WriteFile(filePath, []byte(`{"sharedListenAddress": ""}`), 0o644))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 10*time.Second, cfg.HTTPAPIService.Indexer.fetchInterval())
+
+	updated := strings.NewReplacer(
+		`"maxMilestonesToQueryForEntries": 20`, `"maxMilestonesToQueryForEntries": 50`,
+		`{"fetchInterval": "10s"}`, `{"fetchInterval": "1m"}`,
+		`"0.0.0.0:8484"`, `"0.0.0.0:1"`,
+	).Replace(testConfig)
+	require.NoError(t, os.WriteFile(filePath, []byte(updated), 0o644))
+
+	assert.Eventually(t, func() bool {
+		return cfg.HTTPAPIService.Indexer.fetchInterval() == time.Minute
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 50, cfg.HTTPAPIService.maxMilestonesToQueryForEntries())
+
+	// fields which require a restart are not reloaded
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	assert.Equal(t, "0.0.0.0:8484", cfg.SharedListenAddress)
+}
@@ -0,0 +1,80 @@
+package migration
+
+import (
+	"sync"
+)
+
+const (
+	// EventTypeLocked is the type of events fired for lockings confirmed by a new legacy milestone.
+	EventTypeLocked = "locked"
+	// EventTypeMinted is the type of events fired for receipts of a new C2 milestone.
+	EventTypeMinted = "minted"
+
+	// the amount of events buffered per subscriber before it is dropped.
+	eventSubscriberBufferSize = 1000
+)
+
+// Event is fired when a locking or receipt was added to the MigrationIndex.
+type Event struct {
+	// The type of the event, either EventTypeLocked or EventTypeMinted.
+	Type string
+	// The payload of the event, either *LockedFunds or *RecentReceipt.
+	Payload interface{}
+}
+
+// EventBroker distributes the events of a MigrationIndex to its subscribers.
+type EventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan *Event]struct{}
+}
+
+func newEventBroker() *EventBroker {
+	return &EventBroker{subscribers: make(map[chan *Event]struct{})}
+}
+
+// Subscribe returns a channel receiving all future events and a function to cancel the subscription.
+// The channel is closed if the subscription is cancelled or if the subscriber doesn't keep up with the events.
+func (b *EventBroker) Subscribe() (<-chan *Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan *Event, eventSubscriberBufferSize)
+	b.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.unsubscribe(ch)
+	}
+}
+
+// publishes the given events to all subscribers and drops the subscribers which can't keep up.
+func (b *EventBroker) publish(events ...*Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		for _, event := range events {
+			select {
+			case ch <- event:
+				continue
+			default:
+			}
+			b.unsubscribe(ch)
+			break
+		}
+	}
+}
+
+// removes and closes the given subscriber channel, must be called with the lock held.
+func (b *EventBroker) unsubscribe(ch chan *Event) {
+	if _, has := b.subscribers[ch]; !has {
+		return
+	}
+	delete(b.subscribers, ch)
+	close(ch)
+}
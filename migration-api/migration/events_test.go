@@ -0,0 +1,88 @@
+package migration
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventBroker(t *testing.T) {
+	index := NewMigrationIndex()
+
+	events, unsubscribe := index.Events().Subscribe()
+	locking := &LockedFunds{Funds: Funds{TailTransactionHash: testTailTxHash, Value: 5000000}, LegacyMilestoneIndex: 1}
+	index.AddLegacyMilestone(1, []*LockedFunds{locking})
+	receipt := &RecentReceipt{EmbeddedMilestoneIndex: 10, LegacyMilestoneIndex: 1}
+	index.AddReceipts(10, []*RecentReceipt{receipt}, 0)
+
+	assert.Equal(t, &Event{Type: EventTypeLocked, Payload: locking}, <-events)
+	assert.Equal(t, &Event{Type: EventTypeMinted, Payload: receipt}, <-events)
+
+	unsubscribe()
+	_, ok := <-events
+	assert.False(t, ok)
+	// unsubscribing twice is fine
+	unsubscribe()
+}
+
+func TestEventBrokerDropsSlowSubscribers(t *testing.T) {
+	broker := newEventBroker()
+	events, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	for i := 0; i <= eventSubscriberBufferSize; i++ {
+		broker.publish(&Event{Type: EventTypeLocked})
+	}
+
+	var received int
+	for range events {
+		received++
+	}
+	assert.Equal(t, eventSubscriberBufferSize, received)
+}
+
+func TestHTTPAPIServiceStreamEvents(t *testing.T) {
+	index := NewMigrationIndex()
+	httpAPI := NewHTTPAPIService(echo.New(), "", &HTTPAPIServiceConfig{}, index, nil)
+	httpAPI.e.GET("/events", httpAPI.streamEvents)
+
+	srv := httptest.NewServer(httpAPI.e)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events?types=minted", nil)
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	index.AddLegacyMilestone(1, []*LockedFunds{{LegacyMilestoneIndex: 1}})
+	index.AddReceipts(10, []*RecentReceipt{{EmbeddedMilestoneIndex: 10, LegacyMilestoneIndex: 1, Funds: []Funds{}}}, 0)
+
+	scanner := bufio.NewScanner(res.Body)
+	var lines []string
+	for len(lines) < 2 && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []string{
+		"event: minted",
+		`data: {"embeddedMilestoneIndex":10,"legacyMilestoneIndex":1,"funds":[]}`,
+	}, lines)
+
+	resBadType, err := http.Get(srv.URL + "/events?types=foo")
+	require.NoError(t, err)
+	resBadType.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resBadType.StatusCode)
+}
@@ -0,0 +1,113 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HealthReporter is implemented by services whose readiness and nodes are reported by the HTTP API.
+type HealthReporter interface {
+	// Ready returns nil if the service is ready, otherwise the reason why it isn't.
+	Ready(ctx context.Context) error
+	// NodeStatuses returns the status of the legacy and C2 nodes used by the service.
+	NodeStatuses(ctx context.Context) *ServiceNodeStatuses
+}
+
+// ServiceNodeStatuses holds the status of the legacy and C2 nodes used by a service.
+type ServiceNodeStatuses struct {
+	// The status of the legacy nodes.
+	Legacy []NodeStatus `json:"legacy"`
+	// The status of the C2 nodes.
+	C2 []NodeStatus `json:"c2"`
+}
+
+// ReadinessResponse defines the response of the /ready endpoint.
+type ReadinessResponse struct {
+	// Whether all services are ready.
+	Ready bool `json:"ready"`
+	// The reasons why services are not ready by the name of the service.
+	NotReady map[string]string `json:"notReady,omitempty"`
+}
+
+// registers the /health, /ready and /nodes routes.
+func (httpAPI *HTTPAPIService) registerHealthRoutes() {
+	httpAPI.e.GET("/health", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	httpAPI.e.GET("/ready", func(c echo.Context) error {
+		res := &ReadinessResponse{Ready: true}
+		for name, reporter := range httpAPI.healthReporters {
+			if err := reporter.Ready(c.Request().Context()); err != nil {
+				if res.NotReady == nil {
+					res.NotReady = make(map[string]string)
+				}
+				res.NotReady[name] = err.Error()
+				res.Ready = false
+			}
+		}
+
+		if !res.Ready {
+			return c.JSON(http.StatusServiceUnavailable, res)
+		}
+		return c.JSON(http.StatusOK, res)
+	})
+
+	httpAPI.e.GET("/nodes", func(c echo.Context) error {
+		nodes := make(map[string]*ServiceNodeStatuses, len(httpAPI.healthReporters))
+		for name, reporter := range httpAPI.healthReporters {
+			nodes[name] = reporter.NodeStatuses(c.Request().Context())
+		}
+		return c.JSON(http.StatusOK, nodes)
+	})
+}
+
+// Ready returns an error if none of the legacy or none of the C2 nodes is reachable.
+func (httpAPI *HTTPAPIService) Ready(ctx context.Context) error {
+	return nodePoolsReady(ctx, httpAPI.legacyNodes, httpAPI.c2Nodes)
+}
+
+// NodeStatuses returns the status of the nodes the HTTP API queries.
+func (httpAPI *HTTPAPIService) NodeStatuses(ctx context.Context) *ServiceNodeStatuses {
+	return nodePoolStatuses(ctx, httpAPI.legacyNodes, httpAPI.c2Nodes)
+}
+
+// returns an error if none of the nodes of either pool is reachable.
+func nodePoolsReady(ctx context.Context, legacyNodes *LegacyNodePool, c2Nodes *C2NodePool) error {
+	if legacyNodes == nil || c2Nodes == nil {
+		return errors.New("not initialized")
+	}
+	if !anyReachable(legacyNodes.CurrentStatuses(ctx)) {
+		return fmt.Errorf("%w: none of the legacy nodes is reachable", ErrNoNodeAvailable)
+	}
+	if !anyReachable(c2Nodes.CurrentStatuses(ctx)) {
+		return fmt.Errorf("%w: none of the C2 nodes is reachable", ErrNoNodeAvailable)
+	}
+	return nil
+}
+
+// returns the current status of the nodes of the given pools, which might be nil if not yet initialized.
+func nodePoolStatuses(ctx context.Context, legacyNodes *LegacyNodePool, c2Nodes *C2NodePool) *ServiceNodeStatuses {
+	statuses := &ServiceNodeStatuses{Legacy: make([]NodeStatus, 0), C2: make([]NodeStatus, 0)}
+	if legacyNodes != nil {
+		statuses.Legacy = legacyNodes.CurrentStatuses(ctx)
+	}
+	if c2Nodes != nil {
+		statuses.C2 = c2Nodes.CurrentStatuses(ctx)
+	}
+	return statuses
+}
+
+// tells whether any of the given nodes is reachable.
+func anyReachable(statuses []NodeStatus) bool {
+	for _, status := range statuses {
+		if status.Reachable {
+			return true
+		}
+	}
+	return false
+}
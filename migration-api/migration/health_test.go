@@ -0,0 +1,73 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveHealthRoute(t *testing.T, httpAPI *HTTPAPIService, path string, res interface{}) int {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	httpAPI.e.ServeHTTP(rec, req)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), res))
+	return rec.Code
+}
+
+func TestHealthRoutes(t *testing.T) {
+	synced := newC2InfoServer(t, true, 200)
+	down := newC2InfoServer(t, true, 300)
+	down.Close()
+
+	httpAPI := NewHTTPAPIService(echo.New(), "", &HTTPAPIServiceConfig{}, NewMigrationIndex(), nil)
+	var err error
+	httpAPI.c2Nodes, err = NewC2NodePool(&C2NodeConfig{URIs: []string{synced.URL, down.URL}, Timeout: time.Second})
+	require.NoError(t, err)
+
+	pms := NewPromMetricsService(echo.New(), &PromMetricsServiceConfig{})
+	httpAPI.RegisterHealthReporter("promMetricsService", pms)
+	httpAPI.registerHealthRoutes()
+
+	health := make(map[string]string)
+	assert.Equal(t, http.StatusOK, serveHealthRoute(t, httpAPI, "/health", &health))
+	assert.Equal(t, "ok", health["status"])
+
+	// neither the legacy node pool nor the prom metrics service are initialized
+	readiness := &ReadinessResponse{}
+	assert.Equal(t, http.StatusServiceUnavailable, serveHealthRoute(t, httpAPI, "/ready", readiness))
+	assert.False(t, readiness.Ready)
+	assert.Equal(t, "not initialized", readiness.NotReady["httpAPIService"])
+	assert.Equal(t, "waiting for the first update", readiness.NotReady["promMetricsService"])
+
+	nodes := make(map[string]*ServiceNodeStatuses)
+	assert.Equal(t, http.StatusOK, serveHealthRoute(t, httpAPI, "/nodes", &nodes))
+	require.Contains(t, nodes, "httpAPIService")
+	require.Contains(t, nodes, "promMetricsService")
+	assert.Empty(t, nodes["httpAPIService"].Legacy)
+	require.Len(t, nodes["httpAPIService"].C2, 2)
+	assert.True(t, nodes["httpAPIService"].C2[0].Reachable)
+	assert.EqualValues(t, 200, nodes["httpAPIService"].C2[0].MilestoneIndex)
+	assert.False(t, nodes["httpAPIService"].C2[1].Reachable)
+	assert.NotEmpty(t, nodes["httpAPIService"].C2[1].LastError)
+}
+
+func TestPromMetricsServiceReady(t *testing.T) {
+	pms := NewPromMetricsService(echo.New(), &PromMetricsServiceConfig{})
+	assert.Error(t, pms.Ready(context.Background()))
+
+	pms.legacyBacklog.Store(10)
+	pms.c2Backlog.Store(0)
+	err := pms.Ready(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, "catching up: 10 legacy and 0 C2 milestones behind", err.Error())
+
+	pms.legacyBacklog.Store(0)
+	assert.NoError(t, pms.Ready(context.Background()))
+}
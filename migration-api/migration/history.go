@@ -0,0 +1,169 @@
+package migration
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTooManyHistoryPoints is returned when a history query would return more than MaxHistoryPoints points.
+	ErrTooManyHistoryPoints = errors.New("too many history points")
+)
+
+const (
+	// MaxHistoryPoints is the max amount of points returned by a single history query.
+	MaxHistoryPoints = 10000
+)
+
+// HistoryPoint holds the state of the migration after a legacy or C2 milestone was indexed.
+// A point mixes the state of both networks: the indexing of a legacy milestone only updates LegacyMilestoneIndex and
+// LegacyFundsLocked and the indexing of a C2 milestone only updates C2MilestoneIndex, TreasuryTokens and TokensMigrated,
+// the values of the other network are carried forward from the previous point. Time is the timestamp of the milestone
+// which was indexed, so consecutive points can alternate between legacy and C2 milestone timestamps.
+type HistoryPoint struct {
+	// The timestamp of the milestone whose indexing recorded the point.
+	Time time.Time `json:"time"`
+	// The last indexed legacy milestone.
+	LegacyMilestoneIndex uint32 `json:"legacyMilestoneIndex"`
+	// The last indexed C2 milestone.
+	C2MilestoneIndex uint32 `json:"c2MilestoneIndex"`
+	// The tokens residing in the treasury as of C2MilestoneIndex.
+	TreasuryTokens uint64 `json:"treasuryTokens"`
+	// The tokens which left the treasury as of C2MilestoneIndex.
+	TokensMigrated uint64 `json:"tokensMigrated"`
+	// The funds residing on migration addresses on the legacy network as of LegacyMilestoneIndex.
+	LegacyFundsLocked LegacyFundsLocked `json:"legacyFundsLocked"`
+}
+
+// OpenHistoryStore opens the HistoryStore persisted as JSON lines at the given path, the file is created if it doesn't exist.
+// A truncated last line, as left behind by a crash, is discarded.
+func OpenHistoryStore(filePath string) (*HistoryStore, error) {
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open history file: %w", err)
+	}
+
+	store := &HistoryStore{file: file}
+
+	var validSize int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// a line without a newline was not completely written
+			break
+		}
+		if err != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("unable to read history file: %w", err)
+		}
+
+		point := &HistoryPoint{}
+		if err := json.Unmarshal(line, point); err != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("unable to parse history point at offset %d: %w", validSize, err)
+		}
+		store.points = append(store.points, point)
+		validSize += int64(len(line))
+	}
+
+	store.byTime = append(make([]*HistoryPoint, 0, len(store.points)), store.points...)
+	sort.SliceStable(store.byTime, func(i, j int) bool { return store.byTime[i].Time.Before(store.byTime[j].Time) })
+
+	if err := file.Truncate(validSize); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("unable to truncate history file: %w", err)
+	}
+	if _, err := file.Seek(validSize, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("unable to seek history file: %w", err)
+	}
+
+	return store, nil
+}
+
+// HistoryStore persists the state of the migration over time.
+type HistoryStore struct {
+	mu     sync.RWMutex
+	file   *os.File
+	points []*HistoryPoint
+	// the points in ascending time order. as every network's points carry the timestamps of its milestones,
+	// points of both networks are not necessarily appended in time order.
+	byTime []*HistoryPoint
+}
+
+// Append appends the given point to the store and syncs it to disk before it is served.
+func (s *HistoryStore) Append(point *HistoryPoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	line, err := json.Marshal(point)
+	if err != nil {
+		return fmt.Errorf("unable to serialize history point: %w", err)
+	}
+
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("unable to write history point: %w", err)
+	}
+	// without syncing, a power loss could drop points which were already served
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("unable to sync history point: %w", err)
+	}
+
+	s.points = append(s.points, point)
+
+	// points with an equal time stay in the order in which they were appended
+	i := sort.Search(len(s.byTime), func(i int) bool { return s.byTime[i].Time.After(point.Time) })
+	s.byTime = append(s.byTime, nil)
+	copy(s.byTime[i+1:], s.byTime[i:])
+	s.byTime[i] = point
+	return nil
+}
+
+// Last returns the last appended point or nil if the store is empty.
+func (s *HistoryStore) Last() *HistoryPoint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.points) == 0 {
+		return nil
+	}
+	return s.points[len(s.points)-1]
+}
+
+// Range returns the points recorded within [from, to]. If resolution is greater than zero,
+// only the last point of every resolution sized time slot is returned.
+func (s *HistoryStore) Range(from time.Time, to time.Time, resolution time.Duration) ([]*HistoryPoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	start := sort.Search(len(s.byTime), func(i int) bool { return !s.byTime[i].Time.Before(from) })
+	end := sort.Search(len(s.byTime), func(i int) bool { return s.byTime[i].Time.After(to) })
+
+	points := make([]*HistoryPoint, 0)
+	for i := start; i < end; i++ {
+		if resolution > 0 && i+1 < end && s.byTime[i].Time.Truncate(resolution).Equal(s.byTime[i+1].Time.Truncate(resolution)) {
+			// a later point of the same slot follows
+			continue
+		}
+		if len(points) == MaxHistoryPoints {
+			return nil, fmt.Errorf("%w: the range contains more than %d points, use a shorter range or a coarser resolution", ErrTooManyHistoryPoints, MaxHistoryPoints)
+		}
+		points = append(points, s.byTime[i])
+	}
+	return points, nil
+}
+
+// Close closes the underlying file.
+func (s *HistoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
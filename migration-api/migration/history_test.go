@@ -0,0 +1,104 @@
+package migration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryStore(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "history.jsonl")
+
+	store, err := OpenHistoryStore(filePath)
+	require.NoError(t, err)
+	assert.Nil(t, store.Last())
+
+	start := time.Date(2021, 4, 28, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		require.NoError(t, store.Append(&HistoryPoint{
+			Time:                 start.Add(time.Duration(i) * 30 * time.Minute),
+			LegacyMilestoneIndex: uint32(i + 1),
+		}))
+	}
+	require.NoError(t, store.Close())
+
+	// simulate a crash while writing a point
+	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"time": "2021-04-28T03:00:00Z", "legacyMileston`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	store, err = OpenHistoryStore(filePath)
+	require.NoError(t, err)
+	require.EqualValues(t, 6, store.Last().LegacyMilestoneIndex)
+
+	require.NoError(t, store.Append(&HistoryPoint{Time: start.Add(3 * time.Hour), LegacyMilestoneIndex: 7}))
+
+	msIndexes := func(points []*HistoryPoint) []uint32 {
+		indexes := make([]uint32, len(points))
+		for i, point := range points {
+			indexes[i] = point.LegacyMilestoneIndex
+		}
+		return indexes
+	}
+
+	points, err := store.Range(time.Time{}, start.Add(24*time.Hour), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2, 3, 4, 5, 6, 7}, msIndexes(points))
+
+	points, err = store.Range(start.Add(30*time.Minute), start.Add(2*time.Hour), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2, 3, 4, 5}, msIndexes(points))
+
+	// last point of every hour
+	points, err = store.Range(time.Time{}, start.Add(24*time.Hour), time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2, 4, 6, 7}, msIndexes(points))
+
+	// the reopened file only contains complete lines
+	require.NoError(t, store.Close())
+	store, err = OpenHistoryStore(filePath)
+	require.NoError(t, err)
+	defer store.Close()
+	assert.EqualValues(t, 7, store.Last().LegacyMilestoneIndex)
+}
+
+func TestHistoryStoreOutOfOrderPoints(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "history.jsonl")
+	store, err := OpenHistoryStore(filePath)
+	require.NoError(t, err)
+
+	// the points of the C2 network lag behind the ones of the legacy network
+	start := time.Date(2021, 4, 28, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, store.Append(&HistoryPoint{Time: start.Add(2 * time.Hour), LegacyMilestoneIndex: 1}))
+	require.NoError(t, store.Append(&HistoryPoint{Time: start, LegacyMilestoneIndex: 1, C2MilestoneIndex: 1}))
+	require.NoError(t, store.Append(&HistoryPoint{Time: start.Add(time.Hour), LegacyMilestoneIndex: 1, C2MilestoneIndex: 2}))
+	assert.EqualValues(t, 2, store.Last().C2MilestoneIndex)
+
+	c2Indexes := func(points []*HistoryPoint) []uint32 {
+		indexes := make([]uint32, len(points))
+		for i, point := range points {
+			indexes[i] = point.C2MilestoneIndex
+		}
+		return indexes
+	}
+
+	points, err := store.Range(time.Time{}, start.Add(24*time.Hour), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2, 0}, c2Indexes(points))
+
+	// the reopened store keeps the order
+	require.NoError(t, store.Close())
+	store, err = OpenHistoryStore(filePath)
+	require.NoError(t, err)
+	defer store.Close()
+	assert.EqualValues(t, 2, store.Last().C2MilestoneIndex)
+	points, err = store.Range(time.Time{}, start.Add(24*time.Hour), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2, 0}, c2Indexes(points))
+}
@@ -2,24 +2,31 @@ package migration
 
 import (
 	"context"
-	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"math"
 	"net/http"
-	"sort"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/iotaledger/chrysalis-tools/common"
-	"github.com/iotaledger/iota.go/address"
-	"github.com/iotaledger/iota.go/api"
 	"github.com/iotaledger/iota.go/consts"
-	"github.com/iotaledger/iota.go/encoding/b1t6"
-	"github.com/iotaledger/iota.go/v2"
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	// the max amount of entries of a single page.
+	maxPageSize = 1000
+	// the header containing the cursor of the next page.
+	headerNextCursor = "X-Next-Cursor"
+	// the standard header carrying warnings about the completeness of a response.
+	headerWarning = "Warning"
+	// the interval in which comments are sent on idle event streams to keep the connection open.
+	eventStreamKeepAliveInterval = 15 * time.Second
+)
+
 // StateResponse contains the information of a /state response.
 type StateResponse struct {
 	TreasuryTokens    uint64            `json:"treasuryTokens"`
@@ -48,9 +55,20 @@ type RecentReceipt struct {
 	Funds                  []Funds `json:"funds"`
 }
 
-// NewHTTPAPIService creates a new HTTPAPIService.
-func NewHTTPAPIService(e *echo.Echo, listenAddr string, cfg *HTTPAPIServiceConfig) *HTTPAPIService {
-	return &HTTPAPIService{cfg: cfg, listenAddr: listenAddr, e: e}
+// NewHTTPAPIService creates a new HTTPAPIService which answers queries about lockings and receipts
+// out of the given MigrationIndex and about the progress of the migration out of the given HistoryStore (which might be nil).
+func NewHTTPAPIService(e *echo.Echo, listenAddr string, cfg *HTTPAPIServiceConfig, index *MigrationIndex, history *HistoryStore) *HTTPAPIService {
+	httpAPI := &HTTPAPIService{
+		cfg:             cfg,
+		listenAddr:      listenAddr,
+		e:               e,
+		index:           index,
+		history:         history,
+		healthReporters: make(map[string]HealthReporter),
+		shutdown:        make(chan struct{}),
+	}
+	httpAPI.RegisterHealthReporter("httpAPIService", httpAPI)
+	return httpAPI
 }
 
 // HTTPAPIService serves an API to query for migration related data.
@@ -58,195 +76,185 @@ type HTTPAPIService struct {
 	cfg        *HTTPAPIServiceConfig
 	e          *echo.Echo
 	listenAddr string
+	index      *MigrationIndex
+	history    *HistoryStore
+	shutdown   chan struct{}
+
+	legacyNodes *LegacyNodePool
+	c2Nodes     *C2NodePool
+	// verifies the receipts for /receipts/integrity, keeping the results of the already verified ones.
+	receiptVerifier *ReceiptVerifier
+	// the services reported by /ready and /nodes by their name.
+	healthReporters map[string]HealthReporter
 }
 
-// Init does nothing.
+// Init creates the node pools of the service and registers the routes of the API.
 func (httpAPI *HTTPAPIService) Init() error {
-	return nil
-}
-
-// Run starts the API.
-func (httpAPI *HTTPAPIService) Run() error {
-	log.Println("running HTTP API service")
-
-	// create APIs
-	legacyAPI, err := api.ComposeAPI(api.HTTPClientSettings{
-		URI: httpAPI.cfg.LegacyNode.URI,
-		Client: &http.Client{
-			Timeout: httpAPI.cfg.LegacyNode.Timeout,
-		},
-	})
+	legacyClientOpts, err := ledgerCacheOptions(httpAPI.cfg)
 	if err != nil {
-		return fmt.Errorf("unable to build legacy API: %w", err)
+		return err
 	}
 
-	c2API := iotago.NewNodeHTTPAPIClient(httpAPI.cfg.C2Node.URI,
-		iotago.WithNodeHTTPAPIClientHTTPClient(&http.Client{Timeout: httpAPI.cfg.C2Node.Timeout}),
-	)
+	httpAPI.legacyNodes, err = NewLegacyNodePool(&httpAPI.cfg.LegacyNode, legacyClientOpts...)
+	if err != nil {
+		return fmt.Errorf("unable to build legacy node pool: %w", err)
+	}
 
-	httpAPI.e.GET("/state", func(c echo.Context) error {
+	httpAPI.c2Nodes, err = NewC2NodePool(&httpAPI.cfg.C2Node)
+	if err != nil {
+		return fmt.Errorf("unable to build C2 node pool: %w", err)
+	}
 
-		state := &StateResponse{}
+	httpAPI.receiptVerifier = NewReceiptVerifier(httpAPI.legacyNodes, httpAPI.c2Nodes)
+	httpAPI.registerRoutes()
+	return nil
+}
 
-		treasuryRes, err := c2API.Treasury(context.Background())
-		switch {
-		case err == nil:
-			state.TreasuryTokens = treasuryRes.Amount
-			state.TokensMigrated = consts.TotalSupply - treasuryRes.Amount
-		default:
-			state.TreasuryTokens = consts.TotalSupply
-		}
+// RegisterHealthReporter registers a service whose readiness and nodes are reported by /ready and /nodes
+// under the given name. Must be called before Init.
+func (httpAPI *HTTPAPIService) RegisterHealthReporter(name string, reporter HealthReporter) {
+	httpAPI.healthReporters[name] = reporter
+}
 
-		legacyNodeInfo, err := legacyAPI.GetNodeInfo()
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("unable to query node info from legacy node: %v", err))
-		}
+// registers the routes of the API, which must happen before the server is started.
+func (httpAPI *HTTPAPIService) registerRoutes() {
+	httpAPI.registerHealthRoutes()
 
-		ledgerQueryRes, err := common.QueryLedgerState(httpAPI.cfg.LegacyNode.URI, int(legacyNodeInfo.LatestSolidSubtangleMilestoneIndex))
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("unable to query ledger state from legacy node for milestone %d: %v", legacyNodeInfo.LatestSolidSubtangleMilestoneIndex, err))
+	httpAPI.e.GET("/state", func(c echo.Context) error {
+		treasuryTokens, ok := httpAPI.index.Treasury()
+		if !ok {
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "the treasury is not indexed yet")
 		}
 
-		var totalLocked uint64
-		for addr, balance := range ledgerQueryRes.Balances {
-			if balance < uint64(httpAPI.cfg.MinTokenAmountForMigration) {
-				continue
-			}
-
-			if _, err := address.ParseMigrationAddress(addr); err != nil {
-				continue
-			}
-			totalLocked += balance
-			state.LegacyFundsLocked.MigratedAddressesTotal++
+		locked, _, ok := httpAPI.index.LegacyFundsLocked()
+		if !ok {
+			legacyIndex, _ := httpAPI.index.MilestoneIndexes()
+			return echo.NewHTTPError(http.StatusServiceUnavailable, fmt.Sprintf("the locked funds are not indexed yet, indexed up to legacy milestone %d", legacyIndex))
 		}
 
-		state.LegacyFundsLocked.TokensTotal = totalLocked
-		state.LegacyFundsLocked.TokensPercentageOfTotalSupply = math.Floor((float64(totalLocked)/float64(consts.TotalSupply))*100) / 100
-
-		return c.JSON(http.StatusOK, state)
+		return c.JSON(http.StatusOK, &StateResponse{
+			TreasuryTokens:    treasuryTokens,
+			TokensMigrated:    consts.TotalSupply - treasuryTokens,
+			LegacyFundsLocked: locked,
+		})
 	})
 
 	httpAPI.e.GET("/receipts/integrity", func(c echo.Context) error {
-		receipts, err := c2API.Receipts(context.Background())
+		report, err := httpAPI.receiptVerifier.Verify(c.Request().Context())
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("unable to retrieve receipts from C2 node: %v", err))
+			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("unable to verify receipts: %v", err))
 		}
 
-		seenTailTx := make(map[string]struct{})
-		for _, receipt := range receipts {
-			for _, seri := range receipt.Receipt.Funds {
-				entry := seri.(*iotago.MigratedFundsEntry)
-				if _, seen := seenTailTx[string(entry.TailTransactionHash[:])]; seen {
-					tailTxTrytes := b1t6.EncodeToTrytes(entry.TailTransactionHash[:])
-					tailTxHex := hex.EncodeToString(entry.TailTransactionHash[:])
-					return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("tail tx %s / %s was migrated multiple times", tailTxTrytes, tailTxHex))
-				}
-				seenTailTx[string(entry.TailTransactionHash[:])] = struct{}{}
-			}
+		if !report.OK {
+			return c.JSON(http.StatusInternalServerError, report)
 		}
-
-		return c.String(http.StatusOK, "integrity ok")
+		return c.JSON(http.StatusOK, report)
 	})
 
 	httpAPI.e.GET("/recentlyLocked/:numEntries", func(c echo.Context) error {
-		numEntriesWanted, err := strconv.Atoi(c.Param("numEntries"))
+		query, err := parsePageQuery(c, "numEntries")
 		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unable to parse numEntries parameter: %v", err))
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		lockingsCount, _ := httpAPI.index.Counts()
+		if err := validateCursor(query, lockingsCount); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 
-		// use the latest solid milestone as a base and then go back a max amount of milestones to collect entries
-		nodeInfo, err := legacyAPI.GetNodeInfo()
+		lockings, next := httpAPI.index.RecentlyLocked(query)
+		setNextCursor(c, next)
+		httpAPI.setPrunedWarning(c)
+		return c.JSON(http.StatusOK, lockings)
+	})
+
+	httpAPI.e.GET("/recentlyMinted/:numReceipts", func(c echo.Context) error {
+		query, err := parsePageQuery(c, "numReceipts")
 		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unable to parse numEntries parameter: %v", err))
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
-
-		target := nodeInfo.LatestSolidSubtangleMilestoneIndex - int64(httpAPI.cfg.MaxMilestonesToQueryForEntries)
-		switch {
-		case target < 0:
-			target = 0
+		_, receiptsCount := httpAPI.index.Counts()
+		if err := validateCursor(query, receiptsCount); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 
-		// we can't check for the pruning index since it is not part of the legacy node info
-		var funds []Funds
+		receipts, next := httpAPI.index.RecentlyMinted(query)
+		setNextCursor(c, next)
+		return c.JSON(http.StatusOK, receipts)
+	})
 
-	out:
-		for msIndex := nodeInfo.LatestSolidSubtangleMilestoneIndex; msIndex > target; msIndex-- {
-			res, err := common.QueryLedgerDiffExtended(httpAPI.cfg.LegacyNode.URI, int(msIndex))
-			if err != nil {
-				return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("unable to extended ledger diff for milestone %d: %v", msIndex, err))
-			}
+	httpAPI.e.GET("/migrations/:tailTxHash", func(c echo.Context) error {
+		tailTxHash, err := ParseTailTransactionHash(c.Param("tailTxHash"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unable to parse tailTxHash parameter: %v", err))
+		}
 
-			for _, tx := range res.ConfirmedTxWithValue {
-				if tx.Value <= 0 {
-					continue
-				}
-
-				edAddr, err := address.ParseMigrationAddress(tx.Address)
-				if err != nil {
-					continue
-				}
-
-				funds = append(funds, Funds{
-					TailTransactionHash:  tx.TailTxHash,
-					Value:                uint64(tx.Value),
-					TargetEd25519Address: hex.EncodeToString(edAddr[:]),
-				})
-
-				if len(funds) == numEntriesWanted {
-					break out
-				}
-			}
+		httpAPI.setPrunedWarning(c)
+		status, found := httpAPI.index.MigrationStatus(tailTxHash)
+		if !found {
+			legacyIndex, c2Index := httpAPI.index.MilestoneIndexes()
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("no migration with tail tx %s found up to legacy milestone %d and C2 milestone %d", tailTxHash, legacyIndex, c2Index))
 		}
 
-		return c.JSON(http.StatusOK, funds)
+		return c.JSON(http.StatusOK, status)
 	})
 
-	httpAPI.e.GET("/recentlyMinted/:numReceipts", func(c echo.Context) error {
-		receipts, err := c2API.Receipts(context.Background())
+	httpAPI.e.GET("/addresses/:ed25519Address/migrations", func(c echo.Context) error {
+		ed25519Address, err := ParseEd25519Address(c.Param("ed25519Address"))
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("unable to retrieve receipts from C2 node: %v", err))
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unable to parse ed25519Address parameter: %v", err))
 		}
 
-		numReceiptsWanted, err := strconv.Atoi(c.Param("numReceipts"))
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unable to parse numReceipts parameter: %v", err))
-		}
+		httpAPI.setPrunedWarning(c)
+		return c.JSON(http.StatusOK, httpAPI.index.AddressMigrations(ed25519Address))
+	})
 
-		if len(receipts) == 0 {
-			return c.JSON(http.StatusOK, make([]*RecentReceipt, 0))
+	httpAPI.e.GET("/reconciliation", func(c echo.Context) error {
+		if _, ok := httpAPI.index.Treasury(); !ok {
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "the treasury is not indexed yet")
 		}
 
-		recentReceipts := make([]*RecentReceipt, numReceiptsWanted)
-		sort.Slice(receipts, func(i, j int) bool {
-			return receipts[i].MilestoneIndex > receipts[j].MilestoneIndex
-		})
+		httpAPI.setPrunedWarning(c)
+		return c.JSON(http.StatusOK, httpAPI.index.Reconcile())
+	})
+
+	httpAPI.e.GET("/events", httpAPI.streamEvents)
 
-		if numReceiptsWanted > len(receipts) {
-			numReceiptsWanted = len(receipts)
+	httpAPI.e.GET("/history", func(c echo.Context) error {
+		if httpAPI.history == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "history recording is disabled")
 		}
 
-		for i := 0; i < numReceiptsWanted; i++ {
-			recentReceipts[i] = &RecentReceipt{
-				EmbeddedMilestoneIndex: receipts[i].MilestoneIndex,
-				LegacyMilestoneIndex:   receipts[i].Receipt.MigratedAt,
-				Funds: func() []Funds {
-					funds := make([]Funds, len(receipts[i].Receipt.Funds))
-					for j, f := range receipts[i].Receipt.Funds {
-						entry := f.(*iotago.MigratedFundsEntry)
-						addr := entry.Address.(*iotago.Ed25519Address)
-						funds[j] = Funds{
-							TailTransactionHash:  hex.EncodeToString(entry.TailTransactionHash[:]),
-							Value:                entry.Deposit,
-							TargetEd25519Address: hex.EncodeToString(addr[:]),
-						}
-					}
-					return funds
-				}(),
+		from, err := parseTimeParam(c, "from", time.Time{})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		to, err := parseTimeParam(c, "to", time.Now())
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		var resolution time.Duration
+		if res := c.QueryParam("resolution"); len(res) > 0 {
+			if resolution, err = time.ParseDuration(res); err != nil || resolution < 0 {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid resolution '%s'", res))
 			}
 		}
 
-		return c.JSON(http.StatusOK, recentReceipts)
+		points, err := httpAPI.history.Range(from, to, resolution)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, points)
 	})
+}
+
+// Run starts the API.
+func (httpAPI *HTTPAPIService) Run() error {
+	log.Println("running HTTP API service")
+
+	if httpAPI.legacyNodes == nil {
+		panic("Init() must be called before Run()")
+	}
 
 	if err := httpAPI.e.Start(httpAPI.listenAddr); err != nil {
 		if errors.Is(err, http.ErrServerClosed) {
@@ -258,9 +266,156 @@ func (httpAPI *HTTPAPIService) Run() error {
 	return nil
 }
 
+// streams the events of the index as server-sent events until the client disconnects.
+// the optional types query parameter restricts the stream to a comma separated list of event types.
+func (httpAPI *HTTPAPIService) streamEvents(c echo.Context) error {
+	wantedTypes := map[string]bool{EventTypeLocked: true, EventTypeMinted: true}
+	if types := c.QueryParam("types"); len(types) > 0 {
+		wantedTypes = make(map[string]bool)
+		for _, eventType := range strings.Split(types, ",") {
+			if eventType != EventTypeLocked && eventType != EventTypeMinted {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown event type '%s'", eventType))
+			}
+			wantedTypes[eventType] = true
+		}
+	}
+
+	events, unsubscribe := httpAPI.index.Events().Subscribe()
+	defer unsubscribe()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	keepAlive := time.NewTicker(eventStreamKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-httpAPI.shutdown:
+			return nil
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+		case event, ok := <-events:
+			if !ok {
+				// the client didn't keep up and has to reconnect
+				return nil
+			}
+			if !wantedTypes[event.Type] {
+				continue
+			}
+			data, err := json.Marshal(event.Payload)
+			if err != nil {
+				return nil
+			}
+			if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return nil
+			}
+		}
+		res.Flush()
+	}
+}
+
+// parses the given query parameter as either a RFC 3339 time or unix seconds, returns def if it is missing.
+func parseTimeParam(c echo.Context, name string, def time.Time) (time.Time, error) {
+	value := c.QueryParam(name)
+	if len(value) == 0 {
+		return def, nil
+	}
+	if unixSeconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unixSeconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to parse %s parameter as RFC 3339 time or unix seconds: %w", name, err)
+	}
+	return t, nil
+}
+
+// returns the percentage of the total supply the given amount of tokens make up.
+func percentageOfTotalSupply(tokens uint64) float64 {
+	return math.Floor((float64(tokens)/float64(consts.TotalSupply))*100) / 100
+}
+
+// parses the page size out of the given path parameter and the cursor and milestone bounds out of the query parameters.
+func parsePageQuery(c echo.Context, sizeParam string) (PageQuery, error) {
+	query := PageQuery{Cursor: -1}
+
+	var err error
+	if query.Size, err = strconv.Atoi(c.Param(sizeParam)); err != nil {
+		return query, fmt.Errorf("unable to parse %s parameter: %w", sizeParam, err)
+	}
+	if query.Size < 1 || query.Size > maxPageSize {
+		return query, fmt.Errorf("%s parameter must be between 1 and %d", sizeParam, maxPageSize)
+	}
+
+	if cursor := c.QueryParam("cursor"); len(cursor) > 0 {
+		if query.Cursor, err = strconv.Atoi(cursor); err != nil || query.Cursor < 0 {
+			return query, fmt.Errorf("invalid cursor '%s'", cursor)
+		}
+	}
+
+	for name, bound := range map[string]*uint32{"fromMilestone": &query.FromMilestone, "toMilestone": &query.ToMilestone} {
+		value := c.QueryParam(name)
+		if len(value) == 0 {
+			continue
+		}
+		msIndex, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return query, fmt.Errorf("unable to parse %s parameter: %w", name, err)
+		}
+		*bound = uint32(msIndex)
+	}
+
+	if query.ToMilestone > 0 && query.FromMilestone > query.ToMilestone {
+		return query, fmt.Errorf("fromMilestone %d is after toMilestone %d", query.FromMilestone, query.ToMilestone)
+	}
+
+	return query, nil
+}
+
+// checks that the cursor of the given query points to one of the given amount of entries.
+// As the index only grows, a cursor which is valid here stays valid for the query of the page.
+func validateCursor(query PageQuery, entries int) error {
+	if query.Cursor >= entries {
+		return fmt.Errorf("cursor %d is out of range, there are %d entries", query.Cursor, entries)
+	}
+	return nil
+}
+
+// sets the cursor of the next page as a header, if there is one.
+func setNextCursor(c echo.Context, next int) {
+	if next < 0 {
+		return
+	}
+	c.Response().Header().Set(headerNextCursor, strconv.Itoa(next))
+}
+
+// sets a warning header listing the legacy milestones missing from the index, if there are any.
+func (httpAPI *HTTPAPIService) setPrunedWarning(c echo.Context) {
+	pruned := httpAPI.index.PrunedLegacyMilestones()
+	if len(pruned) == 0 {
+		return
+	}
+	ranges := make([]string, len(pruned))
+	for i, r := range pruned {
+		ranges[i] = fmt.Sprintf("%d-%d", r.From, r.To)
+	}
+	c.Response().Header().Set(headerWarning, fmt.Sprintf(`199 - "legacy milestones %s are pruned on all legacy nodes or inconsistent and missing from the index"`, strings.Join(ranges, ", ")))
+}
+
 // Shutdown shuts down the service.
 func (httpAPI *HTTPAPIService) Shutdown(ctx context.Context) error {
 	log.Println("shutting down HTTP API service...")
+	// event streams never become idle and would block the server shutdown
+	close(httpAPI.shutdown)
 	if err := httpAPI.e.Shutdown(ctx); err != nil {
 		return err
 	}
@@ -0,0 +1,90 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/iotaledger/iota.go/consts"
+	iotago "github.com/iotaledger/iota.go/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPAPIServiceStateFromIndex(t *testing.T) {
+	legacyNode := newTestLegacyNode(t, fixtureMilestoneIndex)
+	var cmi atomic.Uint32
+	cmi.Store(12)
+	c2Node := newTestReceiptsNode(t, &cmi, []*iotago.ReceiptTuple{newTestReceipt(5, 1000000)})
+	idxr := newTestIndexerService(t, &HTTPAPIServiceConfig{
+		MaxMilestonesToQueryForEntries: 2,
+		LegacyNode:                     LegacyNodeConfig{URI: legacyNode.URL},
+		C2Node:                         C2NodeConfig{URI: c2Node.URL},
+	})
+
+	// the nodes of the HTTP API service must not be queried to answer /state
+	var nodeCalls atomic.Int32
+	apiNode := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nodeCalls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer apiNode.Close()
+
+	httpAPI := NewHTTPAPIService(echo.New(), "", &HTTPAPIServiceConfig{
+		LegacyNode: LegacyNodeConfig{URI: apiNode.URL},
+		C2Node:     C2NodeConfig{URI: apiNode.URL},
+	}, idxr.index, nil)
+	require.NoError(t, httpAPI.Init())
+	srv := httptest.NewServer(httpAPI.e)
+	defer srv.Close()
+
+	// nothing is indexed yet
+	res, err := http.Get(srv.URL + "/state")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+
+	// the balances are loaded at the first indexed milestone and the fixture's diff is applied onto them
+	require.NoError(t, idxr.update(context.Background()))
+	assert.EqualValues(t, 1, legacyNode.ledgerStateCalls.Load())
+
+	for i := 0; i < 2; i++ {
+		res, err := http.Get(srv.URL + "/state")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		state := &StateResponse{}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(state))
+		res.Body.Close()
+
+		assert.EqualValues(t, 1000000000, state.TreasuryTokens)
+		assert.EqualValues(t, consts.TotalSupply-1000000000, state.TokensMigrated)
+		// the ledger diff fixture locks funds on two migration addresses
+		assert.EqualValues(t, 1005000000, state.LegacyFundsLocked.TokensTotal)
+		assert.EqualValues(t, 2, state.LegacyFundsLocked.MigratedAddressesTotal)
+	}
+
+	assert.Zero(t, nodeCalls.Load())
+	assert.EqualValues(t, 1, legacyNode.ledgerStateCalls.Load())
+}
+
+func TestTreasuryAt(t *testing.T) {
+	receipts := []*iotago.ReceiptTuple{newTestReceipt(5, 1000000), newTestReceipt(9, 2000000)}
+
+	// the treasury before the first receipt includes its deposit
+	treasury, err := treasuryAt(4, receipts, 1000000000)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1001000000, treasury)
+
+	treasury, err = treasuryAt(5, receipts, 1000000000)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1002000000, treasury)
+
+	treasury, err = treasuryAt(9, receipts, 1000000000)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1000000000, treasury)
+}
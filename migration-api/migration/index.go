@@ -0,0 +1,456 @@
+package migration
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/iotaledger/iota.go/consts"
+	"github.com/iotaledger/iota.go/encoding/b1t6"
+	"github.com/iotaledger/iota.go/encoding/t5b1"
+	"github.com/iotaledger/iota.go/guards"
+	"github.com/iotaledger/iota.go/trinary"
+	iotago "github.com/iotaledger/iota.go/v2"
+)
+
+const (
+	// the length of a T5B1 encoded legacy tail transaction hash.
+	legacyTailTxHashLength = len(iotago.LegacyTailTransactionHash{})
+)
+
+var (
+	// ErrInvalidTailTransactionHash is returned when a tail transaction hash is neither in trytes, hex nor b1t6 form.
+	ErrInvalidTailTransactionHash = errors.New("invalid tail transaction hash")
+	// ErrInvalidEd25519Address is returned when an Ed25519 address is not hex encoded or has the wrong length.
+	ErrInvalidEd25519Address = errors.New("invalid Ed25519 address")
+)
+
+// LockedFunds are funds locked on the legacy network by a confirmed migration bundle.
+type LockedFunds struct {
+	Funds
+	// The legacy milestone which confirmed the migration bundle.
+	LegacyMilestoneIndex uint32 `json:"legacyMilestoneIndex"`
+}
+
+// MigrationStatus describes the state of the migration of a single legacy migration bundle.
+type MigrationStatus struct {
+	// The tail transaction hash of the migration bundle in trytes.
+	TailTransactionHash trinary.Hash `json:"tailTransactionHash"`
+	// The migrated value.
+	Value uint64 `json:"value"`
+	// The Ed25519 address to which the funds are migrated.
+	TargetEd25519Address string `json:"targetEd25519Address"`
+	// Whether the migration bundle was confirmed on the legacy network.
+	Confirmed bool `json:"confirmed"`
+	// The legacy milestone which confirmed the migration bundle.
+	LegacyMilestoneIndex uint32 `json:"legacyMilestoneIndex,omitempty"`
+	// Whether the funds were minted by a receipt on the C2 network.
+	Minted bool `json:"minted"`
+	// The C2 milestone which contained the receipt minting the funds.
+	C2MilestoneIndex uint32 `json:"c2MilestoneIndex,omitempty"`
+}
+
+// AddressMigrations lists the migrations targeting a single Ed25519 address.
+type AddressMigrations struct {
+	// The hex encoded Ed25519 address.
+	Ed25519Address string `json:"ed25519Address"`
+	// The tokens which were locked on the legacy network but are not yet minted.
+	PendingTokens uint64 `json:"pendingTokens"`
+	// The tokens which were minted by receipts on the C2 network.
+	MintedTokens uint64 `json:"mintedTokens"`
+	// The migrations in the order in which they were indexed.
+	Migrations []*MigrationStatus `json:"migrations"`
+}
+
+// MilestoneRange is a range of milestones including both ends.
+type MilestoneRange struct {
+	// The first milestone of the range.
+	From uint32 `json:"from"`
+	// The last milestone of the range.
+	To uint32 `json:"to"`
+}
+
+// a receipt entry minting the funds of a migration bundle.
+type mintedFunds struct {
+	funds   Funds
+	receipt *RecentReceipt
+}
+
+// NewMigrationIndex creates a new empty MigrationIndex.
+func NewMigrationIndex() *MigrationIndex {
+	return &MigrationIndex{
+		lockingsByTail: make(map[trinary.Hash]*LockedFunds),
+		mintsByTail:    make(map[trinary.Hash]*mintedFunds),
+		tailsByAddress: make(map[string][]trinary.Hash),
+		events:         newEventBroker(),
+	}
+}
+
+// MigrationIndex holds the lockings on the legacy network and the receipts on the C2 network
+// in the order in which they were confirmed.
+type MigrationIndex struct {
+	mu sync.RWMutex
+	// lockings in ascending legacy milestone order.
+	lockings []*LockedFunds
+	// receipts in ascending C2 milestone order.
+	receipts []*RecentReceipt
+	// lockings by their tail transaction hash in trytes.
+	lockingsByTail map[trinary.Hash]*LockedFunds
+	// receipt entries by their tail transaction hash in trytes.
+	mintsByTail map[trinary.Hash]*mintedFunds
+	// tail transaction hashes in trytes by their target Ed25519 address.
+	tailsByAddress map[string][]trinary.Hash
+	// fires an event for every added locking and receipt.
+	events *EventBroker
+	// the first legacy milestone which was indexed.
+	firstLegacyMilestoneIndex uint32
+	// the last legacy milestone which was indexed.
+	legacyMilestoneIndex uint32
+	// the legacy milestones which were skipped as no legacy node held them anymore or their extended ledger diff
+	// was inconsistent, in ascending order.
+	prunedLegacyMilestones []MilestoneRange
+	// the last C2 milestone up to which receipts were indexed.
+	c2MilestoneIndex uint32
+	// the tokens in the treasury as of c2MilestoneIndex.
+	treasuryTokens uint64
+
+	// the balances of the migration addresses as of lockedMilestoneIndex, nil while unknown.
+	lockedBalances map[trinary.Hash]uint64
+	// the funds locked on migration addresses derived from lockedBalances.
+	locked LegacyFundsLocked
+	// the legacy milestone up to which the locked balances were derived.
+	lockedMilestoneIndex uint32
+	// the min balance of a migration address to count as locked funds.
+	minLockedAmount uint64
+}
+
+// Events returns the EventBroker firing an event for every locking and receipt added to the index.
+func (idx *MigrationIndex) Events() *EventBroker {
+	return idx.events
+}
+
+// MilestoneIndexes returns the last indexed legacy and C2 milestone.
+func (idx *MigrationIndex) MilestoneIndexes() (legacy uint32, c2 uint32) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.legacyMilestoneIndex, idx.c2MilestoneIndex
+}
+
+// Counts returns the amount of indexed lockings and receipts.
+func (idx *MigrationIndex) Counts() (lockings int, receipts int) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.lockings), len(idx.receipts)
+}
+
+// AddLegacyMilestone adds the lockings confirmed by the given legacy milestone.
+// Milestones must be added in ascending order.
+func (idx *MigrationIndex) AddLegacyMilestone(msIndex uint32, lockings []*LockedFunds) {
+	idx.addLegacyMilestone(msIndex, lockings)
+
+	events := make([]*Event, len(lockings))
+	for i, locking := range lockings {
+		events[i] = &Event{Type: EventTypeLocked, Payload: locking}
+	}
+	idx.events.publish(events...)
+}
+
+// adds the lockings of the given legacy milestone under the lock.
+func (idx *MigrationIndex) addLegacyMilestone(msIndex uint32, lockings []*LockedFunds) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.firstLegacyMilestoneIndex == 0 {
+		idx.firstLegacyMilestoneIndex = msIndex
+	}
+	idx.lockings = append(idx.lockings, lockings...)
+	for _, locking := range lockings {
+		tailTxHash := locking.TailTransactionHash
+		if _, has := idx.mintsByTail[tailTxHash]; !has && !idx.hasTailOfAddress(locking.TargetEd25519Address, tailTxHash) {
+			idx.addTailOfAddress(locking.TargetEd25519Address, tailTxHash)
+		}
+		// a migration bundle can contain several migration outputs, which are accumulated
+		if existing, has := idx.lockingsByTail[tailTxHash]; has {
+			existing.Value += locking.Value
+			continue
+		}
+		accumulated := *locking
+		idx.lockingsByTail[tailTxHash] = &accumulated
+	}
+	idx.legacyMilestoneIndex = msIndex
+}
+
+// SkipLegacyMilestones marks the given range of legacy milestones as indexed without knowing their lockings,
+// as no legacy node holds them anymore or their extended ledger diff is inconsistent. Milestones must be skipped in ascending order.
+// The locked funds become unknown, as the balances of the migration addresses can't be derived across the skipped milestones.
+func (idx *MigrationIndex) SkipLegacyMilestones(from uint32, to uint32) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.lockedBalances = nil
+	idx.locked = LegacyFundsLocked{}
+	idx.lockedMilestoneIndex = 0
+	if n := len(idx.prunedLegacyMilestones); n > 0 && idx.prunedLegacyMilestones[n-1].To+1 == from {
+		idx.prunedLegacyMilestones[n-1].To = to
+	} else {
+		idx.prunedLegacyMilestones = append(idx.prunedLegacyMilestones, MilestoneRange{From: from, To: to})
+	}
+	idx.legacyMilestoneIndex = to
+}
+
+// PrunedLegacyMilestones returns the ranges of legacy milestones which were skipped as no legacy node held them anymore
+// or their extended ledger diff was inconsistent.
+func (idx *MigrationIndex) PrunedLegacyMilestones() []MilestoneRange {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	pruned := make([]MilestoneRange, len(idx.prunedLegacyMilestones))
+	copy(pruned, idx.prunedLegacyMilestones)
+	return pruned
+}
+
+// tells whether a skipped legacy milestone could have confirmed a locking migrated at the given legacy milestone,
+// must be called with the lock held.
+func (idx *MigrationIndex) prunedUpTo(msIndex uint32) bool {
+	return len(idx.prunedLegacyMilestones) > 0 && idx.prunedLegacyMilestones[0].From <= msIndex
+}
+
+// AddReceipts adds the receipts which were issued up to the given C2 milestone
+// along with the tokens in the treasury as of that milestone.
+// Receipts must be added in ascending order of their C2 milestone.
+func (idx *MigrationIndex) AddReceipts(msIndex uint32, receipts []*RecentReceipt, treasuryTokens uint64) {
+	idx.addReceipts(msIndex, receipts, treasuryTokens)
+
+	events := make([]*Event, len(receipts))
+	for i, receipt := range receipts {
+		events[i] = &Event{Type: EventTypeMinted, Payload: receipt}
+	}
+	idx.events.publish(events...)
+}
+
+// adds the given receipts and treasury under the lock.
+func (idx *MigrationIndex) addReceipts(msIndex uint32, receipts []*RecentReceipt, treasuryTokens uint64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.receipts = append(idx.receipts, receipts...)
+	for _, receipt := range receipts {
+		for _, funds := range receipt.Funds {
+			tailTxHash, err := ParseTailTransactionHash(funds.TailTransactionHash)
+			if err != nil {
+				continue
+			}
+			if _, has := idx.lockingsByTail[tailTxHash]; !has {
+				idx.addTailOfAddress(funds.TargetEd25519Address, tailTxHash)
+			}
+			idx.mintsByTail[tailTxHash] = &mintedFunds{funds: funds, receipt: receipt}
+		}
+	}
+	idx.c2MilestoneIndex = msIndex
+	idx.treasuryTokens = treasuryTokens
+}
+
+// PageQuery defines a page of lockings or receipts, pages are ordered from the most recent to the oldest entry.
+type PageQuery struct {
+	// The position to continue from as returned with the previous page, -1 to start with the most recent entry.
+	Cursor int
+	// The milestone from which on entries are included, 0 for no lower bound.
+	FromMilestone uint32
+	// The milestone up to which entries are included, 0 for no upper bound.
+	ToMilestone uint32
+	// The max amount of entries of the page.
+	Size int
+}
+
+// RecentlyLocked returns the page of lockings defined by the given query, the most recent first.
+// Milestone bounds refer to the legacy milestone which confirmed the locking.
+// The returned cursor continues with the next page, it is -1 if there are no further entries.
+func (idx *MigrationIndex) RecentlyLocked(query PageQuery) ([]*LockedFunds, int) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	positions, next := pagePositions(len(idx.lockings), func(i int) uint32 {
+		return idx.lockings[i].LegacyMilestoneIndex
+	}, query)
+
+	lockings := make([]*LockedFunds, 0, len(positions))
+	for _, i := range positions {
+		lockings = append(lockings, idx.lockings[i])
+	}
+	return lockings, next
+}
+
+// RecentlyMinted returns the page of receipts defined by the given query, the most recent first.
+// Milestone bounds refer to the C2 milestone in which the receipt was embedded.
+// The returned cursor continues with the next page, it is -1 if there are no further entries.
+func (idx *MigrationIndex) RecentlyMinted(query PageQuery) ([]*RecentReceipt, int) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	positions, next := pagePositions(len(idx.receipts), func(i int) uint32 {
+		return idx.receipts[i].EmbeddedMilestoneIndex
+	}, query)
+
+	receipts := make([]*RecentReceipt, 0, len(positions))
+	for _, i := range positions {
+		receipts = append(receipts, idx.receipts[i])
+	}
+	return receipts, next
+}
+
+// returns the descending positions of the page defined by the given query within n entries
+// sorted ascending by their milestone, and the position to continue from or -1.
+func pagePositions(n int, milestoneAt func(i int) uint32, query PageQuery) ([]int, int) {
+	start := n - 1
+	if query.Cursor >= 0 && query.Cursor < n {
+		start = query.Cursor
+	}
+	if query.ToMilestone > 0 {
+		// the first entry beyond the upper bound
+		beyond := sort.Search(n, func(i int) bool { return milestoneAt(i) > query.ToMilestone })
+		if beyond-1 < start {
+			start = beyond - 1
+		}
+	}
+
+	size := clamp(query.Size, 0, n)
+	positions := make([]int, 0, size)
+	i := start
+	for ; i >= 0 && milestoneAt(i) >= query.FromMilestone; i-- {
+		if len(positions) == size {
+			return positions, i
+		}
+		positions = append(positions, i)
+	}
+	return positions, -1
+}
+
+// MigrationStatus returns the status of the migration bundle with the given tail transaction hash in trytes.
+// It returns false if the bundle is neither among the indexed lockings nor the indexed receipts.
+func (idx *MigrationIndex) MigrationStatus(tailTxHash trinary.Hash) (*MigrationStatus, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.migrationStatus(tailTxHash)
+}
+
+// AddressMigrations returns the migrations targeting the given hex encoded Ed25519 address.
+func (idx *MigrationIndex) AddressMigrations(ed25519Address string) *AddressMigrations {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	tails := idx.tailsByAddress[ed25519Address]
+	migrations := &AddressMigrations{
+		Ed25519Address: ed25519Address,
+		Migrations:     make([]*MigrationStatus, 0, len(tails)),
+	}
+	for _, tailTxHash := range tails {
+		status, _ := idx.migrationStatus(tailTxHash)
+		if status.Minted {
+			migrations.MintedTokens += status.Value
+		} else {
+			migrations.PendingTokens += status.Value
+		}
+		migrations.Migrations = append(migrations.Migrations, status)
+	}
+	return migrations
+}
+
+// tells whether the given tail transaction hash is among the ones of the given address, must be called with the lock held.
+func (idx *MigrationIndex) hasTailOfAddress(ed25519Address string, tailTxHash trinary.Hash) bool {
+	for _, tail := range idx.tailsByAddress[ed25519Address] {
+		if tail == tailTxHash {
+			return true
+		}
+	}
+	return false
+}
+
+// adds the given tail transaction hash to the ones of the given address, must be called with the lock held.
+func (idx *MigrationIndex) addTailOfAddress(ed25519Address string, tailTxHash trinary.Hash) {
+	idx.tailsByAddress[ed25519Address] = append(idx.tailsByAddress[ed25519Address], tailTxHash)
+}
+
+// returns the status of the given migration, must be called with the lock held.
+func (idx *MigrationIndex) migrationStatus(tailTxHash trinary.Hash) (*MigrationStatus, bool) {
+	locking, locked := idx.lockingsByTail[tailTxHash]
+	minted, hasMinted := idx.mintsByTail[tailTxHash]
+	if !locked && !hasMinted {
+		return nil, false
+	}
+
+	status := &MigrationStatus{TailTransactionHash: tailTxHash}
+	if locked {
+		status.Value = locking.Value
+		status.TargetEd25519Address = locking.TargetEd25519Address
+		status.Confirmed = true
+		status.LegacyMilestoneIndex = locking.LegacyMilestoneIndex
+	}
+	if hasMinted {
+		status.Value = minted.funds.Value
+		status.TargetEd25519Address = minted.funds.TargetEd25519Address
+		// a receipt can only mint funds of confirmed migration bundles
+		status.Confirmed = true
+		status.LegacyMilestoneIndex = minted.receipt.LegacyMilestoneIndex
+		status.Minted = true
+		status.C2MilestoneIndex = minted.receipt.EmbeddedMilestoneIndex
+	}
+	return status, true
+}
+
+// ParseTailTransactionHash parses the given tail transaction hash into trytes.
+// Besides trytes, it accepts the hex and the b1t6 (trytes) encoding of the T5B1 encoded
+// hash used in iotago.MigratedFundsEntry.TailTransactionHash.
+func ParseTailTransactionHash(s string) (trinary.Hash, error) {
+	switch {
+	case guards.IsTrytesOfExactLength(s, consts.HashTrytesSize):
+		return s, nil
+	// the hex and b1t6 encodings have the same length but hex is never uppercase
+	case len(s) == b1t6.EncodedLen(legacyTailTxHashLength)/consts.TritsPerTryte && guards.IsTrytes(s):
+		tailTxHashBytes, err := b1t6.DecodeTrytes(s)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidTailTransactionHash, err)
+		}
+		return tailTransactionHashFromT5B1(tailTxHashBytes)
+	case len(s) == hex.EncodedLen(legacyTailTxHashLength):
+		tailTxHashBytes, err := hex.DecodeString(s)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidTailTransactionHash, err)
+		}
+		return tailTransactionHashFromT5B1(tailTxHashBytes)
+	}
+	return "", fmt.Errorf("%w: %s", ErrInvalidTailTransactionHash, s)
+}
+
+// ParseEd25519Address parses the given hex encoded Ed25519 address into its lowercase hex form.
+func ParseEd25519Address(s string) (string, error) {
+	addrBytes, err := hex.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidEd25519Address, err)
+	}
+	if len(addrBytes) != iotago.Ed25519AddressBytesLength {
+		return "", fmt.Errorf("%w: must be %d bytes long but is %d", ErrInvalidEd25519Address, iotago.Ed25519AddressBytesLength, len(addrBytes))
+	}
+	return hex.EncodeToString(addrBytes), nil
+}
+
+// converts the given T5B1 encoded tail transaction hash into trytes.
+func tailTransactionHashFromT5B1(tailTxHashBytes []byte) (trinary.Hash, error) {
+	trytes, err := t5b1.DecodeToTrytes(tailTxHashBytes)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidTailTransactionHash, err)
+	}
+	// the 243 trits of a hash are padded to 245 trits by T5B1
+	if len(trytes) != consts.HashTrytesSize+1 || trytes[consts.HashTrytesSize] != '9' {
+		return "", fmt.Errorf("%w: %s is not a T5B1 encoded hash", ErrInvalidTailTransactionHash, trytes)
+	}
+	return trytes[:consts.HashTrytesSize], nil
+}
+
+// clamps n to [min, max].
+func clamp(n int, min int, max int) int {
+	switch {
+	case n < min:
+		return min
+	case n > max:
+		return max
+	}
+	return n
+}
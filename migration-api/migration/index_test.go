@@ -0,0 +1,202 @@
+package migration
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/iotaledger/iota.go/encoding/b1t6"
+	"github.com/iotaledger/iota.go/encoding/t5b1"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTailTxHash = "KCGLBWPLCKRIHCHASCCPBMYDXBMAXGZGMSEBFHYCNOMXPRGBCJBXPAKVCDWCGAPUYVFRUBULYJCURWUGW"
+
+func TestParseTailTransactionHash(t *testing.T) {
+	tailTxHashBytes := t5b1.EncodeTrytes(testTailTxHash)
+	require.Len(t, tailTxHashBytes, legacyTailTxHashLength)
+
+	for name, input := range map[string]string{
+		"trytes": testTailTxHash,
+		"hex":    hex.EncodeToString(tailTxHashBytes),
+		"b1t6":   b1t6.EncodeToTrytes(tailTxHashBytes),
+	} {
+		t.Run(name, func(t *testing.T) {
+			tailTxHash, err := ParseTailTransactionHash(input)
+			require.NoError(t, err)
+			assert.Equal(t, testTailTxHash, tailTxHash)
+		})
+	}
+
+	for name, input := range map[string]string{
+		"empty":     "",
+		"lowercase": "kcglbwplckrihchascc",
+		"hex":       hex.EncodeToString(make([]byte, legacyTailTxHashLength-1)),
+		"padding":   hex.EncodeToString(append(t5b1.EncodeTrytes(testTailTxHash[:80]), 0x79)),
+	} {
+		t.Run("invalid "+name, func(t *testing.T) {
+			_, err := ParseTailTransactionHash(input)
+			assert.True(t, errors.Is(err, ErrInvalidTailTransactionHash))
+		})
+	}
+}
+
+func TestMigrationIndexMigrationStatus(t *testing.T) {
+	index := NewMigrationIndex()
+
+	const target = "efdc112efe262b304bcf379b26c31bad029f616ee3ec4aa6345a366e4c9e43a3"
+	funds := Funds{TailTransactionHash: testTailTxHash, Value: 5000000, TargetEd25519Address: target}
+
+	_, found := index.MigrationStatus(testTailTxHash)
+	assert.False(t, found)
+
+	index.AddLegacyMilestone(1004394, []*LockedFunds{{Funds: funds, LegacyMilestoneIndex: 1004394}})
+
+	status, found := index.MigrationStatus(testTailTxHash)
+	require.True(t, found)
+	assert.Equal(t, &MigrationStatus{
+		TailTransactionHash:  testTailTxHash,
+		Value:                5000000,
+		TargetEd25519Address: target,
+		Confirmed:            true,
+		LegacyMilestoneIndex: 1004394,
+	}, status)
+
+	mintedFunds := funds
+	mintedFunds.TailTransactionHash = hex.EncodeToString(t5b1.EncodeTrytes(testTailTxHash))
+	index.AddReceipts(10024, []*RecentReceipt{{
+		EmbeddedMilestoneIndex: 10024,
+		LegacyMilestoneIndex:   1004394,
+		Funds:                  []Funds{mintedFunds},
+	}}, 0)
+
+	status, found = index.MigrationStatus(testTailTxHash)
+	require.True(t, found)
+	assert.True(t, status.Minted)
+	assert.EqualValues(t, 10024, status.C2MilestoneIndex)
+	assert.EqualValues(t, 1004394, status.LegacyMilestoneIndex)
+}
+
+func TestMigrationIndexAddressMigrations(t *testing.T) {
+	index := NewMigrationIndex()
+
+	const target = "efdc112efe262b304bcf379b26c31bad029f616ee3ec4aa6345a366e4c9e43a3"
+	const otherTailTxHash = "LKLDRXVJIHIFKGYUEGVFMNUKPYEXZWOMAX9LRLRRAVQRVCZQRDIMSRAFAPEAEIXIPQXLLYFYFVDURIQCW"
+
+	index.AddLegacyMilestone(1004394, []*LockedFunds{
+		{Funds: Funds{TailTransactionHash: testTailTxHash, Value: 5000000, TargetEd25519Address: target}, LegacyMilestoneIndex: 1004394},
+		{Funds: Funds{TailTransactionHash: otherTailTxHash, Value: 1000000000, TargetEd25519Address: target}, LegacyMilestoneIndex: 1004394},
+	})
+	index.AddReceipts(10024, []*RecentReceipt{{
+		EmbeddedMilestoneIndex: 10024,
+		LegacyMilestoneIndex:   1004394,
+		Funds: []Funds{{
+			TailTransactionHash:  hex.EncodeToString(t5b1.EncodeTrytes(testTailTxHash)),
+			Value:                5000000,
+			TargetEd25519Address: target,
+		}},
+	}}, 0)
+
+	migrations := index.AddressMigrations(target)
+	assert.Equal(t, target, migrations.Ed25519Address)
+	assert.EqualValues(t, 5000000, migrations.MintedTokens)
+	assert.EqualValues(t, 1000000000, migrations.PendingTokens)
+	require.Len(t, migrations.Migrations, 2)
+	assert.Equal(t, testTailTxHash, migrations.Migrations[0].TailTransactionHash)
+	assert.True(t, migrations.Migrations[0].Minted)
+	assert.Equal(t, otherTailTxHash, migrations.Migrations[1].TailTransactionHash)
+	assert.False(t, migrations.Migrations[1].Minted)
+
+	assert.Empty(t, index.AddressMigrations("afdc312efe262b304bcf379b26c31bad029f616ee3ec4aa6345a366e4c9e43a3").Migrations)
+}
+
+func TestParseEd25519Address(t *testing.T) {
+	addr, err := ParseEd25519Address("EFDC112EFE262B304BCF379B26C31BAD029F616EE3EC4AA6345A366E4C9E43A3")
+	require.NoError(t, err)
+	assert.Equal(t, "efdc112efe262b304bcf379b26c31bad029f616ee3ec4aa6345a366e4c9e43a3", addr)
+
+	_, err = ParseEd25519Address("efdc")
+	assert.True(t, errors.Is(err, ErrInvalidEd25519Address))
+}
+
+func TestMigrationIndexRecentlyLockedPages(t *testing.T) {
+	index := NewMigrationIndex()
+	for msIndex := uint32(1); msIndex <= 5; msIndex++ {
+		// two lockings per milestone
+		index.AddLegacyMilestone(msIndex, []*LockedFunds{
+			{Funds: Funds{Value: uint64(msIndex) * 10}, LegacyMilestoneIndex: msIndex},
+			{Funds: Funds{Value: uint64(msIndex)*10 + 1}, LegacyMilestoneIndex: msIndex},
+		})
+	}
+
+	values := func(lockings []*LockedFunds) []uint64 {
+		vals := make([]uint64, len(lockings))
+		for i, locking := range lockings {
+			vals[i] = locking.Value
+		}
+		return vals
+	}
+
+	// page through everything
+	var all []uint64
+	query := PageQuery{Cursor: -1, Size: 3}
+	for {
+		lockings, next := index.RecentlyLocked(query)
+		all = append(all, values(lockings)...)
+		if next == -1 {
+			break
+		}
+		query.Cursor = next
+	}
+	assert.Equal(t, []uint64{51, 50, 41, 40, 31, 30, 21, 20, 11, 10}, all)
+
+	// milestone range
+	lockings, next := index.RecentlyLocked(PageQuery{Cursor: -1, FromMilestone: 2, ToMilestone: 3, Size: 3})
+	assert.Equal(t, []uint64{31, 30, 21}, values(lockings))
+	lockings, next = index.RecentlyLocked(PageQuery{Cursor: next, FromMilestone: 2, ToMilestone: 3, Size: 3})
+	assert.Equal(t, []uint64{20}, values(lockings))
+	assert.Equal(t, -1, next)
+
+	// exact fit doesn't announce an empty next page
+	lockings, next = index.RecentlyLocked(PageQuery{Cursor: -1, FromMilestone: 5, Size: 2})
+	assert.Equal(t, []uint64{51, 50}, values(lockings))
+	assert.Equal(t, -1, next)
+
+	// empty index
+	receipts, next := NewMigrationIndex().RecentlyMinted(PageQuery{Cursor: -1, Size: 10})
+	assert.Empty(t, receipts)
+	assert.Equal(t, -1, next)
+}
+
+func TestHTTPAPIServiceRecentPagesCursor(t *testing.T) {
+	index := NewMigrationIndex()
+	index.AddLegacyMilestone(1, []*LockedFunds{{LegacyMilestoneIndex: 1}, {LegacyMilestoneIndex: 1}})
+	index.AddReceipts(10, []*RecentReceipt{{EmbeddedMilestoneIndex: 10, LegacyMilestoneIndex: 1}}, 0)
+
+	httpAPI := NewHTTPAPIService(echo.New(), "", &HTTPAPIServiceConfig{}, index, nil)
+	httpAPI.registerRoutes()
+
+	var tests = []struct {
+		path    string
+		expCode int
+	}{
+		{path: "/recentlyLocked/10", expCode: http.StatusOK},
+		{path: "/recentlyLocked/10?cursor=1", expCode: http.StatusOK},
+		{path: "/recentlyLocked/10?cursor=2", expCode: http.StatusBadRequest},
+		{path: "/recentlyLocked/10?cursor=-1", expCode: http.StatusBadRequest},
+		{path: "/recentlyMinted/10?cursor=0", expCode: http.StatusOK},
+		{path: "/recentlyMinted/10?cursor=1", expCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			httpAPI.e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			assert.Equal(t, tt.expCode, rec.Code, rec.Body.String())
+		})
+	}
+}
@@ -0,0 +1,543 @@
+package migration
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iotaledger/chrysalis-tools/common"
+	"github.com/iotaledger/iota.go/address"
+	"github.com/iotaledger/iota.go/api"
+	"github.com/iotaledger/iota.go/consts"
+	"github.com/iotaledger/iota.go/transaction"
+	"github.com/iotaledger/iota.go/trinary"
+	iotago "github.com/iotaledger/iota.go/v2"
+)
+
+const (
+	// the fetch interval used if none is configured.
+	defaultIndexerFetchInterval = 10 * time.Second
+)
+
+// NewIndexerService creates a new IndexerService which indexes into the given MigrationIndex.
+// If history is not nil, the state of the migration is recorded into it after every indexed milestone.
+func NewIndexerService(cfg *HTTPAPIServiceConfig, index *MigrationIndex, history *HistoryStore) *IndexerService {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &IndexerService{
+		cfg:     cfg,
+		index:   index,
+		history: history,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// IndexerService follows the legacy and C2 milestones and indexes lockings and receipts into a MigrationIndex.
+type IndexerService struct {
+	cfg         *HTTPAPIServiceConfig
+	index       *MigrationIndex
+	history     *HistoryStore
+	legacyNodes *LegacyNodePool
+	c2Nodes     *C2NodePool
+
+	// canceled on shutdown, aborts the catch-up and pending queries.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// closed once the current Run returned.
+	runDoneMu sync.Mutex
+	runDone   chan struct{}
+
+	// the legacy milestone after which the indexing starts, pinned once the LSMI is known.
+	startIndex       uint32
+	startIndexPinned bool
+	// whether the indexing caught up with the LSMI once, lockings of the catch-up before are not published as events.
+	legacyCaughtUp atomic.Bool
+	// whether the receipts issued up to the C2 CMI were indexed once, receipts of the catch-up before are not published as events.
+	receiptsCaughtUp atomic.Bool
+}
+
+// Init initializes the node pools of the service.
+func (idxr *IndexerService) Init() error {
+	legacyClientOpts, err := ledgerCacheOptions(idxr.cfg)
+	if err != nil {
+		return err
+	}
+
+	idxr.legacyNodes, err = NewLegacyNodePool(&idxr.cfg.LegacyNode, legacyClientOpts...)
+	if err != nil {
+		return fmt.Errorf("unable to init legacy node pool: %w", err)
+	}
+
+	idxr.c2Nodes, err = NewC2NodePool(&idxr.cfg.C2Node)
+	if err != nil {
+		return fmt.Errorf("unable to init C2 node pool: %w", err)
+	}
+
+	return nil
+}
+
+// Run periodically indexes new legacy and C2 milestones.
+func (idxr *IndexerService) Run() error {
+	log.Println("running indexer service")
+
+	if idxr.legacyNodes == nil {
+		panic("Init() must be called before Run()")
+	}
+
+	runDone := make(chan struct{})
+	defer close(runDone)
+	idxr.runDoneMu.Lock()
+	idxr.runDone = runDone
+	idxr.runDoneMu.Unlock()
+
+	for {
+		if err := idxr.update(idxr.ctx); err != nil && idxr.ctx.Err() == nil {
+			log.Printf("unable to update migration index: %v", err)
+		}
+
+		// the fetch interval can be changed at runtime
+		fetchInterval := idxr.cfg.Indexer.fetchInterval()
+		if fetchInterval <= 0 {
+			fetchInterval = defaultIndexerFetchInterval
+		}
+
+		select {
+		case <-idxr.ctx.Done():
+			return nil
+		case <-time.After(fetchInterval):
+		}
+	}
+}
+
+// Shutdown shuts down the service and waits for the running update to be aborted.
+func (idxr *IndexerService) Shutdown(ctx context.Context) error {
+	log.Println("shutting down indexer service...")
+	idxr.cancel()
+
+	idxr.runDoneMu.Lock()
+	runDone := idxr.runDone
+	idxr.runDoneMu.Unlock()
+	if runDone == nil {
+		return nil
+	}
+
+	select {
+	case <-runDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Ready returns an error while the indexing didn't catch up with the LSMI of the legacy nodes once.
+func (idxr *IndexerService) Ready(_ context.Context) error {
+	if idxr.legacyCaughtUp.Load() {
+		return nil
+	}
+	if legacyIndex, _ := idxr.index.MilestoneIndexes(); legacyIndex > 0 {
+		return fmt.Errorf("catching up: indexed up to legacy milestone %d", legacyIndex)
+	}
+	return errors.New("waiting for the first update")
+}
+
+// NodeStatuses returns the status of the nodes the service queries.
+func (idxr *IndexerService) NodeStatuses(ctx context.Context) *ServiceNodeStatuses {
+	return nodePoolStatuses(ctx, idxr.legacyNodes, idxr.c2Nodes)
+}
+
+// indexes the legacy and C2 milestones which were confirmed since the last update.
+func (idxr *IndexerService) update(ctx context.Context) error {
+	// a failing network shouldn't hold back the indexing of the other one
+	return errors.Join(idxr.indexLegacyMilestones(ctx), idxr.indexReceipts(ctx))
+}
+
+// indexes the lockings of the legacy milestones confirmed since the last indexed legacy milestone.
+func (idxr *IndexerService) indexLegacyMilestones(ctx context.Context) error {
+	var legacyInfo *api.GetNodeInfoResponse
+	if err := idxr.legacyNodes.Do(ctx, func(node *LegacyNode) error {
+		var err error
+		legacyInfo, err = node.API.GetNodeInfo()
+		return err
+	}); err != nil {
+		return fmt.Errorf("unable to query info from legacy node: %w", err)
+	}
+	lsmi := uint32(legacyInfo.LatestSolidSubtangleMilestoneIndex)
+
+	if !idxr.startIndexPinned {
+		// the start index is derived from the LSMI only once, as it moves on while no milestone is indexed yet
+		idxr.startIndex = idxr.legacyStartIndex(lsmi)
+		idxr.startIndexPinned = true
+	}
+
+	lastIndexed, _ := idxr.index.MilestoneIndexes()
+	if lastIndexed < idxr.startIndex {
+		lastIndexed = idxr.startIndex
+	}
+
+	// skip the milestones which none of the legacy nodes holds anymore at once
+	if pruningIndex := idxr.legacyNodes.PruningIndex(); lastIndexed < pruningIndex && pruningIndex <= lsmi {
+		log.Printf("skipping legacy milestones %d-%d as they are pruned on all legacy nodes", lastIndexed+1, pruningIndex)
+		idxr.index.SkipLegacyMilestones(lastIndexed+1, pruningIndex)
+		lastIndexed = pruningIndex
+	}
+
+	for msIndex := lastIndexed + 1; msIndex <= lsmi; msIndex++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var diff *common.GetLedgerDiffExtReturn
+		err := idxr.legacyNodes.DoAt(ctx, msIndex, func(node *LegacyNode) error {
+			var err error
+			diff, err = node.Client.LedgerDiffExtended(ctx, int(msIndex))
+			return err
+		})
+		switch {
+		case errors.Is(err, ErrMilestonePruned):
+			log.Printf("skipping legacy milestone %d: %v", msIndex, err)
+			idxr.index.SkipLegacyMilestones(msIndex, msIndex)
+			continue
+		case errors.Is(err, common.ErrInconsistentLedgerDiffExt):
+			// querying the milestone again would yield the same diff, so it is skipped instead of blocking the indexer
+			log.Printf("skipping legacy milestone %d as its extended ledger diff is inconsistent: %v", msIndex, err)
+			idxr.index.SkipLegacyMilestones(msIndex, msIndex)
+			continue
+		case err != nil:
+			return fmt.Errorf("unable to query extended ledger diff for legacy milestone %d: %w", msIndex, err)
+		}
+
+		// the timestamp is queried before the milestone is indexed, so that a failing query doesn't leave a gap in the history
+		var msTime time.Time
+		if idxr.history != nil && msIndex > idxr.lastHistoryPoint().LegacyMilestoneIndex {
+			if msTime, err = idxr.legacyMilestoneTime(ctx, msIndex); err != nil {
+				return err
+			}
+		}
+
+		// the ledger state of a milestone already contains its diff, so the diff is only applied to known balances
+		if idxr.index.hasLockedBalances() {
+			idxr.index.applyLedgerDiff(diff)
+		} else if err := idxr.loadLockedBalances(ctx, msIndex); err != nil {
+			return err
+		}
+
+		if idxr.legacyCaughtUp.Load() {
+			idxr.index.AddLegacyMilestone(msIndex, lockingsOfLedgerDiff(msIndex, diff))
+		} else {
+			// subscribers only expect lockings of new milestones, not the history of the catch-up
+			idxr.index.addLegacyMilestone(msIndex, lockingsOfLedgerDiff(msIndex, diff))
+		}
+
+		if idxr.history == nil {
+			continue
+		}
+		locked, _, _ := idxr.index.LegacyFundsLocked()
+		if err := idxr.recordHistory(msTime, func(point *HistoryPoint) bool {
+			if msIndex <= point.LegacyMilestoneIndex {
+				// already recorded before a restart
+				return false
+			}
+			point.LegacyMilestoneIndex = msIndex
+			point.LegacyFundsLocked = locked
+			return true
+		}); err != nil {
+			return err
+		}
+	}
+
+	idxr.legacyCaughtUp.Store(true)
+	return nil
+}
+
+// loads the balances of the migration addresses at the given legacy milestone into the index
+// as the base to apply ledger diffs on.
+func (idxr *IndexerService) loadLockedBalances(ctx context.Context, msIndex uint32) error {
+	minAmount := uint64(idxr.cfg.MinTokenAmountForMigration)
+	if msIndex == 0 {
+		idxr.index.setLockedBalances(msIndex, nil, minAmount)
+		return nil
+	}
+
+	var balances map[trinary.Hash]uint64
+	if err := idxr.legacyNodes.DoAt(ctx, msIndex, func(node *LegacyNode) error {
+		// reset in case a previous node failed mid-stream
+		balances = make(map[trinary.Hash]uint64)
+		_, err := node.Client.StreamLedgerState(ctx, int(msIndex), func(addr trinary.Hash, balance uint64) error {
+			if _, err := address.ParseMigrationAddress(addr); err != nil {
+				return nil
+			}
+			balances[addr] = balance
+			return nil
+		})
+		return err
+	}); err != nil {
+		return fmt.Errorf("unable to query ledger state of legacy milestone %d: %w", msIndex, err)
+	}
+
+	idxr.index.setLockedBalances(msIndex, balances, minAmount)
+	return nil
+}
+
+// records a new point at the given milestone timestamp into the history which is a copy of the last point modified
+// by the given function. as every point only updates the values of one network, the values of the other network
+// are carried forward. no point is recorded if the function returns false.
+func (idxr *IndexerService) recordHistory(msTime time.Time, update func(point *HistoryPoint) bool) error {
+	point := &HistoryPoint{}
+	if last := idxr.history.Last(); last != nil {
+		*point = *last
+	}
+
+	if !update(point) {
+		return nil
+	}
+
+	point.Time = msTime
+	if err := idxr.history.Append(point); err != nil {
+		return fmt.Errorf("unable to record history: %w", err)
+	}
+	return nil
+}
+
+// returns the last point of the history or an empty point if none was recorded yet.
+func (idxr *IndexerService) lastHistoryPoint() *HistoryPoint {
+	if idxr.history == nil {
+		return &HistoryPoint{}
+	}
+	if last := idxr.history.Last(); last != nil {
+		return last
+	}
+	return &HistoryPoint{}
+}
+
+// queries the timestamp of the given legacy milestone out of the tail transaction of its milestone bundle.
+func (idxr *IndexerService) legacyMilestoneTime(ctx context.Context, msIndex uint32) (time.Time, error) {
+	var wfConf *common.GetWhiteFlagConfirmationResponse
+	if err := idxr.legacyNodes.DoAt(ctx, msIndex, func(node *LegacyNode) error {
+		var err error
+		wfConf, err = node.Client.WhiteFlagConfirmation(ctx, int(msIndex))
+		return err
+	}); err != nil {
+		return time.Time{}, fmt.Errorf("unable to query white-flag confirmation of legacy milestone %d: %w", msIndex, err)
+	}
+
+	if len(wfConf.MilestoneBundle) == 0 {
+		return time.Time{}, fmt.Errorf("white-flag confirmation of legacy milestone %d contains no milestone bundle", msIndex)
+	}
+	tailTx, err := transaction.AsTransactionObject(wfConf.MilestoneBundle[0])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to parse milestone bundle of legacy milestone %d: %w", msIndex, err)
+	}
+	return time.Unix(int64(tailTx.Timestamp), 0).UTC(), nil
+}
+
+// queries the timestamp of the given C2 milestone.
+func (idxr *IndexerService) c2MilestoneTime(ctx context.Context, msIndex uint32) (time.Time, error) {
+	var msRes *iotago.MilestoneResponse
+	if err := idxr.c2Nodes.DoAt(ctx, msIndex, func(node *C2Node) error {
+		var err error
+		msRes, err = node.API.MilestoneByIndex(ctx, msIndex)
+		return err
+	}); err != nil {
+		return time.Time{}, fmt.Errorf("unable to query C2 milestone %d: %w", msIndex, err)
+	}
+	return time.Unix(msRes.Time, 0).UTC(), nil
+}
+
+// returns the legacy milestone after which the indexing starts.
+func (idxr *IndexerService) legacyStartIndex(lsmi uint32) uint32 {
+	if idxr.cfg.Indexer.LegacyMilestoneStartIndex > 0 {
+		return uint32(idxr.cfg.Indexer.LegacyMilestoneStartIndex)
+	}
+	// without a configured start index, only the last MaxMilestonesToQueryForEntries milestones are indexed
+	maxMilestones := idxr.cfg.maxMilestonesToQueryForEntries()
+	if int(lsmi) < maxMilestones {
+		return 0
+	}
+	return lsmi - uint32(maxMilestones)
+}
+
+// extracts the funds sent to migration addresses out of the given extended ledger diff.
+func lockingsOfLedgerDiff(msIndex uint32, diff *common.GetLedgerDiffExtReturn) []*LockedFunds {
+	var lockings []*LockedFunds
+	for _, tx := range diff.ConfirmedTxWithValue {
+		if tx.Value <= 0 {
+			continue
+		}
+
+		edAddr, err := address.ParseMigrationAddress(tx.Address)
+		if err != nil {
+			continue
+		}
+
+		lockings = append(lockings, &LockedFunds{
+			Funds: Funds{
+				TailTransactionHash:  tx.TailTxHash,
+				Value:                uint64(tx.Value),
+				TargetEd25519Address: hex.EncodeToString(edAddr[:]),
+			},
+			LegacyMilestoneIndex: msIndex,
+		})
+	}
+	return lockings
+}
+
+// indexes the receipts which were issued since the last indexed C2 milestone.
+func (idxr *IndexerService) indexReceipts(ctx context.Context) error {
+	var c2Info *iotago.NodeInfoResponse
+	var receipts []*iotago.ReceiptTuple
+	var treasury *iotago.TreasuryResponse
+	if err := idxr.c2Nodes.Do(ctx, func(node *C2Node) error {
+		var err error
+		if c2Info, err = node.API.Info(ctx); err != nil {
+			return err
+		}
+		// the treasury is queried before the receipts, so that every receipt it reflects is among the receipts
+		if treasury, err = node.API.Treasury(ctx); err != nil {
+			return err
+		}
+		receipts, err = node.API.Receipts(ctx)
+		return err
+	}); err != nil {
+		return fmt.Errorf("unable to query receipts from C2 node: %w", err)
+	}
+
+	_, lastIndexed := idxr.index.MilestoneIndexes()
+	if c2Info.ConfirmedMilestoneIndex <= lastIndexed {
+		return nil
+	}
+
+	sort.Slice(receipts, func(i, j int) bool {
+		return receipts[i].MilestoneIndex < receipts[j].MilestoneIndex
+	})
+
+	var newReceipts []*RecentReceipt
+	var newReceiptTuples []*iotago.ReceiptTuple
+	for _, receipt := range receipts {
+		if receipt.MilestoneIndex <= lastIndexed || receipt.MilestoneIndex > c2Info.ConfirmedMilestoneIndex {
+			continue
+		}
+		newReceipts = append(newReceipts, recentReceiptOf(receipt))
+		newReceiptTuples = append(newReceiptTuples, receipt)
+	}
+
+	treasuryTokens, err := treasuryAt(c2Info.ConfirmedMilestoneIndex, receipts, treasury.Amount)
+	if err != nil {
+		return err
+	}
+
+	// the points are collected before the receipts are indexed, so that a failing query doesn't leave a gap in the history
+	var points []*treasuryPoint
+	if idxr.history != nil {
+		if points, err = idxr.treasuryPoints(ctx, c2Info.ConfirmedMilestoneIndex, newReceiptTuples, treasuryTokens); err != nil {
+			return err
+		}
+	}
+
+	if idxr.receiptsCaughtUp.Load() {
+		idxr.index.AddReceipts(c2Info.ConfirmedMilestoneIndex, newReceipts, treasuryTokens)
+	} else {
+		idxr.index.addReceipts(c2Info.ConfirmedMilestoneIndex, newReceipts, treasuryTokens)
+		idxr.receiptsCaughtUp.Store(true)
+	}
+
+	for _, point := range points {
+		if err := idxr.recordTreasury(point); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the treasury as of a C2 milestone to record into the history.
+type treasuryPoint struct {
+	msIndex        uint32
+	msTime         time.Time
+	treasuryTokens uint64
+}
+
+// returns the treasury points of the given new receipts which are not recorded yet. without any receipt,
+// the treasury as of the given CMI is recorded once.
+func (idxr *IndexerService) treasuryPoints(ctx context.Context, cmi uint32, newReceipts []*iotago.ReceiptTuple, treasuryTokens uint64) ([]*treasuryPoint, error) {
+	last := idxr.lastHistoryPoint()
+
+	var points []*treasuryPoint
+	for _, receipt := range newReceipts {
+		treasuryTx, ok := receipt.Receipt.Transaction.(*iotago.TreasuryTransaction)
+		if !ok || receipt.MilestoneIndex <= last.C2MilestoneIndex {
+			continue
+		}
+		points = append(points, &treasuryPoint{msIndex: receipt.MilestoneIndex, treasuryTokens: treasuryTx.Output.(*iotago.TreasuryOutput).Amount})
+	}
+	if len(points) == 0 && last.C2MilestoneIndex == 0 {
+		points = append(points, &treasuryPoint{msIndex: cmi, treasuryTokens: treasuryTokens})
+	}
+
+	for _, point := range points {
+		var err error
+		if point.msTime, err = idxr.c2MilestoneTime(ctx, point.msIndex); err != nil {
+			return nil, err
+		}
+	}
+	return points, nil
+}
+
+// returns the tokens in the treasury as of the given C2 milestone out of the given receipts sorted by their milestone
+// and the current treasury. The first receipt beyond the milestone spent the treasury as of the milestone,
+// which therefore equals the receipt's remaining treasury plus its deposits.
+func treasuryAt(msIndex uint32, receipts []*iotago.ReceiptTuple, currentTreasury uint64) (uint64, error) {
+	for _, receipt := range receipts {
+		if receipt.MilestoneIndex <= msIndex {
+			continue
+		}
+		treasuryTx, ok := receipt.Receipt.Transaction.(*iotago.TreasuryTransaction)
+		if !ok {
+			return 0, fmt.Errorf("receipt of C2 milestone %d contains no treasury transaction", receipt.MilestoneIndex)
+		}
+		treasuryTokens := treasuryTx.Output.(*iotago.TreasuryOutput).Amount
+		for _, f := range receipt.Receipt.Funds {
+			treasuryTokens += f.(*iotago.MigratedFundsEntry).Deposit
+		}
+		return treasuryTokens, nil
+	}
+	// no receipt spent the treasury since the milestone
+	return currentTreasury, nil
+}
+
+// records the given treasury point into the history.
+func (idxr *IndexerService) recordTreasury(tp *treasuryPoint) error {
+	return idxr.recordHistory(tp.msTime, func(point *HistoryPoint) bool {
+		if tp.msIndex <= point.C2MilestoneIndex {
+			// already recorded before a restart
+			return false
+		}
+		point.C2MilestoneIndex = tp.msIndex
+		point.TreasuryTokens = tp.treasuryTokens
+		point.TokensMigrated = consts.TotalSupply - tp.treasuryTokens
+		return true
+	})
+}
+
+// converts the given receipt into a RecentReceipt.
+func recentReceiptOf(receipt *iotago.ReceiptTuple) *RecentReceipt {
+	funds := make([]Funds, len(receipt.Receipt.Funds))
+	for i, f := range receipt.Receipt.Funds {
+		entry := f.(*iotago.MigratedFundsEntry)
+		addr := entry.Address.(*iotago.Ed25519Address)
+		funds[i] = Funds{
+			TailTransactionHash:  hex.EncodeToString(entry.TailTransactionHash[:]),
+			Value:                entry.Deposit,
+			TargetEd25519Address: hex.EncodeToString(addr[:]),
+		}
+	}
+	return &RecentReceipt{
+		EmbeddedMilestoneIndex: receipt.MilestoneIndex,
+		LegacyMilestoneIndex:   receipt.Receipt.MigratedAt,
+		Funds:                  funds,
+	}
+}
@@ -0,0 +1,502 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/chrysalis-tools/common"
+	"github.com/iotaledger/iota.go/consts"
+	"github.com/iotaledger/iota.go/transaction"
+	"github.com/iotaledger/iota.go/trinary"
+	iotago "github.com/iotaledger/iota.go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// the milestone of the common ledger diff fixture which confirms two migration bundles.
+	fixtureMilestoneIndex = 1337000
+	fixtureMigrationAddr  = "TRANSFERC9J9QAXADAKARBYBEBLBSCZCFCMCTD9DGDNEHVOWVWBWIWPXWXCXJXQYXYDYKYRZTDRD99VD9"
+)
+
+func readLedgerDiffExtFixture(t *testing.T) *common.GetLedgerDiffExtReturn {
+	f, err := os.Open(fmt.Sprintf("../../common/testdata/ledger_diff_ext_%d.json", fixtureMilestoneIndex))
+	require.NoError(t, err)
+	defer f.Close()
+	diff, err := common.DecodeLedgerDiffExt(f)
+	require.NoError(t, err)
+	return diff
+}
+
+// a legacy node serving the ledger diff fixture and empty ledger diffs and states for all other milestones up to its LSMI.
+type testLegacyNode struct {
+	*httptest.Server
+	lsmi atomic.Uint32
+	// the amount of getLedgerState calls
+	ledgerStateCalls atomic.Int32
+	// getLedgerDiffExt fails with an internal error from this milestone on, unless zero
+	failingFrom atomic.Uint32
+	// getLedgerDiffExt returns a diff not matching its confirmed txs for this milestone, unless zero
+	inconsistentAt atomic.Uint32
+	// whether getLedgerDiffExt blocks until the request is canceled
+	hanging atomic.Bool
+}
+
+func newTestLegacyNode(t *testing.T, lsmi uint32) *testLegacyNode {
+	fixture, err := os.ReadFile(fmt.Sprintf("../../common/testdata/ledger_diff_ext_%d.json", fixtureMilestoneIndex))
+	require.NoError(t, err)
+
+	node := &testLegacyNode{}
+	node.lsmi.Store(lsmi)
+	node.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Command        string `json:"command"`
+			MilestoneIndex uint32 `json:"milestoneIndex"`
+			TargetIndex    uint32 `json:"targetIndex"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		lsmi := node.lsmi.Load()
+		switch req.Command {
+		case "getNodeInfo":
+			_, _ = fmt.Fprintf(w, `{"latestMilestoneIndex": %d, "latestSolidSubtangleMilestoneIndex": %d}`, lsmi, lsmi)
+		case "getLedgerDiffExt":
+			switch {
+			case node.hanging.Load():
+				<-r.Context().Done()
+			case node.failingFrom.Load() != 0 && req.MilestoneIndex >= node.failingFrom.Load():
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = fmt.Fprint(w, `{"error": "internal error"}`)
+			case node.inconsistentAt.Load() != 0 && req.MilestoneIndex == node.inconsistentAt.Load():
+				_, _ = fmt.Fprintf(w, `{"confirmedTxWithValue": [], "confirmedBundlesWithValue": [], "diff": {"%s": 1}, "milestoneIndex": %d}`, fixtureMigrationAddr, req.MilestoneIndex)
+			case req.MilestoneIndex == fixtureMilestoneIndex:
+				_, _ = w.Write(fixture)
+			default:
+				_, _ = fmt.Fprintf(w, `{"confirmedTxWithValue": [], "confirmedBundlesWithValue": [], "diff": {}, "milestoneIndex": %d}`, req.MilestoneIndex)
+			}
+		case "getWhiteFlagConfirmation":
+			// the milestone bundle's tail transaction carries the timestamp of the milestone
+			msBundle := transaction.MustTransactionsToTrytes(transaction.Transactions{{
+				SignatureMessageFragment: strings.Repeat("9", consts.SignatureMessageFragmentSizeInTrytes),
+				Address:                  consts.NullHashTrytes,
+				ObsoleteTag:              strings.Repeat("9", consts.TagTrinarySize/consts.TritsPerTryte),
+				Timestamp:                uint64(testLegacyMilestoneTime(req.MilestoneIndex).Unix()),
+				Bundle:                   consts.NullHashTrytes,
+				TrunkTransaction:         consts.NullHashTrytes,
+				BranchTransaction:        consts.NullHashTrytes,
+				Tag:                      strings.Repeat("9", consts.TagTrinarySize/consts.TritsPerTryte),
+				Nonce:                    strings.Repeat("9", consts.NonceTrinarySize/consts.TritsPerTryte),
+			}})
+			_ = json.NewEncoder(w).Encode(&common.GetWhiteFlagConfirmationResponse{MilestoneBundle: msBundle, IncludedBundles: [][]trinary.Trytes{}})
+		case "getLedgerState":
+			node.ledgerStateCalls.Add(1)
+			_, _ = fmt.Fprintf(w, `{"balances": {}, "milestoneIndex": %d}`, req.TargetIndex)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprintf(w, `{"error": "unknown command %s"}`, req.Command)
+		}
+	}))
+	t.Cleanup(node.Close)
+	return node
+}
+
+// a C2 node serving the given receipts and the treasury left by the last one.
+func newTestReceiptsNode(t *testing.T, cmi *atomic.Uint32, receipts []*iotago.ReceiptTuple) *httptest.Server {
+	treasury := &iotago.TreasuryResponse{Amount: consts.TotalSupply}
+	for _, receipt := range receipts {
+		treasury.Amount = receipt.Receipt.Transaction.(*iotago.TreasuryTransaction).Output.(*iotago.TreasuryOutput).Amount
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data interface{}
+		switch r.URL.Path {
+		case iotago.NodeAPIRouteInfo:
+			data = &iotago.NodeInfoResponse{IsHealthy: true, ConfirmedMilestoneIndex: cmi.Load(), LatestMilestoneIndex: cmi.Load()}
+		case iotago.NodeAPIRouteReceipts:
+			data = &iotago.ReceiptsResponse{Receipts: receipts}
+		case iotago.NodeAPIRouteTreasury:
+			data = treasury
+		default:
+			msIndex, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/api/v1/milestones/"), 10, 32)
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			data = &iotago.MilestoneResponse{Index: uint32(msIndex), Time: testC2MilestoneTime(uint32(msIndex)).Unix()}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(&iotago.HTTPOkResponseEnvelope{Data: data}))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// the timestamps of the milestones served by the test nodes.
+func testLegacyMilestoneTime(msIndex uint32) time.Time {
+	return time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(msIndex) * time.Minute)
+}
+
+func testC2MilestoneTime(msIndex uint32) time.Time {
+	return time.Date(2021, 4, 29, 0, 0, 0, 0, time.UTC).Add(time.Duration(msIndex) * 10 * time.Second)
+}
+
+func newTestReceipt(msIndex uint32, deposit uint64) *iotago.ReceiptTuple {
+	receipt := &iotago.Receipt{
+		MigratedAt: msIndex,
+		Transaction: &iotago.TreasuryTransaction{
+			Input:  &iotago.TreasuryInput{},
+			Output: &iotago.TreasuryOutput{Amount: 1000000000},
+		},
+	}
+	receipt.Funds = append(receipt.Funds, &iotago.MigratedFundsEntry{Address: &iotago.Ed25519Address{}, Deposit: deposit})
+	return &iotago.ReceiptTuple{MilestoneIndex: msIndex, Receipt: receipt}
+}
+
+func newTestIndexerService(t *testing.T, cfg *HTTPAPIServiceConfig) *IndexerService {
+	cfg.LegacyNode.Timeout = time.Second
+	cfg.C2Node.Timeout = time.Second
+	idxr := NewIndexerService(cfg, NewMigrationIndex(), nil)
+	require.NoError(t, idxr.Init())
+	return idxr
+}
+
+func TestLockingsOfLedgerDiff(t *testing.T) {
+	diff := readLedgerDiffExtFixture(t)
+
+	// funds leaving a migration address are no lockings
+	diff.ConfirmedTxWithValue = append(diff.ConfirmedTxWithValue, &common.TxHashWithValue{
+		TxHash:     testTailTxHash,
+		TailTxHash: testTailTxHash,
+		Address:    fixtureMigrationAddr,
+		Value:      -1000000000,
+	})
+
+	lockings := lockingsOfLedgerDiff(fixtureMilestoneIndex, diff)
+	require.Len(t, lockings, 2)
+	assert.EqualValues(t, 1000000000, lockings[0].Value)
+	assert.Equal(t, "GEJDJTLASCMYOCP9JNADEYHNKAHKPRHWDNRUUZQHQHCEJGZMQQE9HDQHKBZPDSCDQOFWVQQUGUSJBES9B", lockings[0].TailTransactionHash)
+	assert.EqualValues(t, 5000000, lockings[1].Value)
+	for _, locking := range lockings {
+		assert.EqualValues(t, fixtureMilestoneIndex, locking.LegacyMilestoneIndex)
+		assert.Len(t, locking.TargetEd25519Address, 64)
+	}
+}
+
+func TestMigrationIndexApplyLedgerDiff(t *testing.T) {
+	index := NewMigrationIndex()
+	index.setLockedBalances(fixtureMilestoneIndex-1, map[string]uint64{fixtureMigrationAddr: 500000}, 1000000)
+
+	index.applyLedgerDiff(readLedgerDiffExtFixture(t))
+
+	// only the balances of migration addresses are tracked
+	assert.Len(t, index.lockedBalances, 2)
+	assert.EqualValues(t, 1000500000, index.lockedBalances[fixtureMigrationAddr])
+	locked, msIndex, ok := index.LegacyFundsLocked()
+	require.True(t, ok)
+	assert.EqualValues(t, fixtureMilestoneIndex, msIndex)
+	assert.EqualValues(t, 1005500000, locked.TokensTotal)
+	assert.EqualValues(t, 2, locked.MigratedAddressesTotal)
+
+	// a negative balance is clamped to zero
+	index.applyLedgerDiff(&common.GetLedgerDiffExtReturn{Diff: map[string]int64{fixtureMigrationAddr: -2000000000}, MilestoneIndex: fixtureMilestoneIndex + 1})
+	assert.NotContains(t, index.lockedBalances, fixtureMigrationAddr)
+	locked, _, _ = index.LegacyFundsLocked()
+	assert.EqualValues(t, 5000000, locked.TokensTotal)
+	assert.EqualValues(t, 1, locked.MigratedAddressesTotal)
+
+	// the balances can't be derived across skipped milestones
+	index.SkipLegacyMilestones(fixtureMilestoneIndex+2, fixtureMilestoneIndex+2)
+	_, _, ok = index.LegacyFundsLocked()
+	assert.False(t, ok)
+}
+
+func TestMigrationIndexLockedFunds(t *testing.T) {
+	const migrationAddr = "TRANSFERCDJWLVPAIXRWNAPXV9WYKVUZWWKXVBE9JBABJ9D9C9F9OEGADYO9CWDAGZHBRWIXLXG9MAJV9RJEOLXSJW"
+
+	index := NewMigrationIndex()
+	_, _, ok := index.LegacyFundsLocked()
+	assert.False(t, ok)
+
+	// balances below the min amount don't count as locked funds
+	index.setLockedBalances(1, map[string]uint64{migrationAddr: 500000}, 1000000)
+	locked, _, ok := index.LegacyFundsLocked()
+	require.True(t, ok)
+	assert.Equal(t, LegacyFundsLocked{}, locked)
+
+	index.applyLedgerDiff(&common.GetLedgerDiffExtReturn{Diff: map[string]int64{migrationAddr: 1000000}, MilestoneIndex: 2})
+	locked, _, _ = index.LegacyFundsLocked()
+	assert.EqualValues(t, 1500000, locked.TokensTotal)
+	assert.EqualValues(t, 1, locked.MigratedAddressesTotal)
+
+	index.applyLedgerDiff(&common.GetLedgerDiffExtReturn{Diff: map[string]int64{migrationAddr: -1500000}, MilestoneIndex: 3})
+	locked, _, _ = index.LegacyFundsLocked()
+	assert.Equal(t, LegacyFundsLocked{}, locked)
+	assert.Empty(t, index.lockedBalances)
+}
+
+func TestIndexerServiceLegacyStartIndex(t *testing.T) {
+	var tests = []struct {
+		name          string
+		startIndex    int
+		maxMilestones int
+		lsmi          uint32
+		exp           uint32
+	}{
+		{name: "configured", startIndex: 1000, maxMilestones: 20, lsmi: 5000, exp: 1000},
+		{name: "latest milestones", maxMilestones: 20, lsmi: 5000, exp: 4980},
+		{name: "fewer milestones", maxMilestones: 20, lsmi: 10, exp: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idxr := NewIndexerService(&HTTPAPIServiceConfig{
+				MaxMilestonesToQueryForEntries: tt.maxMilestones,
+				Indexer:                        IndexerConfig{LegacyMilestoneStartIndex: tt.startIndex},
+			}, NewMigrationIndex(), nil)
+			assert.Equal(t, tt.exp, idxr.legacyStartIndex(tt.lsmi))
+		})
+	}
+}
+
+func TestIndexerServiceIndexLegacyMilestones(t *testing.T) {
+	legacyNode := newTestLegacyNode(t, fixtureMilestoneIndex+1)
+	idxr := newTestIndexerService(t, &HTTPAPIServiceConfig{
+		MaxMilestonesToQueryForEntries: 3,
+		LegacyNode:                     LegacyNodeConfig{URI: legacyNode.URL},
+		C2Node:                         C2NodeConfig{URI: legacyNode.URL},
+	})
+
+	// the start index is pinned to the LSMI of the first update, even if nothing could be indexed
+	legacyNode.failingFrom.Store(1)
+	require.Error(t, idxr.indexLegacyMilestones(context.Background()))
+	legacyNode.failingFrom.Store(0)
+	legacyNode.lsmi.Store(fixtureMilestoneIndex + 5)
+
+	require.NoError(t, idxr.indexLegacyMilestones(context.Background()))
+	legacyIndex, _ := idxr.index.MilestoneIndexes()
+	assert.EqualValues(t, fixtureMilestoneIndex+5, legacyIndex)
+	assert.EqualValues(t, fixtureMilestoneIndex-1, idxr.index.firstLegacyMilestoneIndex)
+
+	lockings, _ := idxr.index.RecentlyLocked(PageQuery{Cursor: -1, Size: 10})
+	require.Len(t, lockings, 2)
+	for _, locking := range lockings {
+		assert.EqualValues(t, fixtureMilestoneIndex, locking.LegacyMilestoneIndex)
+	}
+}
+
+func TestIndexerServiceSkipsInconsistentLegacyMilestones(t *testing.T) {
+	legacyNode := newTestLegacyNode(t, fixtureMilestoneIndex+1)
+	legacyNode.inconsistentAt.Store(fixtureMilestoneIndex + 2)
+	idxr := newTestIndexerService(t, &HTTPAPIServiceConfig{
+		MaxMilestonesToQueryForEntries: 3,
+		LegacyNode:                     LegacyNodeConfig{URI: legacyNode.URL},
+		C2Node:                         C2NodeConfig{URI: legacyNode.URL},
+	})
+	require.NoError(t, idxr.indexLegacyMilestones(context.Background()))
+
+	// the inconsistent milestone is skipped instead of failing every following update
+	legacyNode.lsmi.Store(fixtureMilestoneIndex + 5)
+	require.NoError(t, idxr.indexLegacyMilestones(context.Background()))
+	legacyIndex, _ := idxr.index.MilestoneIndexes()
+	assert.EqualValues(t, fixtureMilestoneIndex+5, legacyIndex)
+	assert.Equal(t, []MilestoneRange{{From: fixtureMilestoneIndex + 2, To: fixtureMilestoneIndex + 2}}, idxr.index.PrunedLegacyMilestones())
+
+	// the lockings confirmed before the inconsistent milestone are still indexed
+	lockings, _ := idxr.index.RecentlyLocked(PageQuery{Cursor: -1, Size: 10})
+	assert.Len(t, lockings, 2)
+}
+
+func TestIndexerServiceIndexReceipts(t *testing.T) {
+	var cmi atomic.Uint32
+	cmi.Store(12)
+	c2Node := newTestReceiptsNode(t, &cmi, []*iotago.ReceiptTuple{
+		newTestReceipt(12, 3000000), newTestReceipt(5, 1000000), newTestReceipt(15, 4000000), newTestReceipt(9, 2000000),
+	})
+	idxr := newTestIndexerService(t, &HTTPAPIServiceConfig{
+		MaxMilestonesToQueryForEntries: 3,
+		LegacyNode:                     LegacyNodeConfig{URI: c2Node.URL},
+		C2Node:                         C2NodeConfig{URI: c2Node.URL},
+	})
+
+	embeddedIndexes := func() []uint32 {
+		receipts, _ := idxr.index.RecentlyMinted(PageQuery{Cursor: -1, Size: 10})
+		var indexes []uint32
+		for _, receipt := range receipts {
+			indexes = append(indexes, receipt.EmbeddedMilestoneIndex)
+		}
+		return indexes
+	}
+
+	// receipts beyond the confirmed milestone are left for the next update
+	require.NoError(t, idxr.indexReceipts(context.Background()))
+	assert.Equal(t, []uint32{12, 9, 5}, embeddedIndexes())
+
+	// nothing changes without a new confirmed milestone
+	require.NoError(t, idxr.indexReceipts(context.Background()))
+	assert.Equal(t, []uint32{12, 9, 5}, embeddedIndexes())
+
+	cmi.Store(20)
+	require.NoError(t, idxr.indexReceipts(context.Background()))
+	assert.Equal(t, []uint32{15, 12, 9, 5}, embeddedIndexes())
+	_, c2Index := idxr.index.MilestoneIndexes()
+	assert.EqualValues(t, 20, c2Index)
+}
+
+func TestIndexerServiceHistory(t *testing.T) {
+	legacyNode := newTestLegacyNode(t, fixtureMilestoneIndex)
+	var cmi atomic.Uint32
+	cmi.Store(12)
+	c2Node := newTestReceiptsNode(t, &cmi, []*iotago.ReceiptTuple{newTestReceipt(5, 1000000), newTestReceipt(9, 2000000)})
+	idxr := newTestIndexerService(t, &HTTPAPIServiceConfig{
+		MaxMilestonesToQueryForEntries: 2,
+		LegacyNode:                     LegacyNodeConfig{URI: legacyNode.URL},
+		C2Node:                         C2NodeConfig{URI: c2Node.URL},
+	})
+	history, err := OpenHistoryStore(filepath.Join(t.TempDir(), "history.jsonl"))
+	require.NoError(t, err)
+	defer history.Close()
+	idxr.history = history
+
+	require.NoError(t, idxr.update(context.Background()))
+	// nothing is recorded twice
+	require.NoError(t, idxr.update(context.Background()))
+
+	// the points carry the timestamps of their milestones rather than the time of the catch-up
+	points, err := history.Range(time.Time{}, time.Now(), 0)
+	require.NoError(t, err)
+	require.Len(t, points, 4)
+	for i, exp := range []struct {
+		time        time.Time
+		legacyIndex uint32
+		c2Index     uint32
+	}{
+		{testLegacyMilestoneTime(fixtureMilestoneIndex - 1), fixtureMilestoneIndex - 1, 0},
+		{testLegacyMilestoneTime(fixtureMilestoneIndex), fixtureMilestoneIndex, 0},
+		{testC2MilestoneTime(5), fixtureMilestoneIndex, 5},
+		{testC2MilestoneTime(9), fixtureMilestoneIndex, 9},
+	} {
+		assert.True(t, exp.time.Equal(points[i].Time), "point %d", i)
+		assert.Equal(t, exp.legacyIndex, points[i].LegacyMilestoneIndex, "point %d", i)
+		assert.Equal(t, exp.c2Index, points[i].C2MilestoneIndex, "point %d", i)
+	}
+	assert.EqualValues(t, 1005000000, points[3].LegacyFundsLocked.TokensTotal)
+	assert.EqualValues(t, 1000000000, points[3].TreasuryTokens)
+}
+
+func TestIndexerServiceReceiptsCatchUpEvents(t *testing.T) {
+	var cmi atomic.Uint32
+	cmi.Store(12)
+	c2Node := newTestReceiptsNode(t, &cmi, []*iotago.ReceiptTuple{
+		newTestReceipt(5, 1000000), newTestReceipt(9, 2000000), newTestReceipt(15, 4000000),
+	})
+	idxr := newTestIndexerService(t, &HTTPAPIServiceConfig{
+		LegacyNode: LegacyNodeConfig{URI: c2Node.URL},
+		C2Node:     C2NodeConfig{URI: c2Node.URL},
+	})
+	events, unsubscribe := idxr.index.Events().Subscribe()
+	defer unsubscribe()
+
+	// the receipts issued before the first update are indexed without being published
+	require.NoError(t, idxr.indexReceipts(context.Background()))
+	receipts, _ := idxr.index.RecentlyMinted(PageQuery{Cursor: -1, Size: 10})
+	assert.Len(t, receipts, 2)
+	assert.Empty(t, events)
+
+	cmi.Store(20)
+	require.NoError(t, idxr.indexReceipts(context.Background()))
+	require.Len(t, events, 1)
+}
+
+func TestIndexerServiceShutdownAbortsCatchUp(t *testing.T) {
+	legacyNode := newTestLegacyNode(t, fixtureMilestoneIndex)
+	legacyNode.hanging.Store(true)
+	var cmi atomic.Uint32
+	c2Node := newTestReceiptsNode(t, &cmi, nil)
+	idxr := newTestIndexerService(t, &HTTPAPIServiceConfig{
+		MaxMilestonesToQueryForEntries: 3,
+		LegacyNode:                     LegacyNodeConfig{URI: legacyNode.URL},
+		C2Node:                         C2NodeConfig{URI: c2Node.URL},
+	})
+	idxr.cfg.LegacyNode.Timeout = time.Hour
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- idxr.Run() }()
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, idxr.Shutdown(ctx))
+	require.NoError(t, <-runErr)
+	legacyIndex, _ := idxr.index.MilestoneIndexes()
+	assert.Zero(t, legacyIndex)
+}
+
+func TestIndexerServiceCatchUpEvents(t *testing.T) {
+	var tests = []struct {
+		name      string
+		firstLSMI uint32
+		expEvents int
+	}{
+		{name: "catch-up", firstLSMI: fixtureMilestoneIndex + 1},
+		{name: "caught up", firstLSMI: fixtureMilestoneIndex - 1, expEvents: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			legacyNode := newTestLegacyNode(t, tt.firstLSMI)
+			idxr := newTestIndexerService(t, &HTTPAPIServiceConfig{
+				Indexer:    IndexerConfig{LegacyMilestoneStartIndex: fixtureMilestoneIndex - 2},
+				LegacyNode: LegacyNodeConfig{URI: legacyNode.URL},
+				C2Node:     C2NodeConfig{URI: legacyNode.URL},
+			})
+			events, unsubscribe := idxr.index.Events().Subscribe()
+			defer unsubscribe()
+
+			require.NoError(t, idxr.indexLegacyMilestones(context.Background()))
+			legacyNode.lsmi.Store(fixtureMilestoneIndex + 1)
+			require.NoError(t, idxr.indexLegacyMilestones(context.Background()))
+
+			// the lockings are indexed either way, but only published once caught up
+			lockings, _ := idxr.index.RecentlyLocked(PageQuery{Cursor: -1, Size: 10})
+			assert.Len(t, lockings, 2)
+			assert.Len(t, events, tt.expEvents)
+		})
+	}
+}
+
+func TestIndexerServiceReady(t *testing.T) {
+	legacyNode := newTestLegacyNode(t, fixtureMilestoneIndex+1)
+	idxr := newTestIndexerService(t, &HTTPAPIServiceConfig{
+		Indexer:    IndexerConfig{LegacyMilestoneStartIndex: fixtureMilestoneIndex - 1},
+		LegacyNode: LegacyNodeConfig{URI: legacyNode.URL},
+		C2Node:     C2NodeConfig{URI: legacyNode.URL},
+	})
+
+	err := idxr.Ready(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, "waiting for the first update", err.Error())
+
+	// the catch-up fails after indexing the first milestone
+	legacyNode.failingFrom.Store(fixtureMilestoneIndex + 1)
+	require.Error(t, idxr.indexLegacyMilestones(context.Background()))
+	err = idxr.Ready(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, fmt.Sprintf("catching up: indexed up to legacy milestone %d", fixtureMilestoneIndex), err.Error())
+
+	legacyNode.failingFrom.Store(0)
+	require.NoError(t, idxr.indexLegacyMilestones(context.Background()))
+	assert.NoError(t, idxr.Ready(context.Background()))
+}
@@ -0,0 +1,446 @@
+package migration
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/iotaledger/chrysalis-tools/common"
+	"github.com/iotaledger/iota.go/address"
+	"github.com/iotaledger/iota.go/transaction"
+	"github.com/iotaledger/iota.go/trinary"
+	iotago "github.com/iotaledger/iota.go/v2"
+)
+
+// the kinds of integrity violations.
+const (
+	// a tail transaction hash was migrated multiple times.
+	ViolationDuplicateEntry = "duplicateEntry"
+	// a receipt entry does not correspond to a migration bundle included by the legacy milestone.
+	ViolationUnknownBundle = "unknownBundle"
+	// a receipt entry differs in value or target address from its migration bundle.
+	ViolationEntryMismatch = "entryMismatch"
+	// a migration bundle included by the legacy milestone is missing in the final receipt.
+	ViolationMissingEntry = "missingEntry"
+	// the output of a treasury transaction does not equal the previous output minus the migrated funds.
+	ViolationTreasuryAmount = "treasuryAmount"
+	// the input of a treasury transaction does not reference the milestone of the previous receipt.
+	ViolationTreasuryInput = "treasuryInput"
+	// the current treasury does not equal the output of the last receipt.
+	ViolationTreasuryState = "treasuryState"
+	// a receipt or the white-flag confirmation of its legacy milestone is malformed.
+	ViolationMalformed = "malformed"
+)
+
+// IntegrityReport is the result of the verification of all receipts.
+type IntegrityReport struct {
+	// Whether no violations were found.
+	OK bool `json:"ok"`
+	// The amount of checked receipts, including the unverifiable ones.
+	ReceiptsChecked int `json:"receiptsChecked"`
+	// The amount of checked receipt entries, including the ones of unverifiable receipts.
+	EntriesChecked int `json:"entriesChecked"`
+	// The found violations.
+	Violations []*IntegrityViolation `json:"violations"`
+	// The receipts whose entries couldn't be verified against the white-flag confirmation of their legacy milestone.
+	UnverifiableReceipts []*UnverifiableReceipt `json:"unverifiableReceipts"`
+}
+
+// IntegrityViolation describes a single violation found while verifying the receipts.
+type IntegrityViolation struct {
+	// The kind of the violation.
+	Kind string `json:"kind"`
+	// The C2 milestone containing the violating receipt.
+	EmbeddedMilestoneIndex uint32 `json:"embeddedMilestoneIndex,omitempty"`
+	// The legacy milestone at which the funds were migrated.
+	LegacyMilestoneIndex uint32 `json:"legacyMilestoneIndex,omitempty"`
+	// The tail transaction hash in trytes of the violating entry or bundle.
+	TailTransactionHash trinary.Hash `json:"tailTransactionHash,omitempty"`
+	// A human readable description of the violation.
+	Message string `json:"message"`
+}
+
+// UnverifiableReceipt is a receipt whose entries couldn't be verified against the white-flag confirmation of its legacy milestone.
+type UnverifiableReceipt struct {
+	// The C2 milestone containing the receipt.
+	EmbeddedMilestoneIndex uint32 `json:"embeddedMilestoneIndex"`
+	// The legacy milestone at which the funds were migrated.
+	LegacyMilestoneIndex uint32 `json:"legacyMilestoneIndex"`
+	// Why the receipt couldn't be verified.
+	Reason string `json:"reason"`
+}
+
+// a migration output of a bundle included by a legacy milestone.
+type expectedEntry struct {
+	value                uint64
+	targetEd25519Address string
+	// whether a receipt entry was matched against the output.
+	matched bool
+}
+
+// NewReceiptVerifier creates a new ReceiptVerifier querying the given node pools.
+func NewReceiptVerifier(legacyNodes *LegacyNodePool, c2Nodes *C2NodePool) *ReceiptVerifier {
+	return &ReceiptVerifier{
+		legacyNodes:     legacyNodes,
+		c2Nodes:         c2Nodes,
+		violations:      make([]*IntegrityViolation, 0),
+		unverifiable:    make([]*UnverifiableReceipt, 0),
+		expectedEntries: make(map[uint32]map[trinary.Hash][]*expectedEntry),
+		seenTails:       make(map[trinary.Hash]uint32),
+		chain:           &treasuryChain{},
+	}
+}
+
+// ReceiptVerifier verifies the receipts of the C2 network against the white-flag confirmations of their legacy milestones
+// and checks that the treasury transactions chain correctly. As confirmed receipts never change, every receipt is only
+// verified once and its result is kept, so that subsequent verifications only query the nodes for new receipts.
+type ReceiptVerifier struct {
+	legacyNodes *LegacyNodePool
+	c2Nodes     *C2NodePool
+
+	// serializes the verifications.
+	mu sync.Mutex
+	// the C2 milestone up to which the receipts were verified.
+	verifiedUpTo uint32
+	// the amount of checked receipts and entries.
+	receiptsChecked int
+	entriesChecked  int
+	// the violations found in the verified receipts.
+	violations []*IntegrityViolation
+	// the verified receipts which couldn't be checked against their white-flag confirmation.
+	unverifiable []*UnverifiableReceipt
+	// the expected entries of every legacy milestone referenced by the verified receipts, nil if it is pruned.
+	expectedEntries map[uint32]map[trinary.Hash][]*expectedEntry
+	// the C2 milestones of the verified entries by their tail transaction hash.
+	seenTails map[trinary.Hash]uint32
+	// the treasury transactions of the verified receipts.
+	chain *treasuryChain
+}
+
+// Verify verifies the receipts issued since the last verification and returns the report over all receipts.
+// Receipts issued after the current treasury are left to the next verification, so that the treasury is always
+// verified against the receipt which created it.
+// Violations are part of the returned report, an error is only returned if a node could not be queried.
+func (v *ReceiptVerifier) Verify(ctx context.Context) (*IntegrityReport, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var receipts []*iotago.ReceiptTuple
+	var treasury *iotago.TreasuryResponse
+	if err := v.c2Nodes.Do(ctx, func(node *C2Node) error {
+		var err error
+		// the treasury is queried before the receipts, so that every receipt it reflects is among the receipts
+		if treasury, err = node.API.Treasury(ctx); err != nil {
+			return err
+		}
+		receipts, err = node.API.Receipts(ctx)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("unable to retrieve receipts and treasury from C2 node: %w", err)
+	}
+
+	sort.SliceStable(receipts, func(i, j int) bool {
+		return receipts[i].MilestoneIndex < receipts[j].MilestoneIndex
+	})
+
+	for _, receipt := range receipts {
+		if receipt.MilestoneIndex <= v.verifiedUpTo {
+			continue
+		}
+		if spendsTreasury(receipt, treasury) {
+			// the receipt and the following ones were issued after the treasury was queried,
+			// they are verified along with the treasury they created by the next verification
+			break
+		}
+		if err := v.verifyReceipt(ctx, receipt); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &IntegrityReport{
+		ReceiptsChecked:      v.receiptsChecked,
+		EntriesChecked:       v.entriesChecked,
+		Violations:           append(make([]*IntegrityViolation, 0, len(v.violations)), v.violations...),
+		UnverifiableReceipts: append(make([]*UnverifiableReceipt, 0, len(v.unverifiable)), v.unverifiable...),
+	}
+	report.Violations = append(report.Violations, v.chain.verifyState(treasury)...)
+	report.OK = len(report.Violations) == 0
+	return report, nil
+}
+
+// tells whether the treasury transaction of the given receipt spends the given treasury,
+// i.e. whether the receipt is newer than the treasury.
+func spendsTreasury(receipt *iotago.ReceiptTuple, treasury *iotago.TreasuryResponse) bool {
+	treasuryTx, ok := receipt.Receipt.Transaction.(*iotago.TreasuryTransaction)
+	if !ok || treasury == nil {
+		return false
+	}
+	input, ok := treasuryTx.Input.(*iotago.TreasuryInput)
+	return ok && hex.EncodeToString(input[:]) == treasury.MilestoneID
+}
+
+// verifies the given receipt and adds its results. all nodes are queried before any result is added,
+// so that a failing query leaves the verifier at the previous receipt.
+func (v *ReceiptVerifier) verifyReceipt(ctx context.Context, receipt *iotago.ReceiptTuple) error {
+	r := receipt.Receipt
+
+	var violations []*IntegrityViolation
+	expected, has := v.expectedEntries[r.MigratedAt]
+	if !has {
+		var wfConf *common.GetWhiteFlagConfirmationResponse
+		err := v.legacyNodes.DoAt(ctx, r.MigratedAt, func(node *LegacyNode) error {
+			var err error
+			wfConf, err = node.Client.WhiteFlagConfirmation(ctx, int(r.MigratedAt))
+			return err
+		})
+		switch {
+		case errors.Is(err, ErrMilestonePruned):
+			expected = nil
+		case err != nil:
+			return fmt.Errorf("unable to query white-flag confirmation of legacy milestone %d: %w", r.MigratedAt, err)
+		default:
+			if expected, err = expectedEntriesOf(wfConf); err != nil {
+				violations = append(violations, &IntegrityViolation{
+					Kind:                   ViolationMalformed,
+					EmbeddedMilestoneIndex: receipt.MilestoneIndex,
+					LegacyMilestoneIndex:   r.MigratedAt,
+					Message:                err.Error(),
+				})
+				expected = make(map[trinary.Hash][]*expectedEntry)
+			}
+		}
+	}
+
+	chainViolations, err := v.chain.verify(ctx, v.c2Nodes, receipt)
+	if err != nil {
+		return err
+	}
+
+	v.expectedEntries[r.MigratedAt] = expected
+	if expected == nil {
+		v.unverifiable = append(v.unverifiable, &UnverifiableReceipt{
+			EmbeddedMilestoneIndex: receipt.MilestoneIndex,
+			LegacyMilestoneIndex:   r.MigratedAt,
+			Reason:                 fmt.Sprintf("unverifiable (pruned): the white-flag confirmation of legacy milestone %d is pruned on all legacy nodes", r.MigratedAt),
+		})
+	}
+
+	for _, seri := range r.Funds {
+		entry := seri.(*iotago.MigratedFundsEntry)
+		for _, violation := range verifyReceiptEntry(receipt.MilestoneIndex, entry, expected, v.seenTails) {
+			violation.EmbeddedMilestoneIndex = receipt.MilestoneIndex
+			violation.LegacyMilestoneIndex = r.MigratedAt
+			violations = append(violations, violation)
+		}
+	}
+
+	if r.Final {
+		for tailTxHash, entries := range expected {
+			for _, e := range entries {
+				if e.matched {
+					continue
+				}
+				violations = append(violations, &IntegrityViolation{
+					Kind:                   ViolationMissingEntry,
+					EmbeddedMilestoneIndex: receipt.MilestoneIndex,
+					LegacyMilestoneIndex:   r.MigratedAt,
+					TailTransactionHash:    tailTxHash,
+					Message:                fmt.Sprintf("migration of %d tokens to %s is missing in the final receipt", e.value, e.targetEd25519Address),
+				})
+			}
+		}
+	}
+
+	v.violations = append(v.violations, violations...)
+	v.violations = append(v.violations, chainViolations...)
+	v.receiptsChecked++
+	v.entriesChecked += len(r.Funds)
+	v.verifiedUpTo = receipt.MilestoneIndex
+	return nil
+}
+
+// verifies the given receipt entry of the receipt in the given C2 milestone against the expected entries of its legacy milestone.
+// seenTails holds the C2 milestones of the previously verified entries by their tail transaction hash.
+// if expected is nil, as the white-flag confirmation of the legacy milestone is pruned, only duplicates are detected.
+func verifyReceiptEntry(msIndex uint32, entry *iotago.MigratedFundsEntry, expected map[trinary.Hash][]*expectedEntry, seenTails map[trinary.Hash]uint32) []*IntegrityViolation {
+	tailTxHash, err := tailTransactionHashFromT5B1(entry.TailTransactionHash[:])
+	if err != nil {
+		return []*IntegrityViolation{{
+			Kind:    ViolationMalformed,
+			Message: fmt.Sprintf("tail transaction hash %s: %v", hex.EncodeToString(entry.TailTransactionHash[:]), err),
+		}}
+	}
+
+	edAddr, ok := entry.Address.(*iotago.Ed25519Address)
+	if !ok {
+		return []*IntegrityViolation{{
+			Kind:                ViolationMalformed,
+			TailTransactionHash: tailTxHash,
+			Message:             fmt.Sprintf("entry targets a non Ed25519 address %s", entry.Address),
+		}}
+	}
+	target := hex.EncodeToString(edAddr[:])
+
+	var violations []*IntegrityViolation
+	if seenIn, seen := seenTails[tailTxHash]; seen {
+		violations = append(violations, &IntegrityViolation{
+			Kind:                ViolationDuplicateEntry,
+			TailTransactionHash: tailTxHash,
+			Message:             fmt.Sprintf("tail tx was already migrated by the receipt in C2 milestone %d", seenIn),
+		})
+	}
+	seenTails[tailTxHash] = msIndex
+
+	if expected == nil {
+		return violations
+	}
+
+	candidates, has := expected[tailTxHash]
+	if !has {
+		return append(violations, &IntegrityViolation{
+			Kind:                ViolationUnknownBundle,
+			TailTransactionHash: tailTxHash,
+			Message:             "no migration bundle with this tail tx was included by the legacy milestone",
+		})
+	}
+
+	for _, e := range candidates {
+		if e.matched || e.value != entry.Deposit || e.targetEd25519Address != target {
+			continue
+		}
+		e.matched = true
+		return violations
+	}
+
+	return append(violations, &IntegrityViolation{
+		Kind:                ViolationEntryMismatch,
+		TailTransactionHash: tailTxHash,
+		Message:             fmt.Sprintf("entry of %d tokens to %s does not match any migration output of its bundle", entry.Deposit, target),
+	})
+}
+
+// computes the migration outputs of the bundles included by the given white-flag confirmation by their tail transaction hash.
+func expectedEntriesOf(wfConf *common.GetWhiteFlagConfirmationResponse) (map[trinary.Hash][]*expectedEntry, error) {
+	expected := make(map[trinary.Hash][]*expectedEntry)
+	for i, rawTrytes := range wfConf.IncludedBundles {
+		bndl, err := transaction.AsTransactionObjects(rawTrytes, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse included bundle %d: %w", i, err)
+		}
+
+		var tailTxHash trinary.Hash
+		for j := range bndl {
+			if bndl[j].CurrentIndex == 0 {
+				tailTxHash = bndl[j].Hash
+			}
+		}
+		if len(tailTxHash) == 0 {
+			return nil, fmt.Errorf("included bundle %d has no tail transaction", i)
+		}
+
+		for j := range bndl {
+			tx := &bndl[j]
+			if tx.Value < iotago.MinMigratedFundsEntryDeposit {
+				continue
+			}
+
+			edAddr, err := address.ParseMigrationAddress(tx.Address)
+			if err != nil {
+				continue
+			}
+
+			expected[tailTxHash] = append(expected[tailTxHash], &expectedEntry{
+				value:                uint64(tx.Value),
+				targetEd25519Address: hex.EncodeToString(edAddr[:]),
+			})
+		}
+	}
+	return expected, nil
+}
+
+// the treasury transactions of the verified receipts, used to verify that the next one chains correctly.
+type treasuryChain struct {
+	// the output of the treasury transaction of the last receipt, nil if it was malformed.
+	prevOutput *iotago.TreasuryOutput
+	// the ID of the milestone of the last receipt, nil if it was malformed or pruned.
+	prevMilestoneID *iotago.MilestoneID
+}
+
+// verifies that the treasury transaction of the given receipt chains to the one of the previous receipt
+// and adds it to the chain. receipts must be verified in the order of their C2 milestone.
+// the chain is left unchanged if an error is returned.
+func (tc *treasuryChain) verify(ctx context.Context, c2Nodes *C2NodePool, receipt *iotago.ReceiptTuple) ([]*IntegrityViolation, error) {
+	treasuryTx, ok := receipt.Receipt.Transaction.(*iotago.TreasuryTransaction)
+	if !ok {
+		tc.prevOutput, tc.prevMilestoneID = nil, nil
+		return []*IntegrityViolation{{
+			Kind:                   ViolationMalformed,
+			EmbeddedMilestoneIndex: receipt.MilestoneIndex,
+			Message:                "receipt does not contain a treasury transaction",
+		}}, nil
+	}
+	input := treasuryTx.Input.(*iotago.TreasuryInput)
+	output := treasuryTx.Output.(*iotago.TreasuryOutput)
+
+	var violations []*IntegrityViolation
+	if tc.prevOutput != nil && tc.prevOutput.Amount != output.Amount+receipt.Receipt.Sum() {
+		violations = append(violations, &IntegrityViolation{
+			Kind:                   ViolationTreasuryAmount,
+			EmbeddedMilestoneIndex: receipt.MilestoneIndex,
+			Message:                fmt.Sprintf("treasury output of %d tokens plus the migrated %d tokens does not equal the previous output of %d tokens", output.Amount, receipt.Receipt.Sum(), tc.prevOutput.Amount),
+		})
+	}
+
+	if tc.prevMilestoneID != nil && *tc.prevMilestoneID != iotago.MilestoneID(*input) {
+		violations = append(violations, &IntegrityViolation{
+			Kind:                   ViolationTreasuryInput,
+			EmbeddedMilestoneIndex: receipt.MilestoneIndex,
+			Message:                fmt.Sprintf("treasury input %s does not reference the milestone %s of the previous receipt", hex.EncodeToString(input[:]), hex.EncodeToString(tc.prevMilestoneID[:])),
+		})
+	}
+
+	var milestone *iotago.Milestone
+	err := c2Nodes.DoAt(ctx, receipt.MilestoneIndex, func(node *C2Node) error {
+		var err error
+		milestone, err = queryC2Milestone(ctx, node.API, receipt.MilestoneIndex)
+		return err
+	})
+	switch {
+	case errors.Is(err, ErrMilestonePruned):
+		// the input of the next receipt can't be verified
+		tc.prevOutput, tc.prevMilestoneID = output, nil
+		return violations, nil
+	case err != nil:
+		return nil, err
+	}
+
+	msID, err := milestone.ID()
+	if err != nil {
+		return nil, fmt.Errorf("unable to compute ID of C2 milestone %d: %w", receipt.MilestoneIndex, err)
+	}
+
+	tc.prevOutput, tc.prevMilestoneID = output, msID
+	return violations, nil
+}
+
+// verifies that the given current treasury is the output of the treasury transaction of the last receipt.
+func (tc *treasuryChain) verifyState(treasury *iotago.TreasuryResponse) []*IntegrityViolation {
+	if tc.prevOutput == nil || treasury == nil {
+		return nil
+	}
+
+	if treasury.Amount != tc.prevOutput.Amount || (tc.prevMilestoneID != nil && treasury.MilestoneID != hex.EncodeToString(tc.prevMilestoneID[:])) {
+		prevMilestoneID := "unknown"
+		if tc.prevMilestoneID != nil {
+			prevMilestoneID = hex.EncodeToString(tc.prevMilestoneID[:])
+		}
+		return []*IntegrityViolation{{
+			Kind:    ViolationTreasuryState,
+			Message: fmt.Sprintf("current treasury of %d tokens from milestone %s does not equal the output of %d tokens of the last receipt's milestone %s", treasury.Amount, treasury.MilestoneID, tc.prevOutput.Amount, prevMilestoneID),
+		}}
+	}
+	return nil
+}
@@ -0,0 +1,421 @@
+package migration
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/chrysalis-tools/common"
+	"github.com/iotaledger/hive.go/serializer"
+	"github.com/iotaledger/iota.go/address"
+	"github.com/iotaledger/iota.go/consts"
+	"github.com/iotaledger/iota.go/encoding/t5b1"
+	"github.com/iotaledger/iota.go/transaction"
+	"github.com/iotaledger/iota.go/trinary"
+	iotago "github.com/iotaledger/iota.go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyReceiptEntry(t *testing.T) {
+	const target = "efdc112efe262b304bcf379b26c31bad029f616ee3ec4aa6345a366e4c9e43a3"
+
+	newEntry := func(deposit uint64) *iotago.MigratedFundsEntry {
+		addr := &iotago.Ed25519Address{}
+		addrBytes, err := hex.DecodeString(target)
+		require.NoError(t, err)
+		copy(addr[:], addrBytes)
+
+		entry := &iotago.MigratedFundsEntry{Address: addr, Deposit: deposit}
+		copy(entry.TailTransactionHash[:], t5b1.EncodeTrytes(testTailTxHash))
+		return entry
+	}
+
+	newExpected := func() map[trinary.Hash][]*expectedEntry {
+		return map[trinary.Hash][]*expectedEntry{
+			testTailTxHash: {{value: 5000000, targetEd25519Address: target}},
+		}
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		expected := newExpected()
+		seenTails := map[trinary.Hash]uint32{}
+		assert.Empty(t, verifyReceiptEntry(10000, newEntry(5000000), expected, seenTails))
+		assert.True(t, expected[testTailTxHash][0].matched)
+		assert.EqualValues(t, 10000, seenTails[testTailTxHash])
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		violations := verifyReceiptEntry(10000, newEntry(4000000), newExpected(), map[trinary.Hash]uint32{})
+		require.Len(t, violations, 1)
+		assert.Equal(t, ViolationEntryMismatch, violations[0].Kind)
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		expected := newExpected()
+		seenTails := map[trinary.Hash]uint32{}
+		assert.Empty(t, verifyReceiptEntry(10000, newEntry(5000000), expected, seenTails))
+
+		violations := verifyReceiptEntry(10001, newEntry(5000000), expected, seenTails)
+		require.Len(t, violations, 2)
+		assert.Equal(t, ViolationDuplicateEntry, violations[0].Kind)
+		assert.Equal(t, ViolationEntryMismatch, violations[1].Kind)
+	})
+
+	t.Run("unknown bundle", func(t *testing.T) {
+		violations := verifyReceiptEntry(10000, newEntry(5000000), map[trinary.Hash][]*expectedEntry{}, map[trinary.Hash]uint32{})
+		require.Len(t, violations, 1)
+		assert.Equal(t, ViolationUnknownBundle, violations[0].Kind)
+		assert.Equal(t, testTailTxHash, violations[0].TailTransactionHash)
+	})
+}
+
+// a C2 node serving milestones and receipts.
+type testMilestonesNode struct {
+	*httptest.Server
+	// the IDs of the served milestones by their index.
+	milestoneIDs map[uint32]*iotago.MilestoneID
+	// the amount of milestone queries.
+	milestoneCalls atomic.Int32
+	// the served receipts and treasury.
+	receipts atomic.Pointer[[]*iotago.ReceiptTuple]
+	treasury atomic.Pointer[iotago.TreasuryResponse]
+}
+
+// a C2 node serving milestones with the given indexes.
+func newTestMilestonesNode(t *testing.T, indexes ...uint32) *testMilestonesNode {
+	node := &testMilestonesNode{milestoneIDs: make(map[uint32]*iotago.MilestoneID)}
+	messageIDs := make(map[uint32]string)
+	rawMessages := make(map[string][]byte)
+	for _, index := range indexes {
+		milestone := &iotago.Milestone{
+			Index:      index,
+			Parents:    iotago.MilestoneParentMessageIDs{{}},
+			PublicKeys: []iotago.MilestonePublicKey{{}},
+			Signatures: []iotago.MilestoneSignature{{}},
+		}
+		msID, err := milestone.ID()
+		require.NoError(t, err)
+		node.milestoneIDs[index] = msID
+
+		msg := &iotago.Message{Parents: iotago.MessageIDs{{}}, Payload: milestone}
+		msgID, err := msg.ID()
+		require.NoError(t, err)
+		rawMessages[hex.EncodeToString(msgID[:])], err = msg.Serialize(serializer.DeSeriModePerformValidation)
+		require.NoError(t, err)
+		messageIDs[index] = hex.EncodeToString(msgID[:])
+	}
+
+	node.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data interface{}
+		switch r.URL.Path {
+		case iotago.NodeAPIRouteInfo:
+			data = &iotago.NodeInfoResponse{IsHealthy: true, ConfirmedMilestoneIndex: 100}
+		case iotago.NodeAPIRouteReceipts:
+			data = &iotago.ReceiptsResponse{Receipts: *node.receipts.Load()}
+		case iotago.NodeAPIRouteTreasury:
+			data = node.treasury.Load()
+		}
+		if data != nil {
+			w.Header().Set("Content-Type", "application/json")
+			require.NoError(t, json.NewEncoder(w).Encode(&iotago.HTTPOkResponseEnvelope{Data: data}))
+			return
+		}
+		if index, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/api/v1/milestones/"), 10, 32); err == nil {
+			node.milestoneCalls.Add(1)
+			if msgID, has := messageIDs[uint32(index)]; has {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = fmt.Fprintf(w, `{"data": {"index": %d, "messageId": "%s"}}`, index, msgID)
+				return
+			}
+		}
+		if raw, has := rawMessages[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/messages/"), "/raw")]; has {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write(raw)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(node.Close)
+	return node
+}
+
+// a receipt in the given milestone migrating the given deposit at the given legacy milestone, whose treasury
+// transaction spends the treasury of the given milestone (none if zero) and leaves the given amount.
+func (node *testMilestonesNode) newReceipt(msIndex uint32, migratedAt uint32, inputOf uint32, deposit uint64, output uint64) *iotago.ReceiptTuple {
+	input := &iotago.TreasuryInput{}
+	if inputOf != 0 {
+		copy(input[:], node.milestoneIDs[inputOf][:])
+	}
+	receipt := &iotago.Receipt{
+		MigratedAt:  migratedAt,
+		Transaction: &iotago.TreasuryTransaction{Input: input, Output: &iotago.TreasuryOutput{Amount: output}},
+	}
+	if deposit != 0 {
+		receipt.Funds = append(receipt.Funds, &iotago.MigratedFundsEntry{Address: &iotago.Ed25519Address{}, Deposit: deposit})
+	}
+	return &iotago.ReceiptTuple{MilestoneIndex: msIndex, Receipt: receipt}
+}
+
+// the treasury created by the given milestone.
+func (node *testMilestonesNode) treasuryOf(msIndex uint32, amount uint64) *iotago.TreasuryResponse {
+	return &iotago.TreasuryResponse{MilestoneID: hex.EncodeToString(node.milestoneIDs[msIndex][:]), Amount: amount}
+}
+
+func TestTreasuryChain(t *testing.T) {
+	c2Node := newTestMilestonesNode(t, 10, 20, 30)
+	c2Nodes, err := NewC2NodePool(&C2NodeConfig{URI: c2Node.URL, Timeout: time.Second})
+	require.NoError(t, err)
+
+	newReceipt := func(msIndex uint32, inputOf uint32, deposit uint64, output uint64) *iotago.ReceiptTuple {
+		return c2Node.newReceipt(msIndex, msIndex, inputOf, deposit, output)
+	}
+
+	var tests = []struct {
+		name          string
+		receipts      []*iotago.ReceiptTuple
+		treasury      *iotago.TreasuryResponse
+		expViolations []string
+	}{
+		{
+			name:     "ok",
+			receipts: []*iotago.ReceiptTuple{newReceipt(10, 0, 1000000, 9000000), newReceipt(20, 10, 2000000, 7000000), newReceipt(30, 20, 3000000, 4000000)},
+			treasury: c2Node.treasuryOf(30, 4000000),
+		},
+		{
+			name:          "broken chain",
+			receipts:      []*iotago.ReceiptTuple{newReceipt(10, 0, 1000000, 9000000), newReceipt(20, 0, 2000000, 7000000), newReceipt(30, 10, 3000000, 4000000)},
+			treasury:      c2Node.treasuryOf(30, 4000000),
+			expViolations: []string{ViolationTreasuryInput, ViolationTreasuryInput},
+		},
+		{
+			name:          "amount",
+			receipts:      []*iotago.ReceiptTuple{newReceipt(10, 0, 1000000, 9000000), newReceipt(20, 10, 2000000, 6000000)},
+			treasury:      c2Node.treasuryOf(20, 6000000),
+			expViolations: []string{ViolationTreasuryAmount},
+		},
+		{
+			// receipts must be sorted by their milestone, otherwise they don't chain
+			name:          "out of order receipts",
+			receipts:      []*iotago.ReceiptTuple{newReceipt(20, 10, 2000000, 7000000), newReceipt(10, 0, 1000000, 9000000)},
+			treasury:      c2Node.treasuryOf(20, 7000000),
+			expViolations: []string{ViolationTreasuryAmount, ViolationTreasuryInput, ViolationTreasuryState},
+		},
+		{
+			name:          "treasury state",
+			receipts:      []*iotago.ReceiptTuple{newReceipt(10, 0, 1000000, 9000000)},
+			treasury:      c2Node.treasuryOf(10, 8000000),
+			expViolations: []string{ViolationTreasuryState},
+		},
+		{
+			name:          "malformed",
+			receipts:      []*iotago.ReceiptTuple{newReceipt(10, 0, 1000000, 9000000), {MilestoneIndex: 20, Receipt: &iotago.Receipt{MigratedAt: 20}}, newReceipt(30, 20, 3000000, 4000000)},
+			treasury:      c2Node.treasuryOf(30, 4000000),
+			expViolations: []string{ViolationMalformed},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := &treasuryChain{}
+			var kinds []string
+			for _, receipt := range tt.receipts {
+				violations, err := chain.verify(context.Background(), c2Nodes, receipt)
+				require.NoError(t, err)
+				for _, v := range violations {
+					kinds = append(kinds, v.Kind)
+				}
+			}
+			for _, v := range chain.verifyState(tt.treasury) {
+				kinds = append(kinds, v.Kind)
+			}
+			assert.Equal(t, tt.expViolations, kinds)
+		})
+	}
+
+	// a milestone which can't be queried fails the verification and leaves the chain unchanged
+	chain := &treasuryChain{}
+	_, err = chain.verify(context.Background(), c2Nodes, newReceipt(40, 0, 1000000, 9000000))
+	assert.Error(t, err)
+	assert.Nil(t, chain.prevOutput)
+}
+
+func TestReceiptVerifier(t *testing.T) {
+	// the legacy node pruned the milestones before 15
+	var whiteFlagCalls atomic.Int32
+	legacyNode := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Command        string `json:"command"`
+			MilestoneIndex uint32 `json:"milestoneIndex"`
+		}{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		switch req.Command {
+		case "getNodeInfo":
+			_, _ = fmt.Fprint(w, `{"latestMilestoneIndex": 100, "latestSolidSubtangleMilestoneIndex": 100, "milestoneStartIndex": 15}`)
+		case "getWhiteFlagConfirmation":
+			whiteFlagCalls.Add(1)
+			require.EqualValues(t, 20, req.MilestoneIndex)
+			_, _ = fmt.Fprint(w, `{"milestoneBundle": [], "includedBundles": []}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer legacyNode.Close()
+	legacyNodes, err := NewLegacyNodePool(&LegacyNodeConfig{URI: legacyNode.URL, Timeout: time.Second})
+	require.NoError(t, err)
+
+	c2Node := newTestMilestonesNode(t, 10, 20, 30)
+	c2Nodes, err := NewC2NodePool(&C2NodeConfig{URI: c2Node.URL, Timeout: time.Second})
+	require.NoError(t, err)
+
+	receipts := []*iotago.ReceiptTuple{
+		c2Node.newReceipt(10, 10, 0, 1000000, 9000000),
+		c2Node.newReceipt(20, 20, 10, 0, 9000000),
+	}
+	c2Node.receipts.Store(&receipts)
+	verifier := NewReceiptVerifier(legacyNodes, c2Nodes)
+
+	// the treasury was queried before the first receipt spent the initial treasury
+	c2Node.treasury.Store(&iotago.TreasuryResponse{MilestoneID: hex.EncodeToString(make([]byte, iotago.MilestoneIDLength)), Amount: 10000000})
+	report, err := verifier.Verify(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.OK)
+	assert.Zero(t, report.ReceiptsChecked)
+	assert.Zero(t, whiteFlagCalls.Load())
+
+	c2Node.treasury.Store(c2Node.treasuryOf(20, 9000000))
+	report, err = verifier.Verify(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.OK)
+	assert.Equal(t, 2, report.ReceiptsChecked)
+	assert.Equal(t, 1, report.EntriesChecked)
+	// the receipt migrating funds of a pruned legacy milestone is reported instead of failing the verification
+	require.Len(t, report.UnverifiableReceipts, 1)
+	assert.EqualValues(t, 10, report.UnverifiableReceipts[0].EmbeddedMilestoneIndex)
+	assert.EqualValues(t, 10, report.UnverifiableReceipts[0].LegacyMilestoneIndex)
+	assert.Contains(t, report.UnverifiableReceipts[0].Reason, "unverifiable (pruned)")
+	assert.EqualValues(t, 1, whiteFlagCalls.Load())
+	assert.EqualValues(t, 2, c2Node.milestoneCalls.Load())
+
+	// a receipt issued after the treasury was queried is left to the next verification
+	receipts = append(receipts[:2:2], c2Node.newReceipt(30, 20, 20, 0, 9000000))
+	c2Node.receipts.Store(&receipts)
+	report, err = verifier.Verify(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.OK)
+	assert.Equal(t, 2, report.ReceiptsChecked)
+	assert.EqualValues(t, 2, c2Node.milestoneCalls.Load())
+
+	// only the new receipt is verified, its legacy milestone's white-flag confirmation is already known
+	c2Node.treasury.Store(c2Node.treasuryOf(30, 9000000))
+
+	report, err = verifier.Verify(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.OK)
+	assert.Equal(t, 3, report.ReceiptsChecked)
+	assert.Len(t, report.UnverifiableReceipts, 1)
+	assert.EqualValues(t, 1, whiteFlagCalls.Load())
+	assert.EqualValues(t, 3, c2Node.milestoneCalls.Load())
+
+	// the current treasury is verified on every request
+	c2Node.treasury.Store(c2Node.treasuryOf(30, 8000000))
+	report, err = verifier.Verify(context.Background())
+	require.NoError(t, err)
+	assert.False(t, report.OK)
+	require.Len(t, report.Violations, 1)
+	assert.Equal(t, ViolationTreasuryState, report.Violations[0].Kind)
+	assert.EqualValues(t, 3, c2Node.milestoneCalls.Load())
+}
+
+func TestExpectedEntriesOf(t *testing.T) {
+	const target = "efdc112efe262b304bcf379b26c31bad029f616ee3ec4aa6345a366e4c9e43a3"
+	var edAddr [32]byte
+	addrBytes, err := hex.DecodeString(target)
+	require.NoError(t, err)
+	copy(edAddr[:], addrBytes)
+	migrationAddr, err := address.GenerateMigrationAddress(edAddr)
+	require.NoError(t, err)
+	otherAddr := strings.Repeat("A", consts.HashTrytesSize)
+
+	// a bundle whose transactions send the given values to the given addresses
+	newBundle := func(tag string, outputs map[trinary.Hash]int64) []trinary.Trytes {
+		var txs transaction.Transactions
+		for addr, value := range outputs {
+			txs = append(txs, transaction.Transaction{
+				SignatureMessageFragment: strings.Repeat("9", consts.SignatureMessageFragmentSizeInTrytes),
+				Address:                  addr,
+				Value:                    value,
+				ObsoleteTag:              tag,
+				Bundle:                   consts.NullHashTrytes,
+				TrunkTransaction:         consts.NullHashTrytes,
+				BranchTransaction:        consts.NullHashTrytes,
+				Tag:                      tag,
+				Nonce:                    strings.Repeat("9", consts.NonceTrinarySize/consts.TritsPerTryte),
+			})
+		}
+		for i := range txs {
+			txs[i].CurrentIndex = uint64(i)
+			txs[i].LastIndex = uint64(len(txs) - 1)
+		}
+		return transaction.MustTransactionsToTrytes(txs)
+	}
+	tailOf := func(bndl []trinary.Trytes) trinary.Hash {
+		txs, err := transaction.AsTransactionObjects(bndl, nil)
+		require.NoError(t, err)
+		return txs[0].Hash
+	}
+	tag := func(c string) string { return strings.Repeat(c, consts.TagTrinarySize/consts.TritsPerTryte) }
+
+	migration := newBundle(tag("A"), map[trinary.Hash]int64{migrationAddr: 5000000})
+	subMinimum := newBundle(tag("B"), map[trinary.Hash]int64{migrationAddr: iotago.MinMigratedFundsEntryDeposit - 1})
+	regular := newBundle(tag("C"), map[trinary.Hash]int64{otherAddr: 5000000})
+
+	var tests = []struct {
+		name       string
+		bundles    [][]trinary.Trytes
+		expEntries map[trinary.Hash][]*expectedEntry
+		expErr     bool
+	}{
+		{
+			name:       "migration",
+			bundles:    [][]trinary.Trytes{migration},
+			expEntries: map[trinary.Hash][]*expectedEntry{tailOf(migration): {{value: 5000000, targetEd25519Address: target}}},
+		},
+		{
+			// funds below the minimum deposit can't be migrated by a receipt entry
+			name:       "sub minimum deposit",
+			bundles:    [][]trinary.Trytes{subMinimum, migration},
+			expEntries: map[trinary.Hash][]*expectedEntry{tailOf(migration): {{value: 5000000, targetEd25519Address: target}}},
+		},
+		{
+			name:       "no migration address",
+			bundles:    [][]trinary.Trytes{regular},
+			expEntries: map[trinary.Hash][]*expectedEntry{},
+		},
+		{
+			name:    "malformed",
+			bundles: [][]trinary.Trytes{{"ABC"}},
+			expErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := expectedEntriesOf(&common.GetWhiteFlagConfirmationResponse{IncludedBundles: tt.bundles})
+			if tt.expErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expEntries, entries)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package migration
+
+import (
+	"log"
+
+	"github.com/iotaledger/chrysalis-tools/common"
+	"github.com/iotaledger/iota.go/address"
+	"github.com/iotaledger/iota.go/trinary"
+)
+
+// LegacyFundsLocked returns the funds locked on migration addresses as of the returned legacy milestone.
+// It returns false as long as the balances of the migration addresses are unknown, which is the case before
+// the first legacy milestone was indexed and after legacy milestones were skipped.
+func (idx *MigrationIndex) LegacyFundsLocked() (LegacyFundsLocked, uint32, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	if idx.lockedBalances == nil {
+		return LegacyFundsLocked{}, 0, false
+	}
+	return idx.locked, idx.lockedMilestoneIndex, true
+}
+
+// Treasury returns the tokens in the treasury as of the last indexed C2 milestone.
+// It returns false as long as no C2 milestone was indexed.
+func (idx *MigrationIndex) Treasury() (uint64, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	if idx.c2MilestoneIndex == 0 {
+		return 0, false
+	}
+	return idx.treasuryTokens, true
+}
+
+// tells whether the balances of the migration addresses are known.
+func (idx *MigrationIndex) hasLockedBalances() bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.lockedBalances != nil
+}
+
+// sets the balances of the migration addresses at the given legacy milestone as the base to apply ledger diffs on.
+// only balances of at least minAmount count as locked funds.
+func (idx *MigrationIndex) setLockedBalances(msIndex uint32, balances map[trinary.Hash]uint64, minAmount uint64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.lockedBalances = make(map[trinary.Hash]uint64, len(balances))
+	idx.locked = LegacyFundsLocked{}
+	idx.minLockedAmount = minAmount
+	for addr, balance := range balances {
+		idx.setLockedBalance(addr, balance)
+	}
+	idx.lockedMilestoneIndex = msIndex
+}
+
+// applies the balance changes of migration addresses of the given extended ledger diff.
+func (idx *MigrationIndex) applyLedgerDiff(diff *common.GetLedgerDiffExtReturn) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for addr, change := range diff.Diff {
+		if _, err := address.ParseMigrationAddress(addr); err != nil {
+			continue
+		}
+		balance := int64(idx.lockedBalances[addr]) + change
+		if balance < 0 {
+			log.Printf("balance of migration address %s dropped below zero, the ledger state and diffs are inconsistent", addr)
+			balance = 0
+		}
+		idx.setLockedBalance(addr, uint64(balance))
+	}
+	idx.lockedMilestoneIndex = diff.MilestoneIndex
+}
+
+// sets the balance of the given migration address and updates the locked funds accordingly,
+// must be called with the lock held.
+func (idx *MigrationIndex) setLockedBalance(addr trinary.Hash, balance uint64) {
+	if old, has := idx.lockedBalances[addr]; has && old >= idx.minLockedAmount {
+		idx.locked.TokensTotal -= old
+		idx.locked.MigratedAddressesTotal--
+	}
+
+	if balance == 0 {
+		delete(idx.lockedBalances, addr)
+	} else {
+		idx.lockedBalances[addr] = balance
+	}
+
+	if balance > 0 && balance >= idx.minLockedAmount {
+		idx.locked.TokensTotal += balance
+		idx.locked.MigratedAddressesTotal++
+	}
+
+	idx.locked.TokensPercentageOfTotalSupply = percentageOfTotalSupply(idx.locked.TokensTotal)
+}
@@ -0,0 +1,456 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/iotaledger/chrysalis-tools/common"
+	"github.com/iotaledger/iota.go/api"
+	iotago "github.com/iotaledger/iota.go/v2"
+)
+
+const (
+	// the health check interval used if none is configured.
+	defaultHealthCheckInterval = 30 * time.Second
+)
+
+var (
+	// ErrNoNodeAvailable is returned when none of the nodes of a pool is reachable.
+	ErrNoNodeAvailable = errors.New("no node available")
+	// ErrMilestonePruned is returned when none of the nodes of a pool holds the data of a milestone anymore.
+	ErrMilestonePruned = errors.New("milestone pruned")
+)
+
+// NodeStatus describes the outcome of the last health check of a node.
+type NodeStatus struct {
+	// The URI of the node.
+	URI string `json:"uri"`
+	// Whether the node answered the last health check.
+	Reachable bool `json:"reachable"`
+	// Whether the node is synced (legacy: LSMI == LMI, C2: isHealthy).
+	Synced bool `json:"synced"`
+	// The latest solid (legacy) or confirmed (C2) milestone index of the node.
+	MilestoneIndex uint32 `json:"milestoneIndex"`
+	// The milestone index up to which (including) the node pruned its database.
+	PruningIndex uint32 `json:"pruningIndex"`
+	// The latency of the last health check.
+	Latency time.Duration `json:"latency"`
+	// The last error encountered with the node.
+	LastError string `json:"lastError,omitempty"`
+	// The time of the last health check.
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// tells whether the node pruned the given milestone, milestone 0 stands for no particular milestone.
+func (s *NodeStatus) pruned(msIndex uint32) bool {
+	return msIndex > 0 && s.PruningIndex >= msIndex
+}
+
+// tells whether the node didn't reach the given milestone yet, milestone 0 stands for no particular milestone.
+func (s *NodeStatus) lagging(msIndex uint32) bool {
+	return s.MilestoneIndex < msIndex
+}
+
+// checks the health of the node at the given index of the pool.
+type nodeHealthCheckFunc func(ctx context.Context, i int) (msIndex uint32, pruningIndex uint32, synced bool, err error)
+
+// nodePool selects the most synced node out of a set of nodes and fails over to another node on errors.
+type nodePool struct {
+	name                string
+	healthCheckInterval time.Duration
+	healthCheck         nodeHealthCheckFunc
+
+	mu        sync.Mutex
+	statuses  []NodeStatus
+	selected  int
+	lastCheck time.Time
+	// serializes the health checks, which are executed without holding mu.
+	checkMu sync.Mutex
+}
+
+func newNodePool(name string, uris []string, healthCheckInterval time.Duration, healthCheck nodeHealthCheckFunc) *nodePool {
+	if healthCheckInterval <= 0 {
+		healthCheckInterval = defaultHealthCheckInterval
+	}
+	statuses := make([]NodeStatus, len(uris))
+	for i, uri := range uris {
+		statuses[i].URI = uri
+	}
+	return &nodePool{
+		name:                name,
+		healthCheckInterval: healthCheckInterval,
+		healthCheck:         healthCheck,
+		statuses:            statuses,
+		selected:            -1,
+	}
+}
+
+// Statuses returns the status of every node of the pool as of the last health check.
+func (p *nodePool) Statuses() []NodeStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	statuses := make([]NodeStatus, len(p.statuses))
+	copy(statuses, p.statuses)
+	return statuses
+}
+
+// CurrentStatuses returns the status of every node of the pool and re-checks the health of all nodes first
+// if the last check is outdated.
+func (p *nodePool) CurrentStatuses(ctx context.Context) []NodeStatus {
+	p.mu.Lock()
+	outdated := time.Since(p.lastCheck) >= p.healthCheckInterval
+	p.mu.Unlock()
+	if outdated {
+		p.checkHealth(ctx)
+	}
+	return p.Statuses()
+}
+
+// PruningIndex returns the lowest pruning index of all nodes of the pool as of their last successful health check,
+// i.e. the milestones up to it can't be queried from any node of the pool. Unreachable nodes count with their
+// last known pruning index, so that a briefly unreachable archival node doesn't let its milestones be skipped.
+func (p *nodePool) PruningIndex() uint32 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pruningIndex()
+}
+
+// returns the lowest pruning index of all nodes of the pool, must be called with mu held.
+func (p *nodePool) pruningIndex() uint32 {
+	var pruningIndex uint32
+	for i, status := range p.statuses {
+		if i == 0 || status.PruningIndex < pruningIndex {
+			pruningIndex = status.PruningIndex
+		}
+	}
+	return pruningIndex
+}
+
+// returns the index of the most synced node which was not yet tried and holds the given milestone,
+// re-checks the health of all nodes if the last check is outdated, no node is selected or no node reached the milestone.
+// Returns ErrMilestonePruned if all nodes of the pool, including the unreachable ones, pruned the given milestone.
+func (p *nodePool) selectNode(ctx context.Context, tried map[int]struct{}, msIndex uint32) (int, error) {
+	p.mu.Lock()
+	if _, wasTried := tried[p.selected]; p.selected != -1 && !wasTried && time.Since(p.lastCheck) < p.healthCheckInterval &&
+		p.statuses[p.selected].Reachable && !p.statuses[p.selected].pruned(msIndex) && !p.statuses[p.selected].lagging(msIndex) {
+		p.mu.Unlock()
+		return p.selected, nil
+	}
+	needsCheck := p.selected == -1 || time.Since(p.lastCheck) >= p.healthCheckInterval || !p.reached(msIndex)
+	p.mu.Unlock()
+
+	if needsCheck {
+		p.checkHealth(ctx)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	best := -1
+	pruned, lagging := false, false
+	for i, status := range p.statuses {
+		if _, wasTried := tried[i]; wasTried || !status.Reachable {
+			continue
+		}
+		if status.pruned(msIndex) {
+			pruned = true
+			continue
+		}
+		if status.lagging(msIndex) {
+			lagging = true
+			continue
+		}
+		switch {
+		case best == -1:
+			best = i
+		case status.Synced && !p.statuses[best].Synced:
+			best = i
+		case status.Synced == p.statuses[best].Synced && status.MilestoneIndex > p.statuses[best].MilestoneIndex:
+			best = i
+		}
+	}
+
+	// a lagging node will eventually hold the milestone, so it must not be skipped as pruned
+	if best == -1 && lagging {
+		return -1, fmt.Errorf("%w: none of the reachable %s nodes reached milestone %d yet", ErrNoNodeAvailable, p.name, msIndex)
+	}
+	// an unreachable node which didn't prune the milestone might come back
+	if best == -1 && pruned && msIndex <= p.pruningIndex() {
+		return -1, fmt.Errorf("%w: milestone %d is pruned on all %s nodes", ErrMilestonePruned, msIndex, p.name)
+	}
+	if best == -1 && pruned {
+		return -1, fmt.Errorf("%w: milestone %d is pruned on all reachable %s nodes", ErrNoNodeAvailable, msIndex, p.name)
+	}
+	if best == -1 {
+		p.selected = -1
+		return -1, fmt.Errorf("%w: none of the %d %s nodes is reachable", ErrNoNodeAvailable, len(p.statuses), p.name)
+	}
+
+	if best != p.selected {
+		log.Printf("selected %s node %s (milestone %d, synced %v)", p.name, p.statuses[best].URI, p.statuses[best].MilestoneIndex, p.statuses[best].Synced)
+	}
+	p.selected = best
+	return best, nil
+}
+
+// tells whether a reachable node reached the given milestone as of the last health check, must be called with mu held.
+func (p *nodePool) reached(msIndex uint32) bool {
+	for _, status := range p.statuses {
+		if status.Reachable && !status.lagging(msIndex) {
+			return true
+		}
+	}
+	return false
+}
+
+// checks the health of all nodes concurrently without holding the lock, so that the statuses can be read meanwhile.
+// Callers waiting for a check which was started in the meantime reuse its results instead of checking again.
+func (p *nodePool) checkHealth(ctx context.Context) {
+	requested := time.Now()
+	p.checkMu.Lock()
+	defer p.checkMu.Unlock()
+
+	p.mu.Lock()
+	checkedMeanwhile := p.lastCheck.After(requested)
+	p.mu.Unlock()
+	if checkedMeanwhile {
+		return
+	}
+
+	checked := make([]NodeStatus, len(p.statuses))
+	var wg sync.WaitGroup
+	wg.Add(len(checked))
+	for i := range checked {
+		go func(i int) {
+			defer wg.Done()
+			start := time.Now()
+			msIndex, pruningIndex, synced, err := p.healthCheck(ctx, i)
+			status := &checked[i]
+			status.Latency = time.Since(start)
+			status.CheckedAt = time.Now()
+			status.Reachable = err == nil
+			if err != nil {
+				status.LastError = err.Error()
+				return
+			}
+			status.Synced = synced
+			status.MilestoneIndex = msIndex
+			status.PruningIndex = pruningIndex
+		}(i)
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range checked {
+		status := &p.statuses[i]
+		status.Latency = checked[i].Latency
+		status.CheckedAt = checked[i].CheckedAt
+		status.Reachable = checked[i].Reachable
+		if !checked[i].Reachable {
+			// keep the last known milestones of unreachable nodes
+			status.Synced = false
+			status.LastError = checked[i].LastError
+			continue
+		}
+		status.Synced = checked[i].Synced
+		status.MilestoneIndex = checked[i].MilestoneIndex
+		status.PruningIndex = checked[i].PruningIndex
+	}
+	p.lastCheck = time.Now()
+}
+
+// marks the given node as failed so that the next selection re-checks the health of all nodes.
+func (p *nodePool) markFailed(i int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.statuses[i].LastError = err.Error()
+	if p.selected == i {
+		p.selected = -1
+	}
+}
+
+// executes the given function against the selected node and fails over to the next best node on errors,
+// until every reachable node of the pool was tried once.
+func (p *nodePool) do(ctx context.Context, fn func(i int) error) error {
+	return p.doAt(ctx, 0, fn)
+}
+
+// executes the given function against the selected node which reached and didn't prune the given milestone and
+// fails over to the next best such node on errors, until every reachable node of the pool was tried once.
+// Whether a node pruned the milestone is only derived from the pruning index reported by its health check,
+// an error wrapping ErrMilestonePruned is returned if every node of the pool pruned the milestone.
+func (p *nodePool) doAt(ctx context.Context, msIndex uint32, fn func(i int) error) error {
+	var lastErr error
+	tried := make(map[int]struct{})
+	for len(tried) < len(p.statuses) {
+		i, err := p.selectNode(ctx, tried, msIndex)
+		if err != nil {
+			if lastErr != nil {
+				return lastErr
+			}
+			return err
+		}
+		tried[i] = struct{}{}
+
+		if lastErr = fn(i); lastErr == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return lastErr
+		}
+
+		log.Printf("%s node %s failed: %v", p.name, p.statuses[i].URI, lastErr)
+		p.markFailed(i, lastErr)
+	}
+	return lastErr
+}
+
+// returns the options of a legacy client to use the ledger cache defined in the given config, if any.
+func ledgerCacheOptions(cfg *HTTPAPIServiceConfig) ([]common.LegacyClientOption, error) {
+	if len(cfg.LedgerCacheDir) == 0 {
+		return nil, nil
+	}
+	ledgerCache, err := common.NewLedgerCache(cfg.LedgerCacheDir, common.WithLedgerCacheMaxLedgerStates(cfg.LedgerCacheMaxLedgerStates))
+	if err != nil {
+		return nil, fmt.Errorf("unable to init ledger cache: %w", err)
+	}
+	return []common.LegacyClientOption{common.WithLegacyClientLedgerCache(ledgerCache)}, nil
+}
+
+// LegacyNode bundles the APIs to query a single legacy node.
+type LegacyNode struct {
+	// The URI of the node.
+	URI string
+	// The iota.go API of the node.
+	API *api.API
+	// The client for the commands not covered by the iota.go API.
+	Client *common.LegacyClient
+}
+
+// NewLegacyNodePool creates a new LegacyNodePool out of the endpoints defined in the given config.
+func NewLegacyNodePool(cfg *LegacyNodeConfig, clientOpts ...common.LegacyClientOption) (*LegacyNodePool, error) {
+	uris := cfg.Endpoints()
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("%w: no legacy node URI configured", ErrNoNodeAvailable)
+	}
+
+	pool := &LegacyNodePool{}
+	for _, uri := range uris {
+		legacyAPI, err := api.ComposeAPI(api.HTTPClientSettings{
+			URI:    uri,
+			Client: &http.Client{Timeout: cfg.Timeout},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to build legacy API for %s: %w", uri, err)
+		}
+
+		// ledger states are queried by a separate HTTP client with the longer common.DefaultLegacyClientLedgerStateTimeout
+		opts := append([]common.LegacyClientOption{
+			common.WithLegacyClientHTTPClient(&http.Client{Timeout: cfg.Timeout}),
+		}, clientOpts...)
+		legacyClient, err := common.NewLegacyClient(uri, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to build legacy client for %s: %w", uri, err)
+		}
+
+		pool.nodes = append(pool.nodes, &LegacyNode{URI: uri, API: legacyAPI, Client: legacyClient})
+	}
+
+	pool.nodePool = newNodePool("legacy", uris, cfg.HealthCheckInterval, func(ctx context.Context, i int) (uint32, uint32, bool, error) {
+		info, err := pool.nodes[i].Client.NodeInfo(ctx)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		synced := info.LatestMilestoneIndex > 0 && info.LatestSolidSubtangleMilestoneIndex == info.LatestMilestoneIndex
+		return info.LatestSolidSubtangleMilestoneIndex, info.MilestoneStartIndex, synced, nil
+	})
+
+	return pool, nil
+}
+
+// LegacyNodePool selects the most synced legacy node and fails over to other legacy nodes on errors.
+type LegacyNodePool struct {
+	*nodePool
+	nodes []*LegacyNode
+}
+
+// Do executes the given function against the most synced legacy node and
+// re-executes it against the next best node if it fails.
+func (p *LegacyNodePool) Do(ctx context.Context, fn func(node *LegacyNode) error) error {
+	return p.do(ctx, func(i int) error {
+		return fn(p.nodes[i])
+	})
+}
+
+// DoAt executes the given function, which queries data of the given milestone, against the most synced legacy node
+// which reached and didn't prune the milestone and re-executes it against the next best such node if it fails.
+// Returns an error wrapping ErrMilestonePruned if no legacy node holds the milestone anymore.
+func (p *LegacyNodePool) DoAt(ctx context.Context, msIndex uint32, fn func(node *LegacyNode) error) error {
+	return p.doAt(ctx, msIndex, func(i int) error {
+		return fn(p.nodes[i])
+	})
+}
+
+// C2Node bundles the API to query a single C2 node.
+type C2Node struct {
+	// The URI of the node.
+	URI string
+	// The API of the node.
+	API *iotago.NodeHTTPAPIClient
+}
+
+// NewC2NodePool creates a new C2NodePool out of the endpoints defined in the given config.
+func NewC2NodePool(cfg *C2NodeConfig) (*C2NodePool, error) {
+	uris := cfg.Endpoints()
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("%w: no C2 node URI configured", ErrNoNodeAvailable)
+	}
+
+	pool := &C2NodePool{}
+	for _, uri := range uris {
+		pool.nodes = append(pool.nodes, &C2Node{
+			URI: uri,
+			API: iotago.NewNodeHTTPAPIClient(uri, iotago.WithNodeHTTPAPIClientHTTPClient(&http.Client{Timeout: cfg.Timeout})),
+		})
+	}
+
+	pool.nodePool = newNodePool("C2", uris, cfg.HealthCheckInterval, func(ctx context.Context, i int) (uint32, uint32, bool, error) {
+		info, err := pool.nodes[i].API.Info(ctx)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		return info.ConfirmedMilestoneIndex, info.PruningIndex, info.IsHealthy, nil
+	})
+
+	return pool, nil
+}
+
+// C2NodePool selects the most synced C2 node and fails over to other C2 nodes on errors.
+type C2NodePool struct {
+	*nodePool
+	nodes []*C2Node
+}
+
+// Do executes the given function against the most synced C2 node and
+// re-executes it against the next best node if it fails.
+func (p *C2NodePool) Do(ctx context.Context, fn func(node *C2Node) error) error {
+	return p.do(ctx, func(i int) error {
+		return fn(p.nodes[i])
+	})
+}
+
+// DoAt executes the given function, which queries data of the given milestone, against the most synced C2 node
+// which reached and didn't prune the milestone and re-executes it against the next best such node if it fails.
+// Returns an error wrapping ErrMilestonePruned if no C2 node holds the milestone anymore.
+func (p *C2NodePool) DoAt(ctx context.Context, msIndex uint32, fn func(node *C2Node) error) error {
+	return p.doAt(ctx, msIndex, func(i int) error {
+		return fn(p.nodes[i])
+	})
+}
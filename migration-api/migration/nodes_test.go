@@ -0,0 +1,251 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	iotago "github.com/iotaledger/iota.go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newC2InfoServer(t *testing.T, healthy bool, cmi uint32) *httptest.Server {
+	return newPrunedC2InfoServer(t, healthy, cmi, 0)
+}
+
+func newPrunedC2InfoServer(t *testing.T, healthy bool, cmi uint32, pruningIndex uint32) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"data": {"isHealthy": %v, "confirmedMilestoneIndex": %d, "pruningIndex": %d}}`, healthy, cmi, pruningIndex)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestC2NodePoolSelection(t *testing.T) {
+	behind := newC2InfoServer(t, true, 100)
+	unhealthy := newC2InfoServer(t, false, 300)
+	best := newC2InfoServer(t, true, 200)
+
+	pool, err := NewC2NodePool(&C2NodeConfig{
+		URI:     behind.URL,
+		URIs:    []string{unhealthy.URL, best.URL, behind.URL},
+		Timeout: time.Second,
+	})
+	require.NoError(t, err)
+	require.Len(t, pool.nodes, 3)
+
+	var used []string
+	require.NoError(t, pool.Do(context.Background(), func(node *C2Node) error {
+		used = append(used, node.URI)
+		return nil
+	}))
+	assert.Equal(t, []string{best.URL}, used)
+
+	statuses := pool.Statuses()
+	require.Len(t, statuses, 3)
+	for _, status := range statuses {
+		assert.True(t, status.Reachable)
+	}
+	assert.False(t, statuses[1].Synced)
+	assert.EqualValues(t, 300, statuses[1].MilestoneIndex)
+}
+
+func TestC2NodePoolFailover(t *testing.T) {
+	first := newC2InfoServer(t, true, 200)
+	second := newC2InfoServer(t, true, 100)
+	down := newC2InfoServer(t, true, 300)
+	down.Close()
+
+	pool, err := NewC2NodePool(&C2NodeConfig{URIs: []string{first.URL, second.URL, down.URL}, Timeout: time.Second})
+	require.NoError(t, err)
+
+	errFailed := errors.New("failed")
+	var used []string
+	require.NoError(t, pool.Do(context.Background(), func(node *C2Node) error {
+		used = append(used, node.URI)
+		if node.URI == first.URL {
+			return errFailed
+		}
+		return nil
+	}))
+	assert.Equal(t, []string{first.URL, second.URL}, used)
+
+	statuses := pool.Statuses()
+	assert.False(t, statuses[2].Reachable)
+	assert.NotEmpty(t, statuses[2].LastError)
+
+	err = pool.Do(context.Background(), func(node *C2Node) error { return errFailed })
+	assert.ErrorIs(t, err, errFailed)
+}
+
+func TestC2NodePoolNoNodeAvailable(t *testing.T) {
+	down := newC2InfoServer(t, true, 300)
+	down.Close()
+
+	pool, err := NewC2NodePool(&C2NodeConfig{URI: down.URL, Timeout: time.Second})
+	require.NoError(t, err)
+
+	err = pool.Do(context.Background(), func(node *C2Node) error { return nil })
+	assert.ErrorIs(t, err, ErrNoNodeAvailable)
+
+	_, err = NewC2NodePool(&C2NodeConfig{})
+	assert.ErrorIs(t, err, ErrNoNodeAvailable)
+}
+
+func TestC2NodePoolPruned(t *testing.T) {
+	pruned := newPrunedC2InfoServer(t, true, 200, 100)
+	archive := newPrunedC2InfoServer(t, true, 150, 10)
+
+	pool, err := NewC2NodePool(&C2NodeConfig{URIs: []string{pruned.URL, archive.URL}, Timeout: time.Second})
+	require.NoError(t, err)
+
+	var used []string
+	useNode := func(node *C2Node) error {
+		used = append(used, node.URI)
+		return nil
+	}
+
+	require.NoError(t, pool.DoAt(context.Background(), 120, useNode))
+	require.NoError(t, pool.DoAt(context.Background(), 50, useNode))
+	assert.Equal(t, []string{pruned.URL, archive.URL}, used)
+	assert.EqualValues(t, 10, pool.PruningIndex())
+
+	used = nil
+	err = pool.DoAt(context.Background(), 5, useNode)
+	assert.ErrorIs(t, err, ErrMilestonePruned)
+	assert.Empty(t, used)
+
+	// nodes answering with not found are failed over, as only the reported pruning index tells about pruned milestones
+	used = nil
+	err = pool.DoAt(context.Background(), 120, func(node *C2Node) error {
+		used = append(used, node.URI)
+		return iotago.ErrHTTPNotFound
+	})
+	assert.ErrorIs(t, err, iotago.ErrHTTPNotFound)
+	assert.NotErrorIs(t, err, ErrMilestonePruned)
+	assert.ElementsMatch(t, []string{pruned.URL, archive.URL}, used)
+}
+
+func TestC2NodePoolArchiveDown(t *testing.T) {
+	pruned := newPrunedC2InfoServer(t, true, 200, 100)
+	var archiveDown atomic.Bool
+	archive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if archiveDown.Load() {
+			// drop the connection like a node which is down
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			_ = conn.Close()
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, `{"data": {"isHealthy": true, "confirmedMilestoneIndex": 150, "pruningIndex": 10}}`)
+	}))
+	t.Cleanup(archive.Close)
+
+	pool, err := NewC2NodePool(&C2NodeConfig{
+		URIs:                []string{pruned.URL, archive.URL},
+		Timeout:             time.Second,
+		HealthCheckInterval: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	var used []string
+	useNode := func(node *C2Node) error {
+		used = append(used, node.URI)
+		return nil
+	}
+
+	require.NoError(t, pool.DoAt(context.Background(), 50, useNode))
+	assert.EqualValues(t, 10, pool.PruningIndex())
+
+	// the milestones held by the unreachable archive node are not pruned
+	archiveDown.Store(true)
+	time.Sleep(2 * time.Millisecond)
+	assert.False(t, pool.CurrentStatuses(context.Background())[1].Reachable)
+	assert.EqualValues(t, 10, pool.PruningIndex())
+	err = pool.DoAt(context.Background(), 50, useNode)
+	assert.ErrorIs(t, err, ErrNoNodeAvailable)
+	assert.NotErrorIs(t, err, ErrMilestonePruned)
+
+	archiveDown.Store(false)
+	time.Sleep(2 * time.Millisecond)
+	require.NoError(t, pool.DoAt(context.Background(), 50, useNode))
+	assert.Equal(t, []string{archive.URL, archive.URL}, used)
+}
+
+func TestC2NodePoolNeverReachedPruningIndex(t *testing.T) {
+	pruned := newPrunedC2InfoServer(t, true, 200, 100)
+	down := newC2InfoServer(t, true, 300)
+	down.Close()
+
+	pool, err := NewC2NodePool(&C2NodeConfig{URIs: []string{pruned.URL, down.URL}, Timeout: time.Second})
+	require.NoError(t, err)
+
+	// nothing is known to be pruned on a node which never answered
+	pool.CurrentStatuses(context.Background())
+	assert.Zero(t, pool.PruningIndex())
+	err = pool.DoAt(context.Background(), 50, func(node *C2Node) error { return nil })
+	assert.NotErrorIs(t, err, ErrMilestonePruned)
+}
+
+func TestC2NodePoolLagging(t *testing.T) {
+	pruned := newPrunedC2InfoServer(t, true, 200, 100)
+	lagging := newPrunedC2InfoServer(t, true, 50, 0)
+
+	pool, err := NewC2NodePool(&C2NodeConfig{URIs: []string{pruned.URL, lagging.URL}, Timeout: time.Second})
+	require.NoError(t, err)
+
+	var used []string
+	useNode := func(node *C2Node) error {
+		used = append(used, node.URI)
+		return nil
+	}
+
+	require.NoError(t, pool.DoAt(context.Background(), 40, useNode))
+	assert.Equal(t, []string{lagging.URL}, used)
+
+	// a milestone only missing on a lagging node is not pruned
+	used = nil
+	err = pool.DoAt(context.Background(), 80, useNode)
+	assert.ErrorIs(t, err, ErrNoNodeAvailable)
+	assert.NotErrorIs(t, err, ErrMilestonePruned)
+
+	err = pool.DoAt(context.Background(), 250, useNode)
+	assert.ErrorIs(t, err, ErrNoNodeAvailable)
+	assert.Empty(t, used)
+}
+
+func TestNodePoolStatusesDuringHealthCheck(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, `{"data": {"isHealthy": true, "confirmedMilestoneIndex": 100}}`)
+	}))
+	t.Cleanup(slow.Close)
+
+	pool, err := NewC2NodePool(&C2NodeConfig{URI: slow.URL, Timeout: 5 * time.Second})
+	require.NoError(t, err)
+
+	checked := make(chan []NodeStatus)
+	go func() { checked <- pool.CurrentStatuses(context.Background()) }()
+
+	// the statuses are readable while the health check is pending
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	statuses := pool.Statuses()
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+	assert.False(t, statuses[0].Reachable)
+
+	close(release)
+	statuses = <-checked
+	assert.True(t, statuses[0].Reachable)
+	assert.EqualValues(t, 100, statuses[0].MilestoneIndex)
+}
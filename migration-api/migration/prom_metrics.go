@@ -4,10 +4,12 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -21,12 +23,17 @@ import (
 
 // NewPromMetricsService creates a new PromMetricsService with the given options.
 func NewPromMetricsService(e *echo.Echo, cfg *PromMetricsServiceConfig) *PromMetricsService {
+	ctx, cancel := context.WithCancel(context.Background())
 	s := &PromMetricsService{
 		cfg:      cfg,
 		e:        e,
 		registry: prometheus.NewRegistry(),
-		shutdown: make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
 	}
+	// no update completed yet
+	s.legacyBacklog.Store(-1)
+	s.c2Backlog.Store(-1)
 	return s
 }
 
@@ -35,103 +42,194 @@ type PromMetricsService struct {
 	e                     *echo.Echo
 	cfg                   *PromMetricsServiceConfig
 	state                 *prommetricservicestate
-	legacyAPI             *api.API
-	c2API                 *iotago.NodeHTTPAPIClient
+	legacyNodes           *LegacyNodePool
+	c2Nodes               *C2NodePool
 	registry              *prometheus.Registry
 	legacyWfTailsIncluded prometheus.Counter
 	receiptEntriesApplied prometheus.Counter
 	serviceErrors         prometheus.Counter
-	shutdown              chan struct{}
+	tokensMigrated        prometheus.Counter
+	treasuryTokens        prometheus.Gauge
+	lockedUnmintedTokens  prometheus.Gauge
+	legacyMilestoneIndex  prometheus.Gauge
+	c2MilestoneIndex      prometheus.Gauge
+	milestoneLag          prometheus.Gauge
+	legacyQueryLatency    prometheus.Histogram
+	c2QueryLatency        prometheus.Histogram
+	legacySkipped         prometheus.Counter
+	c2Skipped             prometheus.Counter
+
+	// canceled on shutdown, aborts the running update and pending queries.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// closed once the current Run returned.
+	runDoneMu sync.Mutex
+	runDone   chan struct{}
+
+	// the amount of milestones the service was behind the nodes after the last update, -1 before the first update.
+	legacyBacklog atomic.Int64
+	c2Backlog     atomic.Int64
 }
 
-// represents the state of the prom metrics service.
-type prommetricservicestate struct {
-	// The last milestone queried for white-flag confirmation data.
-	LastLegacyMilestoneIndexQueried int `json:"lastLegacyMilestoneIndexQueried"`
-	// The last milestone queried for receipt data.
-	LastC2MilestoneIndexQueried int `json:"lastC2MilestoneIndexQueried"`
-	// The persisted counter of confirmed legacy tail txs.
-	LegacyTailsIncluded int `json:"legacyTailsIncluded"`
-	// The persisted counter of applied receipt entries.
-	ReceiptEntriesApplied int `json:"receiptEntriesApplied"`
-}
+const (
+	// the amount of milestones queried between persisting the state, if none is configured.
+	defaultCheckpointInterval = 100
+)
 
-// persists the state to the given file (overriding a previous state file).
-func (pmss *prommetricservicestate) persist(filePath string) error {
-	jsonState, err := json.MarshalIndent(pmss, "", "   ")
-	if err != nil {
-		return fmt.Errorf("unable to serialize state: %w", err)
-	}
-	if err := os.WriteFile(filePath, jsonState, 0666); err != nil {
-		return fmt.Errorf("unable to write state to disk: %w", err)
-	}
-	return nil
+// the result of querying the legacy milestones since the last queried one.
+type legacyQueryResult struct {
+	// the amount of newly included tails.
+	tailsIncluded int
+	// the tokens sent to migration addresses by the newly included bundles.
+	tokensLocked uint64
+	// the amount of milestones skipped as they are pruned on all legacy nodes.
+	skipped int
+	// the legacy milestone up to which was queried.
+	targetIndex int
 }
 
-// loads the state from the given file.
-func (pmss *prommetricservicestate) load(filePath string) error {
-	jsonState, err := os.ReadFile(filePath)
-	if err != nil {
-		return fmt.Errorf("unable to read state file: %w", err)
-	}
-	if err := json.Unmarshal(jsonState, pmss); err != nil {
-		return fmt.Errorf("unable to deserialize state: %w", err)
-	}
-	return nil
+// the result of querying the C2 milestones since the last queried one.
+type c2QueryResult struct {
+	// the amount of newly applied receipt entries.
+	receiptEntriesApplied int
+	// the tokens minted by the newly applied receipt entries.
+	tokensMigrated uint64
+	// the legacy milestone at which the funds of the last new receipt were migrated, 0 if there was none.
+	lastMigratedAt uint32
+	// the amount of milestones skipped as they are pruned on all C2 nodes.
+	skipped int
+	// the C2 milestone up to which was queried.
+	targetIndex int
 }
 
-// Init initializes the service state.
+// Init initializes the service state and registers the /metrics route.
 func (pms *PromMetricsService) Init() error {
-	pms.state = &prommetricservicestate{}
-
-	if _, err := os.Stat(pms.cfg.StateFilePath); os.IsNotExist(err) {
-		log.Println("bootstrapping prom metrics service")
-		pms.state.LastLegacyMilestoneIndexQueried = pms.cfg.LegacyMilestoneStartIndex
-		pms.state.LastC2MilestoneIndexQueried = pms.cfg.C2MilestoneStartIndex
-		if err := pms.state.persist(pms.cfg.StateFilePath); err != nil {
-			return fmt.Errorf("unable to persist initial state: %w", err)
-		}
-	} else if err := pms.state.load(pms.cfg.StateFilePath); err != nil {
+	if err := pms.initState(); err != nil {
 		return err
 	}
 
+	names := &pms.cfg.CounterNames
 	pms.legacyWfTailsIncluded = prometheus.NewCounter(
 		prometheus.CounterOpts{
-			Name: pms.cfg.CounterNames.IncludedLegacyTails,
+			Name: names.IncludedLegacyTails,
 			Help: "The count of tails included.",
 		},
 	)
 	pms.receiptEntriesApplied = prometheus.NewCounter(
 		prometheus.CounterOpts{
-			Name: pms.cfg.CounterNames.AppliedReceiptEntries,
+			Name: names.AppliedReceiptEntries,
 			Help: "The count of applied receipt entries.",
 		},
 	)
 	pms.serviceErrors = prometheus.NewCounter(
 		prometheus.CounterOpts{
-			Name: pms.cfg.CounterNames.ServiceErrors,
+			Name: names.ServiceErrors,
 			Help: "The count of encountered errors during the service's lifetime.",
 		},
 	)
+	pms.tokensMigrated = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: metricName(names.MigratedTokens, "iota_receipts_tokens_migrated"),
+			Help: "The sum of the deposits of applied receipt entries.",
+		},
+	)
+	pms.treasuryTokens = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: metricName(names.TreasuryTokens, "iota_treasury_tokens"),
+			Help: "The tokens residing in the treasury.",
+		},
+	)
+	pms.lockedUnmintedTokens = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: metricName(names.LockedUnmintedTokens, "iota_wf_tokens_locked_unminted"),
+			Help: "The tokens sent to migration addresses by included bundles which were not yet minted by receipts.",
+		},
+	)
+	pms.legacyMilestoneIndex = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: metricName(names.LegacyMilestoneIndex, "iota_legacy_milestone_index"),
+			Help: "The last queried legacy milestone.",
+		},
+	)
+	pms.c2MilestoneIndex = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: metricName(names.C2MilestoneIndex, "iota_c2_milestone_index"),
+			Help: "The last queried C2 milestone.",
+		},
+	)
+	pms.milestoneLag = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: metricName(names.MilestoneLag, "iota_receipts_legacy_milestone_lag"),
+			Help: "The amount of legacy milestones between the last queried one and the one migrated by the last receipt.",
+		},
+	)
+	pms.legacyQueryLatency = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    metricName(names.LegacyMilestoneQueryLatency, "iota_legacy_milestone_query_latency_seconds"),
+			Help:    "The latency of querying the white-flag confirmation of a legacy milestone.",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+	pms.c2QueryLatency = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    metricName(names.C2MilestoneQueryLatency, "iota_c2_milestone_query_latency_seconds"),
+			Help:    "The latency of querying a C2 milestone.",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+
+	pms.legacySkipped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: metricName(names.SkippedLegacyMilestones, "iota_legacy_milestones_skipped_pruned"),
+			Help: "The count of legacy milestones skipped as they are pruned on all legacy nodes.",
+		},
+	)
+	pms.c2Skipped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: metricName(names.SkippedC2Milestones, "iota_c2_milestones_skipped_pruned"),
+			Help: "The count of C2 milestones skipped as they are pruned on all C2 nodes.",
+		},
+	)
 
-	pms.registry.MustRegister(pms.legacyWfTailsIncluded, pms.receiptEntriesApplied, pms.serviceErrors)
+	pms.registry.MustRegister(
+		pms.legacyWfTailsIncluded, pms.receiptEntriesApplied, pms.serviceErrors, pms.treasuryTokens,
+		pms.legacyMilestoneIndex, pms.c2MilestoneIndex, pms.milestoneLag,
+		pms.legacyQueryLatency, pms.c2QueryLatency, pms.legacySkipped, pms.c2Skipped,
+	)
+	if pms.state.TokenSumsUnknown {
+		log.Println("not exposing the token sums of the prom metrics service, as they are unknown for a state migrated from version 1")
+	} else {
+		pms.registry.MustRegister(pms.tokensMigrated, pms.lockedUnmintedTokens)
+	}
 
 	pms.legacyWfTailsIncluded.Add(float64(pms.state.LegacyTailsIncluded))
 	pms.receiptEntriesApplied.Add(float64(pms.state.ReceiptEntriesApplied))
 	pms.serviceErrors.Add(0)
+	pms.tokensMigrated.Add(float64(pms.state.TokensMigrated))
+	pms.legacySkipped.Add(float64(pms.state.LegacyMilestonesSkipped))
+	pms.c2Skipped.Add(float64(pms.state.C2MilestonesSkipped))
+	pms.updateGauges()
 
 	var err error
-	pms.legacyAPI, err = api.ComposeAPI(api.HTTPClientSettings{
-		URI:    pms.cfg.LegacyNode.URI,
-		Client: &http.Client{Timeout: pms.cfg.LegacyNode.Timeout},
-	})
+	pms.legacyNodes, err = NewLegacyNodePool(&pms.cfg.LegacyNode)
+	if err != nil {
+		return fmt.Errorf("unable to init legacy node pool: %w", err)
+	}
+
+	pms.c2Nodes, err = NewC2NodePool(&pms.cfg.C2Node)
 	if err != nil {
-		return fmt.Errorf("unable to init legcy API: %w", err)
+		return fmt.Errorf("unable to init C2 node pool: %w", err)
 	}
 
-	pms.c2API = iotago.NewNodeHTTPAPIClient(pms.cfg.C2Node.URI, iotago.WithNodeHTTPAPIClientHTTPClient(&http.Client{
-		Timeout: pms.cfg.C2Node.Timeout,
-	}))
+	// registered before the HTTP server serving the shared echo instance is started
+	pms.e.GET("/metrics", func(c echo.Context) error {
+		handler := promhttp.HandlerFor(
+			pms.registry,
+			promhttp.HandlerOpts{EnableOpenMetrics: true},
+		)
+		handler.ServeHTTP(c.Response().Writer, c.Request())
+		return nil
+	})
 
 	return nil
 }
@@ -144,21 +242,18 @@ func (pms *PromMetricsService) Run() error {
 		panic("Init() must be called before Run()")
 	}
 
-	pms.e.GET("/metrics", func(c echo.Context) error {
-		handler := promhttp.HandlerFor(
-			pms.registry,
-			promhttp.HandlerOpts{EnableOpenMetrics: true},
-		)
-		handler.ServeHTTP(c.Response().Writer, c.Request())
-		return nil
-	})
+	runDone := make(chan struct{})
+	defer close(runDone)
+	pms.runDoneMu.Lock()
+	pms.runDone = runDone
+	pms.runDoneMu.Unlock()
 
 	for {
 		select {
-		case <-pms.shutdown:
+		case <-pms.ctx.Done():
 			return nil
-		case <-time.After(pms.cfg.FetchInterval):
-			if err := pms.update(); err != nil {
+		case <-time.After(pms.cfg.fetchInterval()):
+			if err := pms.update(pms.ctx); err != nil && pms.ctx.Err() == nil {
 				log.Printf("unable to update prometheus metrics: %v", err)
 				pms.serviceErrors.Inc()
 			}
@@ -166,39 +261,190 @@ func (pms *PromMetricsService) Run() error {
 	}
 }
 
-// Shutdown shuts down the service.
+// Shutdown shuts down the service and waits for the running update to be aborted.
 func (pms *PromMetricsService) Shutdown(ctx context.Context) error {
 	log.Println("shutting down prometheus metrics service...")
+	pms.cancel()
+
+	pms.runDoneMu.Lock()
+	runDone := pms.runDone
+	pms.runDoneMu.Unlock()
+	if runDone == nil {
+		return nil
+	}
+
 	select {
-	case pms.shutdown <- struct{}{}:
+	case <-runDone:
+		return nil
 	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// loads the persisted state or bootstraps it from the configured start indexes if there is none.
+// A corrupted state file is only replaced if RecoverCorruptedState is set, as rebuilding the state resets the counters.
+func (pms *PromMetricsService) initState() error {
+	filePath := pms.cfg.StateFilePath
+	bootstrap := func() error {
+		pms.state = newPromMetricsState(pms.cfg.LegacyMilestoneStartIndex, pms.cfg.C2MilestoneStartIndex)
+		if err := pms.state.persist(filePath); err != nil {
+			return fmt.Errorf("unable to persist initial state: %w", err)
+		}
+		return nil
+	}
+
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		log.Println("bootstrapping prom metrics service")
+		return bootstrap()
+	}
+
+	pms.state = &prommetricservicestate{}
+	err := pms.state.load(filePath)
+	switch {
+	case errors.Is(err, ErrCorruptedPromMetricsState) && pms.cfg.RecoverCorruptedState:
+		corruptedFilePath := filePath + ".corrupted"
+		log.Printf("recovering prom metrics service from corrupted state (moved to %s): %v", corruptedFilePath, err)
+		if err := os.Rename(filePath, corruptedFilePath); err != nil {
+			return fmt.Errorf("unable to move corrupted state file: %w", err)
+		}
+		return bootstrap()
+	case errors.Is(err, ErrCorruptedPromMetricsState):
+		return fmt.Errorf("%s: %w (enable recoverCorruptedState to rebuild it from the configured start indexes)", filePath, err)
+	case err != nil:
+		return err
+	}
+
+	// write back a possibly migrated state
+	return pms.state.persist(filePath)
+}
+
+// Ready returns an error while the service didn't complete an update which caught up with the nodes.
+func (pms *PromMetricsService) Ready(_ context.Context) error {
+	legacyBacklog, c2Backlog := pms.legacyBacklog.Load(), pms.c2Backlog.Load()
+	switch {
+	case legacyBacklog < 0 || c2Backlog < 0:
+		return errors.New("waiting for the first update")
+	case legacyBacklog > 0 || c2Backlog > 0:
+		return fmt.Errorf("catching up: %d legacy and %d C2 milestones behind", legacyBacklog, c2Backlog)
 	}
 	return nil
 }
 
-// update updates the prometheus counters and then persists the service state.
-func (pms *PromMetricsService) update() error {
+// NodeStatuses returns the status of the nodes the service queries.
+func (pms *PromMetricsService) NodeStatuses(ctx context.Context) *ServiceNodeStatuses {
+	return nodePoolStatuses(ctx, pms.legacyNodes, pms.c2Nodes)
+}
 
-	tailsIncluded, legacyMsTargetIndex, err := pms.queryIncludedTails()
+// update queries the milestones since the last queried ones in batches of CheckpointInterval milestones,
+// after every batch the service state is persisted and then the prometheus counters are updated.
+// At most MaxMilestonesPerUpdate milestones of each network are queried per update, so that a large backlog
+// is worked off over several updates without losing the progress of a failed one.
+func (pms *PromMetricsService) update(ctx context.Context) error {
+	legacyTarget, err := pms.queryLegacyTarget(ctx)
 	if err != nil {
 		return err
 	}
 
-	receiptEntriesApplied, c2MsTargetIndex, err := pms.queryC2NodeReceipts()
+	c2Target, err := pms.queryC2Target(ctx)
 	if err != nil {
 		return err
 	}
 
-	pms.state.LastLegacyMilestoneIndexQueried = legacyMsTargetIndex
-	pms.state.LastC2MilestoneIndexQueried = c2MsTargetIndex
-	pms.state.ReceiptEntriesApplied += receiptEntriesApplied
-	pms.state.LegacyTailsIncluded += tailsIncluded
-	if err := pms.state.persist(pms.cfg.StateFilePath); err != nil {
+	defer func(legacyTarget int, c2Target int) {
+		// nodes behind the last queried milestones are not considered a backlog
+		pms.legacyBacklog.Store(int64(clamp(legacyTarget-pms.state.LastLegacyMilestoneIndexQueried, 0, legacyTarget)))
+		pms.c2Backlog.Store(int64(clamp(c2Target-pms.state.LastC2MilestoneIndexQueried, 0, c2Target)))
+	}(legacyTarget, c2Target)
+
+	if err := pms.skipPrunedMilestones(legacyTarget, c2Target); err != nil {
+		return err
+	}
+
+	if max := pms.cfg.MaxMilestonesPerUpdate; max > 0 {
+		legacyTarget = clamp(legacyTarget, 0, pms.state.LastLegacyMilestoneIndexQueried+max)
+		c2Target = clamp(c2Target, 0, pms.state.LastC2MilestoneIndexQueried+max)
+	}
+
+	checkpointInterval := pms.cfg.CheckpointInterval
+	if checkpointInterval <= 0 {
+		checkpointInterval = defaultCheckpointInterval
+	}
+
+	for pms.state.LastLegacyMilestoneIndexQueried < legacyTarget || pms.state.LastC2MilestoneIndexQueried < c2Target {
+		legacyRes, legacyErr := pms.queryIncludedTails(ctx, clamp(legacyTarget, 0, pms.state.LastLegacyMilestoneIndexQueried+checkpointInterval))
+		c2Res, c2Err := pms.queryC2NodeReceipts(ctx, clamp(c2Target, 0, pms.state.LastC2MilestoneIndexQueried+checkpointInterval))
+
+		// keep the progress made up to the failed milestone
+		if err := pms.checkpoint(legacyRes, c2Res); err != nil {
+			return err
+		}
+		if err := errors.Join(legacyErr, c2Err); err != nil {
+			return err
+		}
+	}
+
+	var treasuryRes *iotago.TreasuryResponse
+	if err := pms.c2Nodes.Do(ctx, func(node *C2Node) error {
+		var err error
+		treasuryRes, err = node.API.Treasury(ctx)
+		return err
+	}); err != nil {
+		return fmt.Errorf("unable to query treasury from c2 node: %w", err)
+	}
+	pms.treasuryTokens.Set(float64(treasuryRes.Amount))
+
+	return nil
+}
+
+// skips the milestones up to the pruning index of the node pools at once, as none of the nodes holds them anymore.
+func (pms *PromMetricsService) skipPrunedMilestones(legacyTarget int, c2Target int) error {
+	legacyRes := &legacyQueryResult{targetIndex: pms.state.LastLegacyMilestoneIndexQueried}
+	if pruningIndex := int(pms.legacyNodes.PruningIndex()); legacyRes.targetIndex < pruningIndex && pruningIndex <= legacyTarget {
+		log.Printf("skipping legacy milestones %d-%d as they are pruned on all legacy nodes", legacyRes.targetIndex+1, pruningIndex)
+		legacyRes.skipped = pruningIndex - legacyRes.targetIndex
+		legacyRes.targetIndex = pruningIndex
+	}
+
+	c2Res := &c2QueryResult{targetIndex: pms.state.LastC2MilestoneIndexQueried}
+	if pruningIndex := int(pms.c2Nodes.PruningIndex()); c2Res.targetIndex < pruningIndex && pruningIndex <= c2Target {
+		log.Printf("skipping C2 milestones %d-%d as they are pruned on all C2 nodes", c2Res.targetIndex+1, pruningIndex)
+		c2Res.skipped = pruningIndex - c2Res.targetIndex
+		c2Res.targetIndex = pruningIndex
+	}
+
+	return pms.checkpoint(legacyRes, c2Res)
+}
+
+// persists the service state advanced by the given results and then updates the prometheus counters.
+func (pms *PromMetricsService) checkpoint(legacyRes *legacyQueryResult, c2Res *c2QueryResult) error {
+	if legacyRes.targetIndex == pms.state.LastLegacyMilestoneIndexQueried && c2Res.targetIndex == pms.state.LastC2MilestoneIndexQueried {
+		return nil
+	}
+
+	// the counters are only bumped once the new state is persisted, so that they never diverge from it
+	newState := *pms.state
+	newState.LastLegacyMilestoneIndexQueried = legacyRes.targetIndex
+	newState.LastC2MilestoneIndexQueried = c2Res.targetIndex
+	newState.ReceiptEntriesApplied += c2Res.receiptEntriesApplied
+	newState.LegacyTailsIncluded += legacyRes.tailsIncluded
+	newState.TokensLocked += legacyRes.tokensLocked
+	newState.TokensMigrated += c2Res.tokensMigrated
+	newState.LegacyMilestonesSkipped += legacyRes.skipped
+	newState.C2MilestonesSkipped += c2Res.skipped
+	if c2Res.lastMigratedAt != 0 {
+		newState.LastMigratedAt = c2Res.lastMigratedAt
+	}
+	if err := newState.persist(pms.cfg.StateFilePath); err != nil {
 		return err
 	}
+	pms.state = &newState
 
-	pms.legacyWfTailsIncluded.Add(float64(tailsIncluded))
-	pms.receiptEntriesApplied.Add(float64(receiptEntriesApplied))
+	pms.legacyWfTailsIncluded.Add(float64(legacyRes.tailsIncluded))
+	pms.receiptEntriesApplied.Add(float64(c2Res.receiptEntriesApplied))
+	pms.tokensMigrated.Add(float64(c2Res.tokensMigrated))
+	pms.legacySkipped.Add(float64(legacyRes.skipped))
+	pms.c2Skipped.Add(float64(c2Res.skipped))
+	pms.updateGauges()
 
 	if pms.cfg.Debug {
 		jsonState, err := json.MarshalIndent(pms.state, "", "   ")
@@ -211,91 +457,237 @@ func (pms *PromMetricsService) update() error {
 	return nil
 }
 
-// queries the amount of newly included tails since the last queried legacy milestone.
-func (pms *PromMetricsService) queryIncludedTails() (int, int, error) {
-	legacyInfo, err := pms.legacyAPI.GetNodeInfo()
-	if err != nil {
-		return 0, 0, fmt.Errorf("unable to query info from legacy node: %w", err)
+// updates the gauges derived from the service state.
+func (pms *PromMetricsService) updateGauges() {
+	var lockedUnminted uint64
+	if pms.state.TokensLocked > pms.state.TokensMigrated {
+		lockedUnminted = pms.state.TokensLocked - pms.state.TokensMigrated
+	}
+	pms.lockedUnmintedTokens.Set(float64(lockedUnminted))
+	pms.legacyMilestoneIndex.Set(float64(pms.state.LastLegacyMilestoneIndexQueried))
+	pms.c2MilestoneIndex.Set(float64(pms.state.LastC2MilestoneIndexQueried))
+
+	var lag int
+	if pms.state.LastMigratedAt != 0 && pms.state.LastLegacyMilestoneIndexQueried > int(pms.state.LastMigratedAt) {
+		lag = pms.state.LastLegacyMilestoneIndexQueried - int(pms.state.LastMigratedAt)
+	}
+	pms.milestoneLag.Set(float64(lag))
+}
+
+// returns the configured metric name or the default one if none is configured.
+func metricName(configured string, def string) string {
+	if len(configured) > 0 {
+		return configured
+	}
+	return def
+}
+
+// queries the latest solid legacy milestone.
+func (pms *PromMetricsService) queryLegacyTarget(ctx context.Context) (int, error) {
+	var legacyInfo *api.GetNodeInfoResponse
+	if err := pms.legacyNodes.Do(ctx, func(node *LegacyNode) error {
+		var err error
+		legacyInfo, err = node.API.GetNodeInfo()
+		return err
+	}); err != nil {
+		return 0, fmt.Errorf("unable to query info from legacy node: %w", err)
+	}
+	return int(legacyInfo.LatestSolidSubtangleMilestoneIndex), nil
+}
+
+// queries the latest confirmed C2 milestone.
+func (pms *PromMetricsService) queryC2Target(ctx context.Context) (int, error) {
+	var c2Info *iotago.NodeInfoResponse
+	if err := pms.c2Nodes.Do(ctx, func(node *C2Node) error {
+		var err error
+		c2Info, err = node.API.Info(ctx)
+		return err
+	}); err != nil {
+		return 0, fmt.Errorf("unable to query info from c2 node: %w", err)
 	}
+	return int(c2Info.ConfirmedMilestoneIndex), nil
+}
+
+// queries the amount of newly included tails from the last queried legacy milestone up to the given one.
+// On error, the result covers the milestones up to the failed one.
+func (pms *PromMetricsService) queryIncludedTails(ctx context.Context, target int) (*legacyQueryResult, error) {
+	from := pms.state.LastLegacyMilestoneIndexQueried + 1
+	if pms.cfg.Debug && from <= target {
+		log.Printf("querying white-flag from %d to %d", from, target)
+	}
+
+	msResults, err := queryMilestoneRange(from, target, pms.cfg.QueryConcurrency, func(index int) (*legacyQueryResult, error) {
+		return pms.queryLegacyMilestone(ctx, index)
+	})
 
-	legacyMsTarget := legacyInfo.LatestSolidSubtangleMilestoneIndex
-	if int(legacyMsTarget) == pms.state.LastLegacyMilestoneIndexQueried {
-		return 0, int(legacyMsTarget), nil
+	res := &legacyQueryResult{targetIndex: pms.state.LastLegacyMilestoneIndexQueried}
+	for _, msRes := range msResults {
+		res.tailsIncluded += msRes.tailsIncluded
+		res.tokensLocked += msRes.tokensLocked
+		res.skipped += msRes.skipped
+		res.targetIndex = msRes.targetIndex
 	}
+	return res, err
+}
 
-	var tailsIncluded int
+// queries the included tails of the given legacy milestone.
+func (pms *PromMetricsService) queryLegacyMilestone(ctx context.Context, index int) (*legacyQueryResult, error) {
+	if pms.cfg.Debug {
+		log.Printf("querying white-flag data of %d", index)
+	}
 
+	var wfData *common.GetWhiteFlagConfirmationResponse
+	start := time.Now()
+	err := pms.legacyNodes.DoAt(ctx, uint32(index), func(node *LegacyNode) error {
+		var err error
+		wfData, err = node.Client.WhiteFlagConfirmation(ctx, index)
+		return err
+	})
+	switch {
+	case errors.Is(err, ErrMilestonePruned):
+		log.Printf("skipping legacy milestone %d: %v", index, err)
+		return &legacyQueryResult{skipped: 1, targetIndex: index}, nil
+	case err != nil:
+		return nil, fmt.Errorf("unable to query white-flag confirmation for legacy milestone %d: %w", index, err)
+	}
+	pms.legacyQueryLatency.Observe(time.Since(start).Seconds())
 	if pms.cfg.Debug {
-		log.Printf("querying white-flag from %d to %d", pms.state.LastLegacyMilestoneIndexQueried+1, legacyMsTarget)
+		log.Printf("white-flag-data of %d - tails included %d", index, len(wfData.IncludedBundles))
 	}
 
-	for i := pms.state.LastLegacyMilestoneIndexQueried + 1; i <= int(legacyMsTarget); i++ {
-		if pms.cfg.Debug {
-			log.Printf("querying white-flag data of %d", i)
-		}
-		wfData, err := common.QueryWhiteFlagConfirmation(pms.cfg.LegacyNode.URI, i)
-		if err != nil {
-			return 0, 0, fmt.Errorf("unable to query white-flag confirmation for legacy milestone %d: %w", i, err)
-		}
-		if pms.cfg.Debug {
-			log.Printf("white-flag-data of %d - tails included %d", i, len(wfData.IncludedBundles))
+	entries, err := expectedEntriesOf(wfData)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse white-flag confirmation of legacy milestone %d: %w", index, err)
+	}
+
+	res := &legacyQueryResult{tailsIncluded: len(wfData.IncludedBundles), targetIndex: index}
+	for _, tailEntries := range entries {
+		for _, entry := range tailEntries {
+			res.tokensLocked += entry.value
 		}
-		tailsIncluded += len(wfData.IncludedBundles)
+	}
+	return res, nil
+}
+
+// queries the amount of newly applied receipt entries from the last queried C2 milestone up to the given one.
+// On error, the result covers the milestones up to the failed one.
+func (pms *PromMetricsService) queryC2NodeReceipts(ctx context.Context, target int) (*c2QueryResult, error) {
+	from := pms.state.LastC2MilestoneIndexQueried + 1
+	if pms.cfg.Debug && from <= target {
+		log.Printf("querying milestones/receipts from %d to %d", from, target)
 	}
 
-	return tailsIncluded, int(legacyMsTarget), nil
+	msResults, err := queryMilestoneRange(from, target, pms.cfg.QueryConcurrency, func(index int) (*c2QueryResult, error) {
+		return pms.queryC2MilestoneReceipt(ctx, index)
+	})
+
+	res := &c2QueryResult{targetIndex: pms.state.LastC2MilestoneIndexQueried}
+	for _, msRes := range msResults {
+		res.receiptEntriesApplied += msRes.receiptEntriesApplied
+		res.tokensMigrated += msRes.tokensMigrated
+		res.skipped += msRes.skipped
+		if msRes.lastMigratedAt != 0 {
+			res.lastMigratedAt = msRes.lastMigratedAt
+		}
+		res.targetIndex = msRes.targetIndex
+	}
+	return res, err
 }
 
-// queries the amount of newly applied receipt entries since the last queried C2 milestone.
-func (pms *PromMetricsService) queryC2NodeReceipts() (int, int, error) {
-	c2Info, err := pms.c2API.Info(context.Background())
-	if err != nil {
-		return 0, 0, fmt.Errorf("unable to query info from c2 node: %w", err)
+// queries the receipt entries of the given C2 milestone.
+func (pms *PromMetricsService) queryC2MilestoneReceipt(ctx context.Context, index int) (*c2QueryResult, error) {
+	if pms.cfg.Debug {
+		log.Printf("querying C2 milestone %d", index)
 	}
 
-	var receiptEntriesApplied int
+	var milestone *iotago.Milestone
+	start := time.Now()
+	err := pms.c2Nodes.DoAt(ctx, uint32(index), func(node *C2Node) error {
+		var err error
+		milestone, err = queryC2Milestone(ctx, node.API, uint32(index))
+		return err
+	})
+	switch {
+	case errors.Is(err, ErrMilestonePruned):
+		log.Printf("skipping C2 milestone %d: %v", index, err)
+		return &c2QueryResult{skipped: 1, targetIndex: index}, nil
+	case err != nil:
+		return nil, err
+	}
+	pms.c2QueryLatency.Observe(time.Since(start).Seconds())
 
-	c2MsTarget := c2Info.ConfirmedMilestoneIndex
-	if int(c2MsTarget) == pms.state.LastC2MilestoneIndexQueried {
-		return 0, int(c2MsTarget), nil
+	res := &c2QueryResult{targetIndex: index}
+	if milestone.Receipt == nil {
+		return res, nil
 	}
 
+	receipt := milestone.Receipt.(*iotago.Receipt)
 	if pms.cfg.Debug {
-		log.Printf("querying milestones/receipts from %d to %d", pms.state.LastC2MilestoneIndexQueried+1, c2MsTarget)
+		log.Printf("C2 milestone %d receipt has %d entries", index, len(receipt.Funds))
 	}
+	res.receiptEntriesApplied = len(receipt.Funds)
+	res.tokensMigrated = receipt.Sum()
+	res.lastMigratedAt = receipt.MigratedAt
+	return res, nil
+}
 
-	for i := pms.state.LastC2MilestoneIndexQueried + 1; i <= int(c2MsTarget); i++ {
-		if pms.cfg.Debug {
-			log.Printf("querying C2 milestone %d", i)
-		}
-		msRes, err := pms.c2API.MilestoneByIndex(context.Background(), uint32(i))
-		if err != nil {
-			return 0, 0, fmt.Errorf("unable to query milestone %d from c2 node: %w", i, err)
-		}
+// queries the milestones within [from, to] using at most the given amount of concurrent queries.
+// The results are ordered by milestone index. On error, only the results of the milestones before the first
+// failed one are returned, together with the error of the first failed milestone.
+func queryMilestoneRange[T any](from int, to int, concurrency int, query func(index int) (T, error)) ([]T, error) {
+	if from > to {
+		return nil, nil
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
 
-		msgIDBytes, err := hex.DecodeString(msRes.MessageID)
-		if err != nil {
-			return 0, 0, fmt.Errorf("unable to convert milestone %d's msg hex ID: %w", i, err)
-		}
-		var msID iotago.MessageID
-		copy(msID[:], msgIDBytes)
+	results := make([]T, to-from+1)
+	errs := make([]error, len(results))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < len(results); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i], errs[i] = query(from + i)
+			}
+		}()
+	}
+	for i := range results {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
 
-		msg, err := pms.c2API.MessageByMessageID(context.Background(), msID)
+	for i, err := range errs {
 		if err != nil {
-			return 0, 0, fmt.Errorf("unable to query msg containing milestone %d from c2 node: %w", i, err)
+			return results[:i], err
 		}
+	}
+	return results, nil
+}
 
-		milestone := msg.Payload.(*iotago.Milestone)
-		if milestone.Receipt == nil {
-			continue
-		}
+// queries the milestone payload of the given C2 milestone.
+func queryC2Milestone(ctx context.Context, c2API *iotago.NodeHTTPAPIClient, index uint32) (*iotago.Milestone, error) {
+	msRes, err := c2API.MilestoneByIndex(ctx, index)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query milestone %d from c2 node: %w", index, err)
+	}
 
-		receipt := milestone.Receipt.(*iotago.Receipt)
-		if pms.cfg.Debug {
-			log.Printf("C2 milestone %d receipt has %d entries", i, len(receipt.Funds))
-		}
-		receiptEntriesApplied += len(receipt.Funds)
+	msgIDBytes, err := hex.DecodeString(msRes.MessageID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert milestone %d's msg hex ID: %w", index, err)
+	}
+	var msID iotago.MessageID
+	copy(msID[:], msgIDBytes)
+
+	msg, err := c2API.MessageByMessageID(ctx, msID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query msg containing milestone %d from c2 node: %w", index, err)
 	}
 
-	return receiptEntriesApplied, int(c2MsTarget), nil
+	return msg.Payload.(*iotago.Milestone), nil
 }
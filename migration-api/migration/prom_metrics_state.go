@@ -0,0 +1,132 @@
+package migration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// ErrCorruptedPromMetricsState is returned when the prom metrics service state file can't be parsed.
+	ErrCorruptedPromMetricsState = errors.New("corrupted prom metrics service state")
+	// ErrUnsupportedPromMetricsStateVersion is returned when the prom metrics service state file
+	// was written by a newer version of this service.
+	ErrUnsupportedPromMetricsStateVersion = errors.New("unsupported prom metrics service state version")
+)
+
+const (
+	// the version of the prom metrics service state schema written by this service.
+	// version 1 is the schema without a version field.
+	promMetricsStateVersion = 3
+)
+
+// represents the state of the prom metrics service.
+type prommetricservicestate struct {
+	// The version of the state schema.
+	Version int `json:"version"`
+	// The last milestone queried for white-flag confirmation data.
+	LastLegacyMilestoneIndexQueried int `json:"lastLegacyMilestoneIndexQueried"`
+	// The last milestone queried for receipt data.
+	LastC2MilestoneIndexQueried int `json:"lastC2MilestoneIndexQueried"`
+	// The persisted counter of confirmed legacy tail txs.
+	LegacyTailsIncluded int `json:"legacyTailsIncluded"`
+	// The persisted counter of applied receipt entries.
+	ReceiptEntriesApplied int `json:"receiptEntriesApplied"`
+	// The persisted sum of the tokens sent to migration addresses by included bundles.
+	TokensLocked uint64 `json:"tokensLocked"`
+	// The persisted sum of the deposits of applied receipt entries.
+	TokensMigrated uint64 `json:"tokensMigrated"`
+	// The legacy milestone at which the funds of the last applied receipt were migrated.
+	LastMigratedAt uint32 `json:"lastMigratedAt"`
+	// The persisted counter of legacy milestones skipped as they are pruned on all legacy nodes.
+	LegacyMilestonesSkipped int `json:"legacyMilestonesSkipped"`
+	// The persisted counter of C2 milestones skipped as they are pruned on all C2 nodes.
+	C2MilestonesSkipped int `json:"c2MilestonesSkipped"`
+	// Whether the token sums are unknown, as the state was migrated from version 1 which didn't sum up the tokens.
+	// They then only cover the milestones queried since and are therefore not exposed.
+	TokenSumsUnknown bool `json:"tokenSumsUnknown,omitempty"`
+}
+
+// returns a new state starting at the given milestones.
+func newPromMetricsState(legacyMilestoneStartIndex int, c2MilestoneStartIndex int) *prommetricservicestate {
+	return &prommetricservicestate{
+		Version:                         promMetricsStateVersion,
+		LastLegacyMilestoneIndexQueried: legacyMilestoneStartIndex,
+		LastC2MilestoneIndexQueried:     c2MilestoneStartIndex,
+	}
+}
+
+// persists the state to the given file by writing it to a temporary file first, which then replaces
+// the previous state file. This way the state file either contains the previous or the new state, even on crashes.
+func (pmss *prommetricservicestate) persist(filePath string) error {
+	jsonState, err := json.MarshalIndent(pmss, "", "   ")
+	if err != nil {
+		return fmt.Errorf("unable to serialize state: %w", err)
+	}
+
+	tmpFilePath := filePath + ".tmp"
+	tmpFile, err := os.OpenFile(tmpFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return fmt.Errorf("unable to create temporary state file: %w", err)
+	}
+	if _, err := tmpFile.Write(jsonState); err != nil {
+		_ = tmpFile.Close()
+		return fmt.Errorf("unable to write state to disk: %w", err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		_ = tmpFile.Close()
+		return fmt.Errorf("unable to sync state to disk: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("unable to close temporary state file: %w", err)
+	}
+	if err := os.Rename(tmpFilePath, filePath); err != nil {
+		return fmt.Errorf("unable to replace state file: %w", err)
+	}
+
+	// sync the directory so that the rename itself survives a crash
+	dir, err := os.Open(filepath.Dir(filePath))
+	if err != nil {
+		return fmt.Errorf("unable to open state file directory: %w", err)
+	}
+	defer func() { _ = dir.Close() }()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("unable to sync state file directory: %w", err)
+	}
+	return nil
+}
+
+// loads the state from the given file and migrates it to the current version.
+// Returns ErrCorruptedPromMetricsState if the file can't be parsed.
+func (pmss *prommetricservicestate) load(filePath string) error {
+	jsonState, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("unable to read state file: %w", err)
+	}
+	if err := json.Unmarshal(jsonState, pmss); err != nil {
+		return fmt.Errorf("%w: unable to deserialize state: %v", ErrCorruptedPromMetricsState, err)
+	}
+
+	if pmss.Version == 0 {
+		pmss.Version = 1
+	}
+	if pmss.Version > promMetricsStateVersion {
+		return fmt.Errorf("%w: %d, max supported is %d", ErrUnsupportedPromMetricsStateVersion, pmss.Version, promMetricsStateVersion)
+	}
+	if pmss.LastLegacyMilestoneIndexQueried < 0 || pmss.LastC2MilestoneIndexQueried < 0 ||
+		pmss.LegacyTailsIncluded < 0 || pmss.ReceiptEntriesApplied < 0 {
+		return fmt.Errorf("%w: negative milestone index or counter", ErrCorruptedPromMetricsState)
+	}
+
+	if pmss.Version < 2 {
+		// version 1 didn't sum up the tokens, they could only be rebuilt by querying the milestones from the start again.
+		// the milestone indexes and counters are kept, while the token sums are marked as unknown
+		pmss.TokenSumsUnknown = true
+	}
+	// version 3 added the counters of skipped pruned milestones, which rightfully start at zero
+	// as no milestones were skipped before
+	pmss.Version = promMetricsStateVersion
+	return nil
+}
@@ -0,0 +1,151 @@
+package migration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromMetricsStatePersistAndLoad(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "prom_metrics_service.state")
+
+	state := newPromMetricsState(100, 200)
+	state.LegacyTailsIncluded = 5
+	state.TokensMigrated = 1000000
+	require.NoError(t, state.persist(filePath))
+
+	_, err := os.Stat(filePath + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	loaded := &prommetricservicestate{}
+	require.NoError(t, loaded.load(filePath))
+	assert.Equal(t, state, loaded)
+}
+
+func TestPromMetricsStateMigration(t *testing.T) {
+	var tests = []struct {
+		name     string
+		state    string
+		expState *prommetricservicestate
+	}{
+		{
+			name: "version 2",
+			state: `{
+   "version": 2,
+   "lastLegacyMilestoneIndexQueried": 300,
+   "lastC2MilestoneIndexQueried": 400,
+   "legacyTailsIncluded": 5,
+   "receiptEntriesApplied": 3,
+   "tokensLocked": 7000000,
+   "tokensMigrated": 3000000
+}`,
+			expState: &prommetricservicestate{
+				Version:                         promMetricsStateVersion,
+				LastLegacyMilestoneIndexQueried: 300,
+				LastC2MilestoneIndexQueried:     400,
+				LegacyTailsIncluded:             5,
+				ReceiptEntriesApplied:           3,
+				TokensLocked:                    7000000,
+				TokensMigrated:                  3000000,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "prom_metrics_service.state")
+			require.NoError(t, os.WriteFile(filePath, []byte(tt.state), 0666))
+
+			state := &prommetricservicestate{}
+			require.NoError(t, state.load(filePath))
+			assert.Equal(t, tt.expState, state)
+		})
+	}
+
+	filePath := filepath.Join(t.TempDir(), "prom_metrics_service.state")
+	require.NoError(t, os.WriteFile(filePath, []byte(`{"version": 99}`), 0666))
+	assert.ErrorIs(t, (&prommetricservicestate{}).load(filePath), ErrUnsupportedPromMetricsStateVersion)
+}
+
+func TestPromMetricsServiceVersion1State(t *testing.T) {
+	// a state file written by version 1 of the service, without a version field and token sums
+	v1State, err := os.ReadFile("testdata/prom_metrics_service_v1.state")
+	require.NoError(t, err)
+	filePath := filepath.Join(t.TempDir(), "prom_metrics_service.state")
+	require.NoError(t, os.WriteFile(filePath, v1State, 0666))
+
+	cfg := &PromMetricsServiceConfig{
+		LegacyMilestoneStartIndex: 100,
+		C2MilestoneStartIndex:     200,
+		StateFilePath:             filePath,
+		LegacyNode:                LegacyNodeConfig{URI: "http://localhost:14265"},
+		C2Node:                    C2NodeConfig{URI: "http://localhost:14266"},
+	}
+	cfg.CounterNames.ServiceErrors = "service_errors"
+	cfg.CounterNames.IncludedLegacyTails = "included_legacy_tails"
+	cfg.CounterNames.AppliedReceiptEntries = "applied_receipt_entries"
+
+	// the state is migrated without recoverCorruptedState, keeping the milestone indexes and counters
+	pms := NewPromMetricsService(echo.New(), cfg)
+	require.NoError(t, pms.Init())
+	expState := &prommetricservicestate{
+		Version:                         promMetricsStateVersion,
+		LastLegacyMilestoneIndexQueried: 1000021,
+		LastC2MilestoneIndexQueried:     19,
+		LegacyTailsIncluded:             12,
+		ReceiptEntriesApplied:           12,
+		TokenSumsUnknown:                true,
+	}
+	assert.Equal(t, expState, pms.state)
+
+	// the unknown token sums are not exposed
+	families, err := pms.registry.Gather()
+	require.NoError(t, err)
+	metrics := make(map[string]float64)
+	for _, family := range families {
+		if metric := family.GetMetric()[0]; metric.GetCounter() != nil {
+			metrics[family.GetName()] = metric.GetCounter().GetValue()
+		}
+	}
+	assert.EqualValues(t, 12, metrics["included_legacy_tails"])
+	assert.EqualValues(t, 12, metrics["applied_receipt_entries"])
+	assert.NotContains(t, metrics, "iota_receipts_tokens_migrated")
+	for _, family := range families {
+		assert.NotEqual(t, "iota_wf_tokens_locked_unminted", family.GetName())
+	}
+
+	// the migrated state was written back
+	loaded := &prommetricservicestate{}
+	require.NoError(t, loaded.load(filePath))
+	assert.Equal(t, expState, loaded)
+}
+
+func TestPromMetricsServiceCorruptedState(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "prom_metrics_service.state")
+	require.NoError(t, os.WriteFile(filePath, []byte(`{"lastLegacyMilestoneIndexQue`), 0666))
+
+	cfg := &PromMetricsServiceConfig{
+		LegacyMilestoneStartIndex: 100,
+		C2MilestoneStartIndex:     200,
+		StateFilePath:             filePath,
+	}
+
+	pms := NewPromMetricsService(nil, cfg)
+	assert.ErrorIs(t, pms.initState(), ErrCorruptedPromMetricsState)
+
+	cfg.RecoverCorruptedState = true
+	require.NoError(t, pms.initState())
+	assert.Equal(t, newPromMetricsState(100, 200), pms.state)
+
+	corrupted, err := os.ReadFile(filePath + ".corrupted")
+	require.NoError(t, err)
+	assert.Equal(t, `{"lastLegacyMilestoneIndexQue`, string(corrupted))
+
+	loaded := &prommetricservicestate{}
+	require.NoError(t, loaded.load(filePath))
+	assert.Equal(t, pms.state, loaded)
+}
@@ -0,0 +1,117 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryMilestoneRange(t *testing.T) {
+	var inFlight, maxInFlight int32
+	query := func(index int) (int, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		return index * 2, nil
+	}
+
+	results, err := queryMilestoneRange(10, 29, 3, query)
+	require.NoError(t, err)
+	require.Len(t, results, 20)
+	for i, res := range results {
+		assert.Equal(t, (10+i)*2, res)
+	}
+	assert.LessOrEqual(t, maxInFlight, int32(3))
+
+	results, err = queryMilestoneRange(10, 9, 3, query)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestQueryMilestoneRangeError(t *testing.T) {
+	errQuery := errors.New("query failed")
+
+	results, err := queryMilestoneRange(1, 10, 4, func(index int) (int, error) {
+		if index == 5 || index == 8 {
+			return 0, errQuery
+		}
+		return index, nil
+	})
+	require.ErrorIs(t, err, errQuery)
+	assert.Equal(t, []int{1, 2, 3, 4}, results)
+}
+
+func TestPromMetricsServiceShutdown(t *testing.T) {
+	// a legacy node whose white-flag confirmations block until the request is canceled
+	requested := make(chan struct{})
+	var requestedOnce sync.Once
+	legacyNode := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Command string `json:"command"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch req.Command {
+		case "getNodeInfo":
+			_, _ = fmt.Fprint(w, `{"latestMilestoneIndex": 110, "latestSolidSubtangleMilestoneIndex": 110}`)
+		default:
+			requestedOnce.Do(func() { close(requested) })
+			<-r.Context().Done()
+		}
+	}))
+	t.Cleanup(legacyNode.Close)
+	var cmi atomic.Uint32
+	cmi.Store(200)
+	c2Node := newTestReceiptsNode(t, &cmi, nil)
+
+	cfg := &PromMetricsServiceConfig{
+		LegacyMilestoneStartIndex: 100,
+		C2MilestoneStartIndex:     200,
+		StateFilePath:             filepath.Join(t.TempDir(), "prom_metrics_service.state"),
+		FetchInterval:             10 * time.Millisecond,
+		LegacyNode:                LegacyNodeConfig{URI: legacyNode.URL, Timeout: time.Hour},
+		C2Node:                    C2NodeConfig{URI: c2Node.URL, Timeout: time.Second},
+	}
+	cfg.CounterNames.ServiceErrors = "service_errors"
+	cfg.CounterNames.IncludedLegacyTails = "included_legacy_tails"
+	cfg.CounterNames.AppliedReceiptEntries = "applied_receipt_entries"
+
+	pms := NewPromMetricsService(echo.New(), cfg)
+	require.NoError(t, pms.Init())
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- pms.Run() }()
+	<-requested
+
+	// the pending query is aborted instead of waiting for its timeout
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, pms.Shutdown(ctx))
+	require.NoError(t, <-runErr)
+	assert.Equal(t, 100, pms.state.LastLegacyMilestoneIndexQueried)
+
+	// a run which doesn't return in time fails the shutdown
+	pms.runDone = make(chan struct{})
+	expired, cancelExpired := context.WithTimeout(context.Background(), 0)
+	defer cancelExpired()
+	assert.ErrorIs(t, pms.Shutdown(expired), context.DeadlineExceeded)
+}
@@ -0,0 +1,149 @@
+package migration
+
+import (
+	"github.com/iotaledger/iota.go/consts"
+	"github.com/iotaledger/iota.go/trinary"
+)
+
+// Reconciliation joins the lockings on the legacy network with the receipts on the C2 network.
+type Reconciliation struct {
+	// The first indexed legacy milestone, lockings confirmed before it are unknown.
+	LegacyMilestoneStartIndex uint32 `json:"legacyMilestoneStartIndex"`
+	// The last indexed legacy milestone.
+	LegacyMilestoneIndex uint32 `json:"legacyMilestoneIndex"`
+	// The last indexed C2 milestone.
+	C2MilestoneIndex uint32 `json:"c2MilestoneIndex"`
+	// The lockings which do not appear in any receipt.
+	UnmintedLockings []*LockedFunds `json:"unmintedLockings"`
+	// The sum of the unminted lockings.
+	UnmintedTokens uint64 `json:"unmintedTokens"`
+	// The receipt entries which do not correspond to a confirmed locking.
+	UnmatchedReceiptEntries []*UnmatchedReceiptEntry `json:"unmatchedReceiptEntries"`
+	// The sum of the unmatched receipt entries.
+	UnmatchedTokens uint64 `json:"unmatchedTokens"`
+	// The ranges of legacy milestones which were skipped as no legacy node held them anymore
+	// or their extended ledger diff was inconsistent.
+	PrunedLegacyMilestones []MilestoneRange `json:"prunedLegacyMilestones"`
+	// The amount of receipt entries which migrated funds confirmed before the first indexed legacy milestone
+	// or within pruned legacy milestones and therefore can't be matched against lockings.
+	UnverifiableReceiptEntries uint64 `json:"unverifiableReceiptEntries"`
+	// The amount of receipt entries which migrated funds confirmed after the last indexed legacy milestone.
+	// They are matched against lockings once the legacy milestones are indexed.
+	PendingReceiptEntries uint64 `json:"pendingReceiptEntries"`
+	// The sum of all receipt entries.
+	TokensMinted uint64 `json:"tokensMinted"`
+	// The tokens in the treasury as of the last indexed C2 milestone.
+	TreasuryTokens uint64 `json:"treasuryTokens"`
+	// The tokens which left the treasury (total supply - treasury tokens).
+	TokensMigrated uint64 `json:"tokensMigrated"`
+	// The difference between the tokens which left the treasury and the minted tokens, must be zero.
+	TreasuryDelta int64 `json:"treasuryDelta"`
+}
+
+// UnmatchedReceiptEntry is a receipt entry which does not correspond to a confirmed locking.
+type UnmatchedReceiptEntry struct {
+	Funds
+	// The C2 milestone in which the receipt was embedded.
+	EmbeddedMilestoneIndex uint32 `json:"embeddedMilestoneIndex"`
+	// The legacy milestone at which the funds were migrated.
+	LegacyMilestoneIndex uint32 `json:"legacyMilestoneIndex"`
+	// Why the entry does not match a locking.
+	Reason string `json:"reason"`
+}
+
+// Reconcile joins the indexed lockings with the indexed receipts and compares the minted tokens
+// with the tokens which left the treasury as of the last indexed C2 milestone.
+func (idx *MigrationIndex) Reconcile() *Reconciliation {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	rec := &Reconciliation{
+		LegacyMilestoneStartIndex: idx.firstLegacyMilestoneIndex,
+		LegacyMilestoneIndex:      idx.legacyMilestoneIndex,
+		C2MilestoneIndex:          idx.c2MilestoneIndex,
+		UnmintedLockings:          make([]*LockedFunds, 0),
+		UnmatchedReceiptEntries:   make([]*UnmatchedReceiptEntry, 0),
+		PrunedLegacyMilestones:    make([]MilestoneRange, len(idx.prunedLegacyMilestones)),
+		TreasuryTokens:            idx.treasuryTokens,
+		TokensMigrated:            consts.TotalSupply - idx.treasuryTokens,
+	}
+	copy(rec.PrunedLegacyMilestones, idx.prunedLegacyMilestones)
+
+	for _, locking := range idx.lockings {
+		if _, minted := idx.mintsByTail[locking.TailTransactionHash]; minted {
+			continue
+		}
+		rec.UnmintedLockings = append(rec.UnmintedLockings, locking)
+		rec.UnmintedTokens += locking.Value
+	}
+
+	seenTails := make(map[trinary.Hash]struct{})
+	for _, receipt := range idx.receipts {
+		for _, funds := range receipt.Funds {
+			rec.TokensMinted += funds.Value
+
+			switch {
+			case idx.firstLegacyMilestoneIndex == 0 || receipt.LegacyMilestoneIndex < idx.firstLegacyMilestoneIndex:
+				rec.UnverifiableReceiptEntries++
+				continue
+			case receipt.LegacyMilestoneIndex > idx.legacyMilestoneIndex:
+				// the legacy indexing lags behind the C2 network
+				rec.PendingReceiptEntries++
+				continue
+			case idx.prunedUpTo(receipt.LegacyMilestoneIndex) && !idx.hasLocking(funds):
+				rec.UnverifiableReceiptEntries++
+				continue
+			}
+
+			reason := idx.receiptEntryMismatch(funds, seenTails)
+			if len(reason) == 0 {
+				continue
+			}
+			rec.UnmatchedReceiptEntries = append(rec.UnmatchedReceiptEntries, &UnmatchedReceiptEntry{
+				Funds:                  funds,
+				EmbeddedMilestoneIndex: receipt.EmbeddedMilestoneIndex,
+				LegacyMilestoneIndex:   receipt.LegacyMilestoneIndex,
+				Reason:                 reason,
+			})
+			rec.UnmatchedTokens += funds.Value
+		}
+	}
+
+	rec.TreasuryDelta = int64(rec.TokensMigrated) - int64(rec.TokensMinted)
+	return rec
+}
+
+// tells whether a locking of the given receipt entry was indexed, must be called with the lock held.
+func (idx *MigrationIndex) hasLocking(funds Funds) bool {
+	tailTxHash, err := ParseTailTransactionHash(funds.TailTransactionHash)
+	if err != nil {
+		return false
+	}
+	_, has := idx.lockingsByTail[tailTxHash]
+	return has
+}
+
+// returns why the given receipt entry does not match its locking or an empty string if it does.
+// seenTails holds the tail transaction hashes of the previously checked entries, must be called with the lock held.
+func (idx *MigrationIndex) receiptEntryMismatch(funds Funds, seenTails map[trinary.Hash]struct{}) string {
+	tailTxHash, err := ParseTailTransactionHash(funds.TailTransactionHash)
+	if err != nil {
+		return "malformed tail transaction hash"
+	}
+
+	_, seen := seenTails[tailTxHash]
+	seenTails[tailTxHash] = struct{}{}
+
+	locking, has := idx.lockingsByTail[tailTxHash]
+	switch {
+	case seen:
+		return "tail transaction was minted multiple times"
+	case !has:
+		return "no confirmed locking with this tail transaction"
+	case locking.Value != funds.Value:
+		return "value differs from the locked value"
+	case locking.TargetEd25519Address != funds.TargetEd25519Address:
+		return "target address differs from the locked migration address"
+	}
+	return ""
+}
@@ -0,0 +1,150 @@
+package migration
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/iotaledger/iota.go/consts"
+	"github.com/iotaledger/iota.go/encoding/t5b1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationIndexReconcile(t *testing.T) {
+	index := NewMigrationIndex()
+
+	const target = "efdc112efe262b304bcf379b26c31bad029f616ee3ec4aa6345a366e4c9e43a3"
+	const unmintedTailTxHash = "LKLDRXVJIHIFKGYUEGVFMNUKPYEXZWOMAX9LRLRRAVQRVCZQRDIMSRAFAPEAEIXIPQXLLYFYFVDURIQCW"
+	const unknownTailTxHash = "AAAAAXVJIHIFKGYUEGVFMNUKPYEXZWOMAX9LRLRRAVQRVCZQRDIMSRAFAPEAEIXIPQXLLYFYFVDURIQCW"
+
+	receiptFunds := func(tailTxHash string, value uint64) Funds {
+		return Funds{
+			TailTransactionHash:  hex.EncodeToString(t5b1.EncodeTrytes(tailTxHash)),
+			Value:                value,
+			TargetEd25519Address: target,
+		}
+	}
+
+	// a receipt migrating funds confirmed before the index start
+	index.AddReceipts(10000, []*RecentReceipt{{
+		EmbeddedMilestoneIndex: 10000,
+		LegacyMilestoneIndex:   999,
+		Funds:                  []Funds{receiptFunds(unknownTailTxHash, 7)},
+	}}, 0)
+
+	index.AddLegacyMilestone(1000, []*LockedFunds{
+		{Funds: Funds{TailTransactionHash: testTailTxHash, Value: 5000000, TargetEd25519Address: target}, LegacyMilestoneIndex: 1000},
+		{Funds: Funds{TailTransactionHash: unmintedTailTxHash, Value: 1000000000, TargetEd25519Address: target}, LegacyMilestoneIndex: 1000},
+	})
+
+	index.AddReceipts(10024, []*RecentReceipt{{
+		EmbeddedMilestoneIndex: 10024,
+		LegacyMilestoneIndex:   1000,
+		Funds: []Funds{
+			receiptFunds(testTailTxHash, 5000000),
+			receiptFunds(testTailTxHash, 5000000),
+			receiptFunds(unknownTailTxHash, 3),
+		},
+	}}, 0)
+
+	rec := index.Reconcile()
+	assert.EqualValues(t, 1000, rec.LegacyMilestoneStartIndex)
+	assert.EqualValues(t, 1000, rec.LegacyMilestoneIndex)
+	assert.EqualValues(t, 10024, rec.C2MilestoneIndex)
+
+	require.Len(t, rec.UnmintedLockings, 1)
+	assert.Equal(t, unmintedTailTxHash, rec.UnmintedLockings[0].TailTransactionHash)
+	assert.EqualValues(t, 1000000000, rec.UnmintedTokens)
+
+	require.Len(t, rec.UnmatchedReceiptEntries, 2)
+	assert.Equal(t, "tail transaction was minted multiple times", rec.UnmatchedReceiptEntries[0].Reason)
+	assert.Equal(t, "no confirmed locking with this tail transaction", rec.UnmatchedReceiptEntries[1].Reason)
+	assert.EqualValues(t, 5000003, rec.UnmatchedTokens)
+
+	assert.EqualValues(t, 1, rec.UnverifiableReceiptEntries)
+	assert.EqualValues(t, 10000010, rec.TokensMinted)
+}
+
+func TestMigrationIndexReconcilePruned(t *testing.T) {
+	index := NewMigrationIndex()
+
+	const target = "efdc112efe262b304bcf379b26c31bad029f616ee3ec4aa6345a366e4c9e43a3"
+	const prunedTailTxHash = "AAAAAXVJIHIFKGYUEGVFMNUKPYEXZWOMAX9LRLRRAVQRVCZQRDIMSRAFAPEAEIXIPQXLLYFYFVDURIQCW"
+
+	index.AddLegacyMilestone(1000, nil)
+	index.SkipLegacyMilestones(1001, 1001)
+	index.SkipLegacyMilestones(1002, 1005)
+	index.AddLegacyMilestone(1006, nil)
+
+	assert.Equal(t, []MilestoneRange{{From: 1001, To: 1005}}, index.PrunedLegacyMilestones())
+	legacyIndex, _ := index.MilestoneIndexes()
+	assert.EqualValues(t, 1006, legacyIndex)
+
+	index.AddReceipts(10000, []*RecentReceipt{{
+		EmbeddedMilestoneIndex: 10000,
+		LegacyMilestoneIndex:   1006,
+		Funds: []Funds{{
+			TailTransactionHash:  hex.EncodeToString(t5b1.EncodeTrytes(prunedTailTxHash)),
+			Value:                1000000,
+			TargetEd25519Address: target,
+		}},
+	}}, 0)
+
+	rec := index.Reconcile()
+	assert.Equal(t, []MilestoneRange{{From: 1001, To: 1005}}, rec.PrunedLegacyMilestones)
+	assert.Empty(t, rec.UnmatchedReceiptEntries)
+	assert.EqualValues(t, 1, rec.UnverifiableReceiptEntries)
+}
+
+func TestMigrationIndexReconcileLegacyIndexBehind(t *testing.T) {
+	index := NewMigrationIndex()
+
+	const target = "efdc112efe262b304bcf379b26c31bad029f616ee3ec4aa6345a366e4c9e43a3"
+
+	index.AddLegacyMilestone(1000, nil)
+	// the receipt migrates funds confirmed by a legacy milestone which isn't indexed yet
+	index.AddReceipts(10000, []*RecentReceipt{{
+		EmbeddedMilestoneIndex: 10000,
+		LegacyMilestoneIndex:   1002,
+		Funds: []Funds{{
+			TailTransactionHash:  hex.EncodeToString(t5b1.EncodeTrytes(testTailTxHash)),
+			Value:                5000000,
+			TargetEd25519Address: target,
+		}},
+	}}, consts.TotalSupply-5000000)
+
+	rec := index.Reconcile()
+	assert.Empty(t, rec.UnmatchedReceiptEntries)
+	assert.EqualValues(t, 1, rec.PendingReceiptEntries)
+	assert.EqualValues(t, 0, rec.TreasuryDelta)
+
+	// once the legacy index caught up, the entry is matched against its locking
+	index.AddLegacyMilestone(1001, nil)
+	index.AddLegacyMilestone(1002, []*LockedFunds{
+		{Funds: Funds{TailTransactionHash: testTailTxHash, Value: 5000000, TargetEd25519Address: target}, LegacyMilestoneIndex: 1002},
+	})
+
+	rec = index.Reconcile()
+	assert.Empty(t, rec.UnmatchedReceiptEntries)
+	assert.Empty(t, rec.UnmintedLockings)
+	assert.EqualValues(t, 0, rec.PendingReceiptEntries)
+}
+
+func TestMigrationIndexReconcileTreasuryDelta(t *testing.T) {
+	index := NewMigrationIndex()
+
+	// the treasury as of the indexed C2 milestone is compared, not the one of a later milestone
+	index.AddReceipts(10000, []*RecentReceipt{{
+		EmbeddedMilestoneIndex: 10000,
+		LegacyMilestoneIndex:   999,
+		Funds:                  []Funds{{Value: 7}},
+	}}, consts.TotalSupply-7)
+
+	rec := index.Reconcile()
+	assert.EqualValues(t, consts.TotalSupply-7, rec.TreasuryTokens)
+	assert.EqualValues(t, 7, rec.TokensMigrated)
+	assert.EqualValues(t, 0, rec.TreasuryDelta)
+
+	index.AddReceipts(10001, nil, consts.TotalSupply-10)
+	assert.EqualValues(t, 3, index.Reconcile().TreasuryDelta)
+}
@@ -0,0 +1,261 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// the backoff before the first restart used if none is configured.
+	defaultRestartBackoff = time.Second
+)
+
+// ErrServiceFailed is returned by Supervisor.Run when a critical service failed and is not restarted anymore.
+var ErrServiceFailed = errors.New("service failed")
+
+// Service can be initialized, run and be shut down.
+type Service interface {
+	// Init inits the service.
+	Init() error
+	// Run runs the service.
+	Run() error
+	// Shutdown shuts down the service.
+	Shutdown(context.Context) error
+}
+
+// RestartPolicy defines how the Supervisor handles a service whose Run returned an error or panicked.
+type RestartPolicy struct {
+	// The amount of consecutive restarts after which the service is given up, negative for unlimited restarts.
+	MaxRestarts int
+	// The backoff before the first restart, doubled with every consecutive restart.
+	InitialBackoff time.Duration
+	// The maximum backoff between restarts. A run lasting longer than it is not considered a consecutive failure.
+	MaxBackoff time.Duration
+	// Whether Supervisor.Run returns once the service is given up, so that the other services are shut down.
+	Critical bool
+}
+
+// NewSupervisor creates a new Supervisor without any services.
+func NewSupervisor() *Supervisor {
+	return &Supervisor{stopping: make(chan struct{})}
+}
+
+// Supervisor inits, runs and shuts down services and restarts failed services according to their RestartPolicy.
+type Supervisor struct {
+	mu       sync.Mutex
+	services []*supervisedService
+	// closed on shutdown, aborts the backoff of restarting services.
+	stopping chan struct{}
+	stopped  bool
+	wg       sync.WaitGroup
+}
+
+// a service and its state within the Supervisor.
+type supervisedService struct {
+	name   string
+	srv    Service
+	policy RestartPolicy
+
+	mu      sync.Mutex
+	running bool
+	stopped bool
+}
+
+// Add adds the given service under the given name. Services are initialized in the order they are added
+// and shut down in the reverse order. Must be called before Init.
+func (s *Supervisor) Add(name string, srv Service, policy RestartPolicy) {
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = defaultRestartBackoff
+	}
+	if policy.MaxBackoff < policy.InitialBackoff {
+		policy.MaxBackoff = policy.InitialBackoff
+	}
+	s.services = append(s.services, &supervisedService{name: name, srv: srv, policy: policy})
+}
+
+// Init inits the services in the order they were added and stops at the first failing one.
+func (s *Supervisor) Init() error {
+	for _, ss := range s.services {
+		if err := ss.srv.Init(); err != nil {
+			return fmt.Errorf("unable to init %s: %w", ss.name, err)
+		}
+	}
+	return nil
+}
+
+// Run runs every service in its own goroutine and blocks until all of them stopped or until a critical service
+// is given up, in which case an error wrapping ErrServiceFailed is returned while the other services keep running.
+func (s *Supervisor) Run() error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	failed := make(chan error, len(s.services))
+	s.wg.Add(len(s.services))
+	for _, ss := range s.services {
+		go func(ss *supervisedService) {
+			defer s.wg.Done()
+			if err := s.supervise(ss); err != nil {
+				failed <- err
+			}
+		}(ss)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case err := <-failed:
+		return err
+	case <-done:
+		select {
+		case err := <-failed:
+			return err
+		default:
+			return nil
+		}
+	}
+}
+
+// Shutdown shuts down the running services in the reverse order they were added and waits for them to stop.
+// Returns the errors of all services which failed to shut down or didn't stop in time.
+func (s *Supervisor) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopped = true
+	close(s.stopping)
+	s.mu.Unlock()
+
+	var errs []error
+	for i := len(s.services) - 1; i >= 0; i-- {
+		ss := s.services[i]
+
+		ss.mu.Lock()
+		ss.stopped = true
+		running := ss.running
+		ss.mu.Unlock()
+
+		// services waiting for a restart have nothing to shut down
+		if !running {
+			continue
+		}
+		if err := ss.srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("unable to shut down %s: %w", ss.name, err))
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		var running []string
+		for _, ss := range s.services {
+			ss.mu.Lock()
+			if ss.running {
+				running = append(running, ss.name)
+			}
+			ss.mu.Unlock()
+		}
+		errs = append(errs, fmt.Errorf("%s didn't stop in time: %w", strings.Join(running, ", "), ctx.Err()))
+	}
+
+	return errors.Join(errs...)
+}
+
+// runs the given service until it stops without an error, the Supervisor shuts down or the service is given up.
+// Returns an error if a critical service is given up.
+func (s *Supervisor) supervise(ss *supervisedService) error {
+	backoff := ss.policy.InitialBackoff
+	restarts := 0
+	for {
+		if !ss.start() {
+			return nil
+		}
+		started := time.Now()
+		err := runService(ss.srv)
+		ss.stop()
+
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-s.stopping:
+			log.Printf("%s stopped with an error during shutdown: %v", ss.name, err)
+			return nil
+		default:
+		}
+
+		if time.Since(started) > ss.policy.MaxBackoff {
+			backoff = ss.policy.InitialBackoff
+			restarts = 0
+		}
+
+		if ss.policy.MaxRestarts >= 0 && restarts >= ss.policy.MaxRestarts {
+			log.Printf("giving up %s after %d restarts: %v", ss.name, restarts, err)
+			if ss.policy.Critical {
+				return fmt.Errorf("%w: %s: %v", ErrServiceFailed, ss.name, err)
+			}
+			return nil
+		}
+
+		restarts++
+		log.Printf("%s failed, restarting in %v (restart %d): %v", ss.name, backoff, restarts, err)
+		select {
+		case <-s.stopping:
+			return nil
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > ss.policy.MaxBackoff {
+			backoff = ss.policy.MaxBackoff
+		}
+	}
+}
+
+// marks the service as running, returns false if it was already shut down.
+func (ss *supervisedService) start() bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.stopped {
+		return false
+	}
+	ss.running = true
+	return true
+}
+
+// marks the service as not running.
+func (ss *supervisedService) stop() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.running = false
+}
+
+// runs the given service and converts a panic into an error.
+func runService(srv Service) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return srv.Run()
+}
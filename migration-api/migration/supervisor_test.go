@@ -0,0 +1,143 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// a service whose Run fails the configured amount of times before it blocks until shutdown.
+type testService struct {
+	name        string
+	initOrder   *[]string
+	initErr     error
+	failures    int32
+	runs        atomic.Int32
+	shutdownErr error
+	shutdown    chan struct{}
+	once        sync.Once
+}
+
+func newTestService(name string, initOrder *[]string, failures int32) *testService {
+	return &testService{name: name, initOrder: initOrder, failures: failures, shutdown: make(chan struct{})}
+}
+
+func (ts *testService) Init() error {
+	*ts.initOrder = append(*ts.initOrder, ts.name)
+	return ts.initErr
+}
+
+func (ts *testService) Run() error {
+	if ts.runs.Add(1) <= ts.failures {
+		if ts.runs.Load()%2 == 0 {
+			panic("failed")
+		}
+		return errors.New("failed")
+	}
+	<-ts.shutdown
+	return nil
+}
+
+func (ts *testService) Shutdown(ctx context.Context) error {
+	ts.once.Do(func() { close(ts.shutdown) })
+	return ts.shutdownErr
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	var initOrder []string
+	flaky := newTestService("flaky", &initOrder, 3)
+	stable := newTestService("stable", &initOrder, 0)
+	stable.shutdownErr = errors.New("shutdown failed")
+
+	supervisor := NewSupervisor()
+	supervisor.Add("flaky", flaky, RestartPolicy{MaxRestarts: -1, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+	supervisor.Add("stable", stable, RestartPolicy{Critical: true})
+	require.NoError(t, supervisor.Init())
+	assert.Equal(t, []string{"flaky", "stable"}, initOrder)
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- supervisor.Run() }()
+
+	assert.Eventually(t, func() bool { return flaky.runs.Load() == 4 }, 2*time.Second, time.Millisecond)
+
+	err := supervisor.Shutdown(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, "unable to shut down stable: shutdown failed", err.Error())
+	require.NoError(t, <-runErr)
+	assert.EqualValues(t, 1, stable.runs.Load())
+}
+
+func TestSupervisorCriticalServiceFailed(t *testing.T) {
+	var initOrder []string
+	critical := newTestService("critical", &initOrder, 3)
+	background := newTestService("background", &initOrder, 0)
+
+	supervisor := NewSupervisor()
+	supervisor.Add("background", background, RestartPolicy{MaxRestarts: -1})
+	supervisor.Add("critical", critical, RestartPolicy{MaxRestarts: 1, InitialBackoff: time.Millisecond, Critical: true})
+	require.NoError(t, supervisor.Init())
+
+	err := supervisor.Run()
+	require.ErrorIs(t, err, ErrServiceFailed)
+	assert.Equal(t, "service failed: critical: panic: failed", err.Error())
+	assert.EqualValues(t, 2, critical.runs.Load())
+
+	// the other services keep running until shut down
+	select {
+	case <-background.shutdown:
+		t.Fatal("background service was shut down")
+	default:
+	}
+	require.NoError(t, supervisor.Shutdown(context.Background()))
+}
+
+func TestSupervisorInitError(t *testing.T) {
+	var initOrder []string
+	first := newTestService("first", &initOrder, 0)
+	failing := newTestService("failing", &initOrder, 0)
+	failing.initErr = errors.New("init failed")
+	last := newTestService("last", &initOrder, 0)
+
+	supervisor := NewSupervisor()
+	supervisor.Add("first", first, RestartPolicy{})
+	supervisor.Add("failing", failing, RestartPolicy{})
+	supervisor.Add("last", last, RestartPolicy{})
+
+	err := supervisor.Init()
+	require.Error(t, err)
+	assert.Equal(t, "unable to init failing: init failed", err.Error())
+	assert.Equal(t, []string{"first", "failing"}, initOrder)
+}
+
+func TestSupervisorShutdownTimeout(t *testing.T) {
+	var initOrder []string
+	stuck := newTestService("stuck", &initOrder, 0)
+
+	supervisor := NewSupervisor()
+	supervisor.Add("stuck", &stuckService{stuck}, RestartPolicy{})
+	require.NoError(t, supervisor.Init())
+	go func() { _ = supervisor.Run() }()
+	assert.Eventually(t, func() bool { return stuck.runs.Load() == 1 }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := supervisor.Shutdown(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "stuck didn't stop in time")
+	stuck.once.Do(func() { close(stuck.shutdown) })
+}
+
+// a service ignoring shutdowns.
+type stuckService struct {
+	*testService
+}
+
+func (ss *stuckService) Shutdown(ctx context.Context) error {
+	return nil
+}
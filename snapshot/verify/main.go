@@ -3,6 +3,7 @@
 package main
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"flag"
@@ -73,31 +74,34 @@ func main() {
 	log.Printf("legacy node state: lsmi/lsm %d/%d", nodeInfo.LatestSolidSubtangleMilestoneIndex, nodeInfo.LatestMilestoneIndex)
 	log.Printf("fetching ledger state at %d, this might take a while...go grab a coffee...", nodeInfo.LatestSolidSubtangleMilestoneIndex)
 
-	resObj, err := common.QueryLedgerState(*legacyNodeURI, int(nodeInfo.LatestSolidSubtangleMilestoneIndex))
+	legacyClient, err := common.NewLegacyClient(*legacyNodeURI)
 	must(err)
 
-	log.Printf("total ledger entries: %d", len(resObj.Balances))
-	var migrationsWithLeftOutAddr []migration
-	var migrationsWithoutLeftOutAddr []migration
-	var totalMigrationWithLeftOutAddr, totalMigrationWithoutLeftOutAddr uint64
-	var eligibleAddrsForMigration, eligibleAddrsTokensTotal uint64
-
-	globalSnapshotFile, err := os.OpenFile(*globalSnapshotFileName, os.O_TRUNC|os.O_CREATE|os.O_RDWR, os.ModePerm)
-	must(err)
-	defer globalSnapshotFile.Close()
-
 	type legacyLedgerEntry struct {
 		addr    trinary.Hash
 		balance uint64
 	}
 
+	// stream the ledger state into a slice as the entries need to be sorted anyway
 	var legacyLedgerEntries []legacyLedgerEntry
-	for addr, balance := range resObj.Balances {
+	_, err = legacyClient.StreamLedgerState(context.Background(), int(nodeInfo.LatestSolidSubtangleMilestoneIndex), func(addr trinary.Hash, balance uint64) error {
 		legacyLedgerEntries = append(legacyLedgerEntries, legacyLedgerEntry{
 			addr:    addr,
 			balance: balance,
 		})
-	}
+		return nil
+	})
+	must(err)
+
+	log.Printf("total ledger entries: %d", len(legacyLedgerEntries))
+	var migrationsWithLeftOutAddr []migration
+	var migrationsWithoutLeftOutAddr []migration
+	var totalMigrationWithLeftOutAddr, totalMigrationWithoutLeftOutAddr uint64
+	var eligibleAddrsForMigration, eligibleAddrsTokensTotal uint64
+
+	globalSnapshotFile, err := os.OpenFile(*globalSnapshotFileName, os.O_TRUNC|os.O_CREATE|os.O_RDWR, os.ModePerm)
+	must(err)
+	defer globalSnapshotFile.Close()
 
 	sort.Slice(legacyLedgerEntries, func(i, j int) bool {
 		return legacyLedgerEntries[i].addr < legacyLedgerEntries[j].addr
@@ -7,18 +7,21 @@ import (
 	"syscall"
 
 	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/http"
-
-	_ "github.com/iotaledger/chrysalis-tools/wfmock/pkg/http/whiteflag"
+	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/http/whiteflag"
 )
 
 func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 
+	whiteflag.Initialize()
 	http.Initialize()
 	http.Start()
 	defer http.Shutdown()
 
+	whiteflag.StartCoordinator()
+	defer whiteflag.StopCoordinator()
+
 	// wait for termination
 	<-quit
 	log.Println("exiting")
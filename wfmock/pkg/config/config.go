@@ -49,6 +49,7 @@ type Config struct {
 	HTTP        HTTPConfig        `json:"http"`
 	Coordinator CoordinatorConfig `json:"coordinator"`
 	WhiteFlag   WhiteFlagConfig   `json:"white_flag"`
+	Faults      []FaultProfile    `json:"faults"`
 }
 
 // HTTPConfig holds the HTTP server configuration.
@@ -62,14 +63,29 @@ type CoordinatorConfig struct {
 	Security  consts.SecurityLevel `json:"security"`   // used security level
 	TreeDepth int                  `json:"tree_depth"` // the depth of the Merkle tree
 	MWM       int                  `json:"mwm"`        // PoW MWM used by the coordinator
+	// interval in seconds in which new milestones are issued after the ones of the configured migrations
+	// 0 disables the issuance of new milestones
+	MilestoneInterval uint `json:"milestone_interval"`
 }
 
 // WhiteFlagConfig holds information about the white flag confirmations to be mocked.
 type WhiteFlagConfig struct {
 	Seed       trinary.Trytes         `json:"seed"`       // seed which is used to generate all migration signatures
+	Genesis    []GenesisBalance       `json:"genesis"`    // balances of the mocked ledger before the first milestone
 	Migrations map[uint32][]Migration `json:"migrations"` // migration bundles per milestone index
 }
 
+// GenesisBalance holds the balance of an address derived from the white flag seed before the first milestone.
+// If no genesis balances are configured, the inputs of all configured migrations are funded.
+type GenesisBalance struct {
+	// key index of the address
+	Index uint64 `json:"index"`
+	// security level of the address
+	Security consts.SecurityLevel `json:"security"`
+	// balance of the address
+	Balance uint64 `json:"balance"`
+}
+
 // Migration holds information about a single migration bundle.
 type Migration struct {
 	// input balance
@@ -85,3 +101,28 @@ type Migration struct {
 	// random address can be generated using `openssl rand -hex 32`
 	Ed25519Address hexutil.Bytes `json:"ed25519_address"`
 }
+
+// FaultProfile defines the faults injected into the responses to a command.
+type FaultProfile struct {
+	// command the faults are injected into
+	// all commands if empty
+	Command string `json:"command"`
+	// index of the milestone the faults are injected into (for commands querying a milestone)
+	// all milestones if 0
+	MilestoneIndex uint32 `json:"milestone_index"`
+	// latency in milliseconds added to the response
+	Latency uint `json:"latency"`
+	// HTTP status code answered with instead of the actual response, e.g. 503
+	StatusCode int `json:"status_code"`
+	// whether the JSON response is cut off in the middle
+	TruncateJSON bool `json:"truncate_json"`
+	// whether the included bundles of getWhiteFlagConfirmation are returned in the wrong order
+	// only applied to milestones including at least two bundles
+	WrongBundleOrder bool `json:"wrong_bundle_order"`
+	// whether the milestone bundle of getWhiteFlagConfirmation has an invalid signature
+	InvalidSignature bool `json:"invalid_signature"`
+	// whether the milestone bundle of getWhiteFlagConfirmation contains a wrong white flag Merkle tree hash
+	WrongMerkleRoot bool `json:"wrong_merkle_root"`
+	// whether getWhiteFlagConfirmation and getLedgerDiffExt answer as if the milestone was missing
+	MissingMilestone bool `json:"missing_milestone"`
+}
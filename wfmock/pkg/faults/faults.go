@@ -0,0 +1,76 @@
+// Package faults holds the fault profiles which are injected into the responses of the mock.
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/config"
+)
+
+// ErrInvalidProfile is returned when a fault profile can't be applied.
+var ErrInvalidProfile = errors.New("invalid fault profile")
+
+var (
+	mu       sync.RWMutex
+	profiles = make([]config.FaultProfile, 0)
+)
+
+// Set replaces the active fault profiles.
+func Set(newProfiles []config.FaultProfile) error {
+	for i, profile := range newProfiles {
+		if profile.StatusCode != 0 && (profile.StatusCode < http.StatusBadRequest || profile.StatusCode > 599) {
+			return fmt.Errorf("%w: profile %d has the non error status code %d", ErrInvalidProfile, i, profile.StatusCode)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	profiles = append(make([]config.FaultProfile, 0, len(newProfiles)), newProfiles...)
+	return nil
+}
+
+// Get returns the active fault profiles.
+func Get() []config.FaultProfile {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append(make([]config.FaultProfile, 0, len(profiles)), profiles...)
+}
+
+// Match merges the active fault profiles applying to the given command and milestone index.
+// Milestone index 0 stands for a command not querying a particular milestone, which only matches
+// profiles not restricted to a milestone.
+func Match(command string, msIndex uint32) config.FaultProfile {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	merged := config.FaultProfile{Command: command, MilestoneIndex: msIndex}
+	for _, profile := range profiles {
+		if len(profile.Command) > 0 && !strings.EqualFold(profile.Command, command) {
+			continue
+		}
+		if profile.MilestoneIndex != 0 && profile.MilestoneIndex != msIndex {
+			continue
+		}
+
+		merged.Latency += profile.Latency
+		if merged.StatusCode == 0 {
+			merged.StatusCode = profile.StatusCode
+		}
+		merged.TruncateJSON = merged.TruncateJSON || profile.TruncateJSON
+		merged.WrongBundleOrder = merged.WrongBundleOrder || profile.WrongBundleOrder
+		merged.InvalidSignature = merged.InvalidSignature || profile.InvalidSignature
+		merged.WrongMerkleRoot = merged.WrongMerkleRoot || profile.WrongMerkleRoot
+		merged.MissingMilestone = merged.MissingMilestone || profile.MissingMilestone
+	}
+	return merged
+}
+
+// Delay returns the latency to add to a response of the given profile.
+func Delay(profile config.FaultProfile) time.Duration {
+	return time.Duration(profile.Latency) * time.Millisecond
+}
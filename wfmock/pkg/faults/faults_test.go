@@ -0,0 +1,47 @@
+package faults
+
+import (
+	"testing"
+
+	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	require.NoError(t, Set([]config.FaultProfile{
+		{Latency: 100},
+		{Command: "getWhiteFlagConfirmation", MilestoneIndex: 5, InvalidSignature: true, StatusCode: 503},
+		{Command: "getWhiteFlagConfirmation", WrongBundleOrder: true, StatusCode: 500},
+		{Command: "getLedgerDiffExt", MissingMilestone: true, Latency: 50},
+	}))
+	defer func() { require.NoError(t, Set(nil)) }()
+
+	var tests = []*struct {
+		command string
+		msIndex uint32
+		exp     config.FaultProfile
+	}{
+		{command: "getNodeInfo", exp: config.FaultProfile{Latency: 100}},
+		{command: "getwhiteflagconfirmation", msIndex: 4, exp: config.FaultProfile{Latency: 100, WrongBundleOrder: true, StatusCode: 500}},
+		{command: "getWhiteFlagConfirmation", msIndex: 5, exp: config.FaultProfile{Latency: 100, InvalidSignature: true, WrongBundleOrder: true, StatusCode: 503}},
+		{command: "getLedgerDiffExt", msIndex: 5, exp: config.FaultProfile{Latency: 150, MissingMilestone: true}},
+	}
+
+	for _, tt := range tests {
+		tt.exp.Command = tt.command
+		tt.exp.MilestoneIndex = tt.msIndex
+		assert.Equal(t, tt.exp, Match(tt.command, tt.msIndex), "%s at %d", tt.command, tt.msIndex)
+	}
+}
+
+func TestSetInvalidStatusCode(t *testing.T) {
+	require.NoError(t, Set([]config.FaultProfile{{TruncateJSON: true}}))
+	defer func() { require.NoError(t, Set(nil)) }()
+
+	err := Set([]config.FaultProfile{{StatusCode: 200}})
+	require.ErrorIs(t, err, ErrInvalidProfile)
+
+	// the active profiles are kept
+	assert.Equal(t, []config.FaultProfile{{TruncateJSON: true}}, Get())
+}
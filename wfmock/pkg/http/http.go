@@ -1,6 +1,7 @@
 package http
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/config"
+	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/faults"
 	"github.com/labstack/echo/v4"
 )
 
@@ -22,25 +24,52 @@ var (
 	server   *echo.Echo
 	wg       sync.WaitGroup
 	handlers = make(map[string]HandlerFunc)
+	routes   []route
 )
 
 // HandlerFunc defines a function to serve the provided request.
 type HandlerFunc func(interface{}, echo.Context) error
 
+// route defines an additional route of the HTTP server.
+type route struct {
+	method  string
+	path    string
+	handler echo.HandlerFunc
+}
+
 // ErrorReturn defines the type of an API error.
 type ErrorReturn struct {
 	Error string `json:"error"`
 }
 
+// truncatingWriter buffers a response so that only its first half gets written.
+type truncatingWriter struct {
+	http.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *truncatingWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
 // RegisterHandler registers a new API command.
 func RegisterHandler(command string, handlerFunc HandlerFunc) {
 	handlers[command] = handlerFunc
 }
 
+// RegisterRoute registers a new route besides the API commands, e.g. to administrate the mock.
+func RegisterRoute(method string, path string, handler echo.HandlerFunc) {
+	routes = append(routes, route{method: method, path: path, handler: handler})
+}
+
 // Initialize initializes the HTTP server. Must be called before Start.
 func Initialize() {
 	cfg := config.GetConfig()
 
+	if err := faults.Set(cfg.Faults); err != nil {
+		log.Fatalf("failed to load the fault profiles: %s", err)
+	}
+
 	server = echo.New()
 	server.HideBanner = true // do not show the welcome banner
 	server.HidePort = true   // print our own log message
@@ -50,6 +79,9 @@ func Initialize() {
 
 	// actual routes
 	server.GET("/healthcheck", ok200)
+	for _, r := range routes {
+		server.Add(r.method, r.path, r.handler)
+	}
 	server.POST(webAPIBase, func(c echo.Context) error {
 		request := make(map[string]interface{})
 		if err := c.Bind(&request); err != nil {
@@ -65,10 +97,49 @@ func Initialize() {
 		if !ok {
 			return c.JSON(http.StatusBadRequest, ErrorReturn{Error: fmt.Sprintf("command '%s' is unknown", cmd)})
 		}
-		return handler(request, c)
+		return serveWithFaults(handler, strings.ToLower(cmd), request, c)
 	})
 }
 
+// serveWithFaults serves the request while injecting the faults of the matching fault profiles.
+func serveWithFaults(handler HandlerFunc, cmd string, request map[string]interface{}, c echo.Context) error {
+	profile := faults.Match(cmd, requestedMilestoneIndex(request))
+
+	if delay := faults.Delay(profile); delay > 0 {
+		time.Sleep(delay)
+	}
+	if profile.StatusCode != 0 {
+		return c.JSON(profile.StatusCode, ErrorReturn{Error: fmt.Sprintf("injected fault: status code %d", profile.StatusCode)})
+	}
+	if !profile.TruncateJSON {
+		return handler(request, c)
+	}
+
+	res := c.Response()
+	writer := res.Writer
+	truncating := &truncatingWriter{ResponseWriter: writer}
+	res.Writer = truncating
+	err := handler(request, c)
+	res.Writer = writer
+	if err != nil {
+		return err
+	}
+
+	body := truncating.buf.Bytes()
+	_, err = writer.Write(body[:len(body)/2])
+	return err
+}
+
+// requestedMilestoneIndex returns the milestone index queried by the given request, or 0 if it doesn't query one.
+func requestedMilestoneIndex(request map[string]interface{}) uint32 {
+	for _, key := range []string{"milestoneIndex", "targetIndex"} {
+		if index, ok := request[key].(float64); ok && index > 0 {
+			return uint32(index)
+		}
+	}
+	return 0
+}
+
 // Start starts the HTTP server in a separate go routine.
 func Start() {
 	wg.Add(1)
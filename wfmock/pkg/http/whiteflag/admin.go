@@ -0,0 +1,110 @@
+package whiteflag
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/config"
+	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/faults"
+	httpapi "github.com/iotaledger/chrysalis-tools/wfmock/pkg/http"
+	"github.com/iotaledger/iota.go/bundle"
+	"github.com/iotaledger/iota.go/transaction"
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/labstack/echo/v4"
+)
+
+// AdminMigrationsRoute defines the route to schedule a migration bundle into the next milestone.
+const AdminMigrationsRoute = "/admin/migrations"
+
+// AdminFaultsRoute defines the route to query, replace and clear the active fault profiles.
+const AdminFaultsRoute = "/admin/faults"
+
+// minMigrationBalance is the minimum balance a migration bundle must migrate.
+const minMigrationBalance = 1_000_000
+
+// ScheduleMigrationResponse defines the response of a scheduled migration.
+type ScheduleMigrationResponse struct {
+	MilestoneIndex      uint32       `json:"milestoneIndex"`
+	TailTransactionHash trinary.Hash `json:"tailTransactionHash"`
+}
+
+func scheduleMigration(c echo.Context) error {
+	migration := config.Migration{}
+	if err := c.Bind(&migration); err != nil {
+		return c.JSON(http.StatusBadRequest, httpapi.ErrorReturn{Error: fmt.Sprintf("invalid request: %s", err)})
+	}
+	if migration.Balance < minMigrationBalance {
+		return c.JSON(http.StatusBadRequest, httpapi.ErrorReturn{Error: fmt.Sprintf("balance must be at least %d", minMigrationBalance)})
+	}
+	if migration.Security < 1 || migration.Security > 3 {
+		return c.JSON(http.StatusBadRequest, httpapi.ErrorReturn{Error: fmt.Sprintf("invalid security level %d", migration.Security)})
+	}
+
+	bndl, err := createMigrationBundle(data.seed, migration, data.cfg.MWM)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, httpapi.ErrorReturn{Error: err.Error()})
+	}
+	txs, err := transaction.AsTransactionObjects(bndl, nil)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, httpapi.ErrorReturn{Error: err.Error()})
+	}
+
+	msIndex, err := data.includeBundle(bndl)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, httpapi.ErrorReturn{Error: err.Error()})
+	}
+
+	res := ScheduleMigrationResponse{
+		MilestoneIndex:      msIndex,
+		TailTransactionHash: bundle.TailTransactionHash(txs),
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func getFaults(c echo.Context) error {
+	return c.JSON(http.StatusOK, faults.Get())
+}
+
+func setFaults(c echo.Context) error {
+	profiles := make([]config.FaultProfile, 0)
+	if err := c.Bind(&profiles); err != nil {
+		return c.JSON(http.StatusBadRequest, httpapi.ErrorReturn{Error: fmt.Sprintf("invalid request: %s", err)})
+	}
+	if err := data.validateFaults(profiles); err != nil {
+		return c.JSON(http.StatusBadRequest, httpapi.ErrorReturn{Error: err.Error()})
+	}
+	if err := faults.Set(profiles); err != nil {
+		return c.JSON(http.StatusBadRequest, httpapi.ErrorReturn{Error: err.Error()})
+	}
+	return c.JSON(http.StatusOK, faults.Get())
+}
+
+// validateFaults checks that the given profiles can be applied to the issued milestones they target.
+// The order of the included bundles can only be wrong for a milestone including at least two bundles,
+// profiles for all or not yet issued milestones only apply to the ones which do.
+func (d *whiteFlagData) validateFaults(profiles []config.FaultProfile) error {
+	d.RLock()
+	defer d.RUnlock()
+
+	for i, profile := range profiles {
+		if !profile.WrongBundleOrder || profile.MilestoneIndex == 0 || profile.MilestoneIndex >= uint32(len(d.milestones)) {
+			continue
+		}
+		if len(profile.Command) > 0 && !strings.EqualFold(profile.Command, GetWhiteFlagConfirmationCommand) {
+			continue
+		}
+		if included := len(d.milestones[profile.MilestoneIndex].includedMigrationBundles); included < 2 {
+			return fmt.Errorf("%w: profile %d can't reorder the bundles of milestone %d, which includes %d bundles",
+				faults.ErrInvalidProfile, i, profile.MilestoneIndex, included)
+		}
+	}
+	return nil
+}
+
+func clearFaults(c echo.Context) error {
+	if err := faults.Set(nil); err != nil {
+		return c.JSON(http.StatusInternalServerError, httpapi.ErrorReturn{Error: err.Error()})
+	}
+	return c.JSON(http.StatusOK, faults.Get())
+}
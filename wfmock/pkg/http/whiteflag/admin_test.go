@@ -0,0 +1,198 @@
+package whiteflag
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/config"
+	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/faults"
+	"github.com/iotaledger/iota.go/merkle"
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serves the given JSON body by the given admin route handler.
+func serveAdminRoute(t *testing.T, handler echo.HandlerFunc, method string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, AdminMigrationsRoute, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	require.NoError(t, handler(echo.New().NewContext(req, rec)))
+	return rec
+}
+
+func TestScheduleMigration(t *testing.T) {
+	d := newTestData(t, newTestConfig())
+	const ed25519Address = `"2c2bb061de51f09ce2ccee44a626762bbb766997e1c8098eaec2e3a089c65843"`
+
+	var tests = []struct {
+		name      string
+		body      string
+		expStatus int
+		expIndex  uint32
+	}{
+		{name: "valid", body: `{"balance": 3000000, "index": 2, "security": 1, "ed25519_address": ` + ed25519Address + `}`, expStatus: http.StatusOK, expIndex: 3},
+		{name: "already migrated", body: `{"balance": 1000000, "index": 0, "security": 1, "ed25519_address": ` + ed25519Address + `}`, expStatus: http.StatusBadRequest},
+		{name: "partial balance", body: `{"balance": 1000000, "index": 3, "security": 1, "ed25519_address": ` + ed25519Address + `}`, expStatus: http.StatusBadRequest},
+		{name: "below min balance", body: `{"balance": 999999, "index": 3, "security": 1, "ed25519_address": ` + ed25519Address + `}`, expStatus: http.StatusBadRequest},
+		{name: "invalid security", body: `{"balance": 4000000, "index": 3, "security": 4, "ed25519_address": ` + ed25519Address + `}`, expStatus: http.StatusBadRequest},
+		{name: "invalid address", body: `{"balance": 4000000, "index": 3, "security": 1, "ed25519_address": "2c2b"}`, expStatus: http.StatusBadRequest},
+		{name: "invalid JSON", body: `{"balance": `, expStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			latestIndex := d.latestIndex()
+			rec := serveAdminRoute(t, scheduleMigration, http.MethodPost, tt.body)
+			require.Equal(t, tt.expStatus, rec.Code, rec.Body.String())
+			if tt.expStatus != http.StatusOK {
+				assert.Equal(t, latestIndex, d.latestIndex())
+				return
+			}
+
+			res := &ScheduleMigrationResponse{}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), res))
+			assert.Equal(t, tt.expIndex, res.MilestoneIndex)
+			// no coordinator is running, so the milestone is issued right away
+			assert.Equal(t, tt.expIndex, d.latestIndex())
+			require.Len(t, d.milestones[tt.expIndex].includedMigrationBundles, 1)
+			assert.Equal(t, res.TailTransactionHash, mustTail(d.milestones[tt.expIndex].includedMigrationBundles[0]).Hash)
+		})
+	}
+}
+
+func TestIncludeBundleConcurrently(t *testing.T) {
+	d := newTestData(t, newTestConfig())
+
+	var bundles [][]trinary.Trytes
+	for _, migration := range []config.Migration{
+		{Balance: 3_000_000, Index: 2, Security: 1, Ed25519Address: testEd25519Address},
+		{Balance: 4_000_000, Index: 3, Security: 1, Ed25519Address: testEd25519Address},
+	} {
+		bndl, err := createMigrationBundle(testWhiteFlagSeed, migration, 1)
+		require.NoError(t, err)
+		bundles = append(bundles, bndl)
+	}
+
+	var wg sync.WaitGroup
+	msIndexes := make([]uint32, len(bundles))
+	for i := range bundles {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			msIndexes[i], err = d.includeBundle(bundles[i])
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	// every bundle got its own milestone without any empty one in between
+	assert.ElementsMatch(t, []uint32{3, 4}, msIndexes)
+	assert.EqualValues(t, 4, d.latestIndex())
+	for i, msIndex := range msIndexes {
+		assert.Equal(t, [][]trinary.Trytes{bundles[i]}, d.milestones[msIndex].includedMigrationBundles)
+	}
+}
+
+func TestIncludeBundleKeysExhausted(t *testing.T) {
+	d := newTestData(t, newTestConfig())
+
+	// use up the keys of the coordinator
+	for d.latestIndex() < 7 {
+		require.NoError(t, d.issueMilestone())
+	}
+	projectedBalances := make(map[trinary.Hash]uint64)
+	for addr, balance := range d.projectedBalances {
+		projectedBalances[addr] = balance
+	}
+
+	bndl, err := createMigrationBundle(testWhiteFlagSeed, config.Migration{Balance: 3_000_000, Index: 2, Security: 1, Ed25519Address: testEd25519Address}, 1)
+	require.NoError(t, err)
+	_, err = d.includeBundle(bndl)
+	require.ErrorIs(t, err, merkle.ErrInvalidLeafIndex)
+
+	// the bundle isn't scheduled into a milestone which can't be issued
+	assert.Equal(t, projectedBalances, d.projectedBalances)
+	assert.NotContains(t, d.scheduledTails, mustTail(bndl).Hash)
+	assert.Empty(t, d.pendingBundles)
+	assert.EqualValues(t, 7, d.latestIndex())
+}
+
+func TestScheduleBundleUndo(t *testing.T) {
+	d := newTestData(t, newTestConfig())
+	projectedBalances := make(map[trinary.Hash]uint64)
+	for addr, balance := range d.projectedBalances {
+		projectedBalances[addr] = balance
+	}
+
+	bndl, err := createMigrationBundle(testWhiteFlagSeed, config.Migration{Balance: 3_000_000, Index: 2, Security: 1, Ed25519Address: testEd25519Address}, 1)
+	require.NoError(t, err)
+
+	d.issueMu.Lock()
+	defer d.issueMu.Unlock()
+	msIndex, undo, err := d.scheduleBundle(bndl)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, msIndex)
+	assert.Contains(t, d.scheduledTails, mustTail(bndl).Hash)
+	assert.Len(t, d.pendingBundles[msIndex], 1)
+
+	undo()
+	assert.Equal(t, projectedBalances, d.projectedBalances)
+	assert.NotContains(t, d.scheduledTails, mustTail(bndl).Hash)
+	assert.Empty(t, d.pendingBundles)
+}
+
+func TestSetFaultsWrongBundleOrder(t *testing.T) {
+	newTestData(t, newTestConfig())
+	t.Cleanup(func() { require.NoError(t, faults.Set(nil)) })
+
+	var tests = []struct {
+		name      string
+		body      string
+		expStatus int
+	}{
+		{name: "milestone with two bundles", body: `[{"milestone_index": 2, "wrong_bundle_order": true}]`, expStatus: http.StatusOK},
+		{name: "all milestones", body: `[{"wrong_bundle_order": true}]`, expStatus: http.StatusOK},
+		{name: "future milestone", body: `[{"milestone_index": 10, "wrong_bundle_order": true}]`, expStatus: http.StatusOK},
+		{name: "other command", body: `[{"command": "getLedgerDiffExt", "milestone_index": 1, "wrong_bundle_order": true}]`, expStatus: http.StatusOK},
+		{name: "milestone without bundles", body: `[{"milestone_index": 1, "wrong_bundle_order": true}]`, expStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, faults.Set(nil))
+			rec := serveAdminRoute(t, setFaults, http.MethodPut, tt.body)
+			require.Equal(t, tt.expStatus, rec.Code, rec.Body.String())
+			if tt.expStatus != http.StatusOK {
+				assert.Empty(t, faults.Get())
+			}
+		})
+	}
+}
+
+func TestGetWhiteFlagConfirmationWrongBundleOrder(t *testing.T) {
+	d := newTestData(t, newTestConfig())
+	require.NoError(t, faults.Set([]config.FaultProfile{{WrongBundleOrder: true}}))
+	t.Cleanup(func() { require.NoError(t, faults.Set(nil)) })
+
+	query := func(msIndex uint32) *GetWhiteFlagConfirmationResponse {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec)
+		require.NoError(t, getWhiteFlagConfirmation(map[string]interface{}{"command": GetWhiteFlagConfirmationCommand, "milestoneIndex": msIndex}, c))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		res := &GetWhiteFlagConfirmationResponse{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), res))
+		return res
+	}
+
+	included := d.milestones[2].includedMigrationBundles
+	assert.Equal(t, [][]trinary.Trytes{included[1], included[0]}, query(2).IncludedBundles)
+	// the fault is skipped for a milestone without two bundles to swap
+	assert.Empty(t, query(1).IncludedBundles)
+}
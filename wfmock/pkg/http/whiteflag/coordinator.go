@@ -0,0 +1,172 @@
+package whiteflag
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/iotaledger/iota.go/merkle"
+	"github.com/iotaledger/iota.go/transaction"
+	"github.com/iotaledger/iota.go/trinary"
+)
+
+var (
+	coordinatorShutdown = make(chan struct{})
+	coordinatorWg       sync.WaitGroup
+)
+
+// StartCoordinator starts issuing a new milestone every configured interval in a separate go routine.
+// Does nothing if no milestone interval is configured.
+func StartCoordinator() {
+	interval := time.Duration(data.cfg.MilestoneInterval) * time.Second
+	if interval == 0 {
+		log.Println("milestone issuance disabled")
+		return
+	}
+
+	coordinatorWg.Add(1)
+	go func() {
+		defer coordinatorWg.Done()
+
+		// the milestone index is the index of the key in the coordinator's Merkle tree
+		keysLeft := uint64(1)<<data.cfg.TreeDepth - 1 - uint64(data.latestIndex())
+		log.Printf("coordinator started issuing milestones every %v, its keys last for %d more milestones (%v)\n",
+			interval, keysLeft, time.Duration(keysLeft)*interval)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-coordinatorShutdown:
+				log.Println("coordinator stopped")
+				return
+			case <-ticker.C:
+			}
+
+			if err := data.issueMilestone(); err != nil {
+				if errors.Is(err, merkle.ErrInvalidLeafIndex) {
+					log.Printf("coordinator stopped, no keys left in its Merkle tree: %s", err)
+					return
+				}
+				log.Printf("failed to issue milestone: %s", err)
+				continue
+			}
+			log.Printf("issued milestone %d\n", data.latestIndex())
+		}
+	}()
+}
+
+// StopCoordinator stops the issuance of milestones.
+func StopCoordinator() {
+	close(coordinatorShutdown)
+	coordinatorWg.Wait()
+}
+
+// issueMilestone issues the next milestone including the migration bundles scheduled for it.
+func (d *whiteFlagData) issueMilestone() error {
+	d.issueMu.Lock()
+	defer d.issueMu.Unlock()
+	return d.issueNextMilestone()
+}
+
+// issueNextMilestone issues the next milestone, must be called while holding issueMu.
+func (d *whiteFlagData) issueNextMilestone() error {
+	// the latest milestone is only modified while issueMu is held
+	index := d.latestMilestoneIndex + 1
+	includedBundles := d.pendingBundles[index]
+
+	ledgerDiff, err := createLedgerDiff(index, includedBundles)
+	if err != nil {
+		return fmt.Errorf("failed to create ledger diff of milestone %d: %w", index, err)
+	}
+
+	msHash, msBundle, err := createMilestone(d.cfg, d.merkleTree, index, includedBundles)
+	if err != nil {
+		return fmt.Errorf("failed to create milestone %d: %w", index, err)
+	}
+	delete(d.pendingBundles, index)
+
+	d.Lock()
+	defer d.Unlock()
+	d.milestones = append(d.milestones, whiteFlagMilestone{
+		milestoneBundle:          msBundle,
+		includedMigrationBundles: includedBundles,
+		ledgerDiff:               ledgerDiff,
+	})
+	d.tangle.confirm(index, msBundle, includedBundles, &ledgerDiff)
+	d.latestMilestoneHash = msHash
+	d.latestMilestoneIndex = index
+	return nil
+}
+
+// latestIndex returns the index of the latest issued milestone.
+func (d *whiteFlagData) latestIndex() uint32 {
+	d.RLock()
+	defer d.RUnlock()
+	return d.latestMilestoneIndex
+}
+
+// scheduleBundle schedules the given migration bundle into the next milestone and returns its index and a function
+// undoing the scheduling. Returns an error if the bundle is inconsistent with the ledger or if the coordinator
+// has no key left to sign the next milestone. Must be called while holding issueMu.
+func (d *whiteFlagData) scheduleBundle(bndl []trinary.Trytes) (uint32, func(), error) {
+	index := d.latestMilestoneIndex + 1
+	// the milestone index is the index of the key in the coordinator's Merkle tree
+	if keys := uint64(1) << d.cfg.TreeDepth; uint64(index) >= keys {
+		return 0, nil, fmt.Errorf("%w: milestone %d exceeds the %d keys of the coordinator's Merkle tree", merkle.ErrInvalidLeafIndex, index, keys)
+	}
+
+	tailHash := mustTail(bndl).Hash
+	if _, has := d.scheduledTails[tailHash]; has {
+		return 0, nil, fmt.Errorf("bundle with tail %s is already scheduled", tailHash)
+	}
+	undoProjection, err := d.projectBundle(bndl)
+	if err != nil {
+		return 0, nil, err
+	}
+	d.scheduledTails[tailHash] = struct{}{}
+
+	d.pendingBundles[index] = append(d.pendingBundles[index], bndl)
+
+	undo := func() {
+		undoProjection()
+		delete(d.scheduledTails, tailHash)
+		// the bundle was appended last and no milestone was issued since
+		if pending := d.pendingBundles[index][:len(d.pendingBundles[index])-1]; len(pending) > 0 {
+			d.pendingBundles[index] = pending
+		} else {
+			delete(d.pendingBundles, index)
+		}
+	}
+	return index, undo, nil
+}
+
+// includeBundle schedules the given bundle into the next milestone, which is issued right away if no coordinator
+// is running. Returns the index of the milestone including the bundle.
+func (d *whiteFlagData) includeBundle(bndl []trinary.Trytes) (uint32, error) {
+	d.issueMu.Lock()
+	defer d.issueMu.Unlock()
+
+	msIndex, undo, err := d.scheduleBundle(bndl)
+	if err != nil {
+		return 0, err
+	}
+	// issued within the same critical section, so that no other bundle gets scheduled in between
+	if d.cfg.MilestoneInterval == 0 {
+		if err := d.issueNextMilestone(); err != nil {
+			undo()
+			return 0, err
+		}
+	}
+	return msIndex, nil
+}
+
+// mustTail parses the tail transaction of the given bundle, which must have been parsed before.
+func mustTail(bndl []trinary.Trytes) *transaction.Transaction {
+	tail, err := transaction.AsTransactionObject(bndl[0])
+	if err != nil {
+		panic(err)
+	}
+	return tail
+}
@@ -31,6 +31,9 @@ type GetNodeInfoResponse struct {
 }
 
 func getNodeInfo(_ interface{}, c echo.Context) error {
+	data.RLock()
+	defer data.RUnlock()
+
 	result := GetNodeInfoResponse{
 		AppName:                            "White Flag Mock",
 		LatestMilestoneIndex:               data.latestMilestoneIndex,
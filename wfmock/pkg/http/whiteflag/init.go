@@ -4,7 +4,10 @@ import (
 	"crypto"
 	"fmt"
 	"log"
+	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/config"
@@ -31,41 +34,101 @@ var (
 )
 
 type whiteFlagData struct {
+	sync.RWMutex
+	// serializes the issuance of milestones, guards pendingBundles
+	issueMu sync.Mutex
+
+	cfg        config.CoordinatorConfig
+	merkleTree *merkle.MerkleTree
+	// seed from which the inputs of migration bundles are derived
+	seed trinary.Trytes
+
 	latestMilestoneHash  trinary.Hash
 	latestMilestoneIndex uint32
 	coordinatorAddress   trinary.Hash
 
 	milestones []whiteFlagMilestone
+	// migration bundles to be included by the milestone of the given index once it gets issued
+	pendingBundles map[uint32][][]trinary.Trytes
+
+	// the balances of the mocked ledger before the first milestone
+	genesis map[trinary.Hash]uint64
+	// the balances after all issued milestones and scheduled bundles, guarded by issueMu
+	projectedBalances map[trinary.Hash]uint64
+	// the tails of all scheduled bundles, guarded by issueMu
+	scheduledTails map[trinary.Hash]struct{}
+
+	tangle *tangle
 }
 
 type whiteFlagMilestone struct {
 	milestoneBundle          []trinary.Trytes
 	includedMigrationBundles [][]trinary.Trytes
+	ledgerDiff               GetLedgerDiffExtResponse
 }
 
 func init() {
+	// register the API commands
+	httpapi.RegisterHandler(strings.ToLower(GetNodeInfoCommand), getNodeInfo)
+	httpapi.RegisterHandler(strings.ToLower(GetWhiteFlagConfirmationCommand), getWhiteFlagConfirmation)
+	httpapi.RegisterHandler(strings.ToLower(GetLedgerStateCommand), getLedgerState)
+	httpapi.RegisterHandler(strings.ToLower(GetLedgerDiffExtCommand), getLedgerDiffExt)
+	httpapi.RegisterHandler(strings.ToLower(GetBalancesCommand), getBalances)
+	httpapi.RegisterHandler(strings.ToLower(WereAddressesSpentFromCommand), wereAddressesSpentFrom)
+	httpapi.RegisterHandler(strings.ToLower(FindTransactionsCommand), findTransactions)
+	httpapi.RegisterHandler(strings.ToLower(GetTrytesCommand), getTrytes)
+	httpapi.RegisterHandler(strings.ToLower(GetInclusionStatesCommand), getInclusionStates)
+	httpapi.RegisterHandler(strings.ToLower(GetTransactionsToApproveCommand), getTransactionsToApprove)
+	httpapi.RegisterHandler(strings.ToLower(AttachToTangleCommand), attachToTangle)
+	httpapi.RegisterHandler(strings.ToLower(StoreTransactionsCommand), storeTransactions)
+	httpapi.RegisterHandler(strings.ToLower(BroadcastTransactionsCommand), storeTransactions)
+
+	// register the admin routes
+	httpapi.RegisterRoute(http.MethodPost, AdminMigrationsRoute, scheduleMigration)
+	httpapi.RegisterRoute(http.MethodGet, AdminFaultsRoute, getFaults)
+	httpapi.RegisterRoute(http.MethodPut, AdminFaultsRoute, setFaults)
+	httpapi.RegisterRoute(http.MethodDelete, AdminFaultsRoute, clearFaults)
+
+	log.Println("white flag API registered")
+}
+
+// Initialize creates the mocked ledger and the milestones of the configured migrations.
+// Must be called before the HTTP server is started.
+func Initialize() {
 	cfg := config.GetConfig()
 
+	var err error
+	data, err = createWhiteFlagData(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("mocked coordinator: {address=%s, depth=%d, MWM=%d, latestMSIndex=%d}\n",
+		data.coordinatorAddress, cfg.Coordinator.TreeDepth, cfg.Coordinator.MWM, data.latestMilestoneIndex)
+
+	log.Println("white flag API initialized")
+}
+
+// createWhiteFlagData creates the genesis, the bundles of the configured migrations and the milestones including them.
+func createWhiteFlagData(cfg *config.Config) (*whiteFlagData, error) {
 	log.Println("creating migration bundles...")
 	includedBundles, err := createIncludedBundles(cfg.WhiteFlag, cfg.Coordinator.MWM)
 	if err != nil {
-		log.Fatalf("failed to create bundles: %s", err)
+		return nil, fmt.Errorf("failed to create bundles: %w", err)
 	}
 	log.Printf("created bundles for %d milestone indices\n", len(includedBundles))
 
-	log.Println("creating milestones...")
-	data, err = createMilestones(cfg.Coordinator, includedBundles)
+	genesis, err := createGenesis(cfg.WhiteFlag)
 	if err != nil {
-		log.Fatalf("failed to create milestones: %s", err)
+		return nil, fmt.Errorf("failed to create genesis: %w", err)
 	}
-	log.Printf("mocked coordinator: {address=%s, depth=%d, MWM=%d, latestMSIndex=%d}\n",
-		data.coordinatorAddress, cfg.Coordinator.TreeDepth, cfg.Coordinator.MWM, data.latestMilestoneIndex)
 
-	// register the API commands
-	httpapi.RegisterHandler(strings.ToLower(GetNodeInfoCommand), getNodeInfo)
-	httpapi.RegisterHandler(strings.ToLower(GetWhiteFlagConfirmationCommand), getWhiteFlagConfirmation)
-
-	log.Println("white flag API initialized")
+	log.Println("creating milestones...")
+	d, err := createMilestones(cfg.Coordinator, genesis, includedBundles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create milestones: %w", err)
+	}
+	d.seed = cfg.WhiteFlag.Seed
+	return d, nil
 }
 
 func getPOWFunc() pow.ProofOfWorkFunc {
@@ -75,52 +138,61 @@ func getPOWFunc() pow.ProofOfWorkFunc {
 }
 
 func createIncludedBundles(cfg config.WhiteFlagConfig, mwm int) (map[uint32][][]trinary.Trytes, error) {
-	iotaAPI := new(legacyapi.API)
-
 	includedBundles := make(map[uint32][][]trinary.Trytes)
 	for msIndex, migrations := range cfg.Migrations {
 		var bundles [][]trinary.Trytes
 		for _, migration := range migrations {
-			addr, err := address.GenerateAddress(cfg.Seed, migration.Index, migration.Security, true)
-			if err != nil {
-				return nil, fmt.Errorf("failed to generate address: %w", err)
-			}
-			inputs := []legacyapi.Input{{
-				Balance:  migration.Balance,
-				Address:  addr,
-				KeyIndex: migration.Index,
-				Security: migration.Security,
-			}}
-			migrationAddress, err := generateMigrationAddress(migration.Ed25519Address)
+			bndl, err := createMigrationBundle(cfg.Seed, migration, mwm)
 			if err != nil {
-				return nil, fmt.Errorf("failed to generate migration address from config: %w", err)
-			}
-			transfers := []bundle.Transfer{{
-				Address: migrationAddress,
-				Value:   migration.Balance,
-			}}
-
-			rawTrytes, err := iotaAPI.PrepareTransfers(cfg.Seed, transfers, legacyapi.PrepareTransfersOptions{
-				Inputs: inputs,
-			})
-			if err != nil {
-				return nil, fmt.Errorf("failed to create bundle: %w", err)
-			}
-			// PrepareTransfers returns the transaction trytes in the reversed order, so we must convert and reverse
-			bndl, _ := transaction.AsTransactionObjects(rawTrytes, nil)
-			for i, j := 0, len(bndl)-1; i < j; i, j = i+1, j-1 {
-				bndl[i], bndl[j] = bndl[j], bndl[i]
-			}
-			if err := finalizeBundle(bndl, mwm); err != nil {
-				return nil, fmt.Errorf("failed to finalize the bundle: %w", err)
+				return nil, err
 			}
-			bundles = append(bundles, transaction.MustTransactionsToTrytes(bndl))
+			bundles = append(bundles, bndl)
 		}
 		includedBundles[msIndex] = bundles
 	}
 	return includedBundles, nil
 }
 
+// createMigrationBundle creates the bundle migrating the funds of the given migration, the inputs are derived from the seed.
+func createMigrationBundle(seed trinary.Trytes, migration config.Migration, mwm int) ([]trinary.Trytes, error) {
+	iotaAPI := new(legacyapi.API)
+
+	addr, err := address.GenerateAddress(seed, migration.Index, migration.Security, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate address: %w", err)
+	}
+	inputs := []legacyapi.Input{{
+		Balance:  migration.Balance,
+		Address:  addr,
+		KeyIndex: migration.Index,
+		Security: migration.Security,
+	}}
+	migrationAddress, err := generateMigrationAddress(migration.Ed25519Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate migration address from config: %w", err)
+	}
+	transfers := []bundle.Transfer{{
+		Address: migrationAddress,
+		Value:   migration.Balance,
+	}}
+
+	rawTrytes, err := iotaAPI.PrepareTransfers(seed, transfers, legacyapi.PrepareTransfersOptions{
+		Inputs: inputs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundle: %w", err)
+	}
+	// PrepareTransfers returns the transaction trytes in the reversed order, so we must convert and reverse
+	bndl, _ := transaction.AsTransactionObjects(rawTrytes, nil)
+	for i, j := 0, len(bndl)-1; i < j; i, j = i+1, j-1 {
+		bndl[i], bndl[j] = bndl[j], bndl[i]
+	}
+	if err := finalizeBundle(bndl, mwm); err != nil {
+		return nil, fmt.Errorf("failed to finalize the bundle: %w", err)
+	}
+	return transaction.MustTransactionsToTrytes(bndl), nil
+}
+
 func generateMigrationAddress(bytes []byte) (trinary.Hash, error) {
 	if len(bytes) != 32 {
 		return "", consts.ErrInvalidAddress
@@ -130,12 +202,16 @@ func generateMigrationAddress(bytes []byte) (trinary.Hash, error) {
 	return address.GenerateMigrationAddress(addr, true)
 }
 
-func createMilestones(cfg config.CoordinatorConfig, includedBundles map[uint32][][]trinary.Trytes) (*whiteFlagData, error) {
-	var latestMSIndex uint32
+func createMilestones(cfg config.CoordinatorConfig, genesis map[trinary.Hash]uint64, includedBundles map[uint32][][]trinary.Trytes) (*whiteFlagData, error) {
+	msIndices := make([]uint32, 0, len(includedBundles))
 	for msIndex := range includedBundles {
-		if msIndex > latestMSIndex {
-			latestMSIndex = msIndex
-		}
+		msIndices = append(msIndices, msIndex)
+	}
+	sort.Slice(msIndices, func(i, j int) bool { return msIndices[i] < msIndices[j] })
+
+	var latestMSIndex uint32
+	if len(msIndices) > 0 {
+		latestMSIndex = msIndices[len(msIndices)-1]
 	}
 
 	merkleTree, err := merkle.CreateMerkleTree(cfg.Seed, cfg.Security, cfg.TreeDepth)
@@ -143,31 +219,50 @@ func createMilestones(cfg config.CoordinatorConfig, includedBundles map[uint32][
 		return nil, fmt.Errorf("failed to compute coordinator Merkle tree: %w", err)
 	}
 
-	var latestMSHash trinary.Hash
-	confirmations := make([]whiteFlagMilestone, latestMSIndex+1)
-	for index := uint32(1); index <= latestMSIndex; index++ {
-		var msBundle []trinary.Trytes
-		latestMSHash, msBundle, err = createMilestone(cfg, merkleTree, index, includedBundles[index])
-		if err != nil {
-			return nil, fmt.Errorf("failed to created milestone: %w", err)
-		}
+	context := &whiteFlagData{
+		cfg:                cfg,
+		merkleTree:         merkleTree,
+		coordinatorAddress: merkleTree.Root,
+		milestones:         make([]whiteFlagMilestone, 1, latestMSIndex+1),
+		pendingBundles:     includedBundles,
+		genesis:            genesis,
+		projectedBalances:  make(map[trinary.Hash]uint64, len(genesis)),
+		scheduledTails:     make(map[trinary.Hash]struct{}),
+		tangle:             newTangle(genesis),
+	}
+	for addr, balance := range genesis {
+		context.projectedBalances[addr] = balance
+	}
 
-		confirmations[index] = whiteFlagMilestone{
-			milestoneBundle:          msBundle,
-			includedMigrationBundles: includedBundles[index],
+	// the migrations must be consistent with the ledger
+	for _, msIndex := range msIndices {
+		for _, bndl := range includedBundles[msIndex] {
+			if _, err := context.projectBundle(bndl); err != nil {
+				return nil, fmt.Errorf("invalid migration at milestone %d: %w", msIndex, err)
+			}
+			context.scheduledTails[mustTail(bndl).Hash] = struct{}{}
 		}
 	}
 
-	context := &whiteFlagData{
-		latestMilestoneHash:  latestMSHash,
-		latestMilestoneIndex: latestMSIndex,
-		coordinatorAddress:   merkleTree.Root,
-		milestones:           confirmations,
+	for context.latestMilestoneIndex < latestMSIndex {
+		if err := context.issueMilestone(); err != nil {
+			return nil, err
+		}
 	}
 	return context, nil
 }
 
 func createMilestone(cfg config.CoordinatorConfig, merkleTree *merkle.MerkleTree, index uint32, includedBundles [][]trinary.Trytes) (trinary.Hash, []trinary.Trytes, error) {
+	whiteFlagHash, err := computeWhiteFlagMerkleTreeHash(includedBundles)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to compute white flag Merkle tree hash: %w", err)
+	}
+	return signMilestone(cfg, merkleTree, index, whiteFlagHash, index)
+}
+
+// signMilestone creates the milestone bundle with the given index containing the given white flag Merkle tree hash,
+// signed with the key of the given leaf of the coordinator's Merkle tree.
+func signMilestone(cfg config.CoordinatorConfig, merkleTree *merkle.MerkleTree, index uint32, whiteFlagHash []byte, keyIndex uint32) (trinary.Hash, []trinary.Trytes, error) {
 	leafSiblings, err := merkleTree.AuditPath(index)
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to compute Merkle audit path: %w", err)
@@ -175,10 +270,6 @@ func createMilestone(cfg config.CoordinatorConfig, merkleTree *merkle.MerkleTree
 	siblingsTrytes := strings.Join(leafSiblings, "")
 
 	// append the b1t6 encoded Merkle tree hash to the signature message fragment
-	whiteFlagHash, err := computeWhiteFlagMerkleTreeHash(includedBundles)
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to compute white flag Merkle tree hash: %w", err)
-	}
 	siblingsTrytes += b1t6.EncodeToTrytes(whiteFlagHash)
 
 	tag := trinary.IntToTrytes(int64(index), consts.TagTrinarySize/consts.TritsPerTryte)
@@ -214,7 +305,7 @@ func createMilestone(cfg config.CoordinatorConfig, merkleTree *merkle.MerkleTree
 		return "", nil, fmt.Errorf("failed to do PoW: %w", err)
 	}
 
-	fragments, err := merkle.SignatureFragments(cfg.Seed, index, cfg.Security, txSiblings.Hash)
+	fragments, err := merkle.SignatureFragments(cfg.Seed, keyIndex, cfg.Security, txSiblings.Hash)
 	if err != nil {
 		return "", nil, fmt.Errorf("signing failed: %w", err)
 	}
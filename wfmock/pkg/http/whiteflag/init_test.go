@@ -0,0 +1,119 @@
+package whiteflag
+
+import (
+	"testing"
+
+	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/config"
+	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/hexutil"
+	"github.com/iotaledger/iota.go/address"
+	"github.com/iotaledger/iota.go/transaction"
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testCoordinatorSeed = "YJTQYEWGHGALXDL9MEVDUOFJFOXXFLTLLP9VDYSBOGZEQEGTPBEYQPB9GWHGKQAPFTADPJV99EVGAUGE9"
+	testWhiteFlagSeed   = "XUCKWJVTYPUVFFBVGVMAPAGCCJSYFIBPWMWFYVZJCNMBWSVIG9WDEHIHQLCSNUZCCZWF99VIZPYKGKDRC"
+)
+
+var testEd25519Address = hexutil.Bytes{
+	0x2c, 0x2b, 0xb0, 0x61, 0xde, 0x51, 0xf0, 0x9c, 0xe2, 0xcc, 0xee, 0x44, 0xa6, 0x26, 0x76, 0x2b,
+	0xbb, 0x76, 0x69, 0x97, 0xe1, 0xc8, 0x09, 0x8e, 0xae, 0xc2, 0xe3, 0xa0, 0x89, 0xc6, 0x58, 0x43,
+}
+
+// returns a config with cheap PoW and signatures funding the addresses 0 to 3 and migrating 0 and 1 at milestone 2.
+func newTestConfig() *config.Config {
+	return &config.Config{
+		Coordinator: config.CoordinatorConfig{
+			Seed:      testCoordinatorSeed,
+			Security:  1,
+			TreeDepth: 3,
+			MWM:       1,
+		},
+		WhiteFlag: config.WhiteFlagConfig{
+			Seed: testWhiteFlagSeed,
+			Genesis: []config.GenesisBalance{
+				{Index: 0, Security: 1, Balance: 1_000_000},
+				{Index: 1, Security: 1, Balance: 2_000_000},
+				{Index: 2, Security: 1, Balance: 3_000_000},
+				{Index: 3, Security: 1, Balance: 4_000_000},
+			},
+			Migrations: map[uint32][]config.Migration{
+				2: {
+					{Balance: 1_000_000, Index: 0, Security: 1, Ed25519Address: testEd25519Address},
+					{Balance: 2_000_000, Index: 1, Security: 1, Ed25519Address: testEd25519Address},
+				},
+			},
+		},
+	}
+}
+
+// creates the data of the given config and installs it as the data served by the handlers.
+func newTestData(t *testing.T, cfg *config.Config) *whiteFlagData {
+	d, err := createWhiteFlagData(cfg)
+	require.NoError(t, err)
+	data = d
+	return d
+}
+
+// returns the address with the given index derived from the white flag seed.
+func testAddress(t *testing.T, index uint64) trinary.Hash {
+	addr, err := address.GenerateAddress(testWhiteFlagSeed, index, 1)
+	require.NoError(t, err)
+	return addr
+}
+
+func TestCreateWhiteFlagData(t *testing.T) {
+	d := newTestData(t, newTestConfig())
+
+	require.EqualValues(t, 2, d.latestMilestoneIndex)
+	require.Len(t, d.milestones, 3)
+	assert.Empty(t, d.milestones[1].includedMigrationBundles)
+	assert.Len(t, d.milestones[2].includedMigrationBundles, 2)
+	assert.Empty(t, d.pendingBundles)
+	assert.Len(t, d.scheduledTails, 2)
+
+	for msIndex, ms := range d.milestones[1:] {
+		txs, err := transaction.AsTransactionObjects(ms.milestoneBundle, nil)
+		require.NoError(t, err)
+		require.Len(t, txs, 2)
+		assert.Equal(t, d.coordinatorAddress, txs[0].Address)
+		assert.Equal(t, trinary.IntToTrytes(int64(msIndex+1), 27), txs[0].ObsoleteTag)
+	}
+	assert.Equal(t, mustTail(d.milestones[2].milestoneBundle).Hash, d.latestMilestoneHash)
+}
+
+func TestCreateWhiteFlagDataInconsistentMigration(t *testing.T) {
+	cfg := newTestConfig()
+	// the input of the migration only holds 1 Mi
+	cfg.WhiteFlag.Migrations[3] = []config.Migration{{Balance: 5_000_000, Index: 0, Security: 1, Ed25519Address: testEd25519Address}}
+
+	_, err := createWhiteFlagData(cfg)
+	assert.Error(t, err)
+}
+
+func TestIssueMilestone(t *testing.T) {
+	d := newTestData(t, newTestConfig())
+
+	bndl, err := createMigrationBundle(testWhiteFlagSeed, config.Migration{Balance: 3_000_000, Index: 2, Security: 1, Ed25519Address: testEd25519Address}, 1)
+	require.NoError(t, err)
+	msIndex, _, err := d.scheduleBundle(bndl)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, msIndex)
+
+	// the scheduled bundle is included by the next milestone, the ones after are empty
+	require.NoError(t, d.issueMilestone())
+	require.NoError(t, d.issueMilestone())
+	assert.EqualValues(t, 4, d.latestIndex())
+	assert.Equal(t, [][]trinary.Trytes{bndl}, d.milestones[3].includedMigrationBundles)
+	assert.Empty(t, d.milestones[4].includedMigrationBundles)
+	assert.Empty(t, d.pendingBundles)
+
+	// the keys of the Merkle tree of depth 3 are used up after milestone 7
+	for d.latestIndex() < 7 {
+		require.NoError(t, d.issueMilestone())
+	}
+	assert.Error(t, d.issueMilestone())
+	assert.EqualValues(t, 7, d.latestIndex())
+}
@@ -0,0 +1,261 @@
+package whiteflag
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/config"
+	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/faults"
+	httpapi "github.com/iotaledger/chrysalis-tools/wfmock/pkg/http"
+	"github.com/iotaledger/iota.go/address"
+	"github.com/iotaledger/iota.go/transaction"
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/labstack/echo/v4"
+	"github.com/mitchellh/mapstructure"
+)
+
+// GetLedgerStateCommand defines the command for the getLedgerState API call.
+const GetLedgerStateCommand = "getLedgerState"
+
+// GetLedgerDiffExtCommand defines the command for the getLedgerDiffExt API call.
+const GetLedgerDiffExtCommand = "getLedgerDiffExt"
+
+// GetLedgerStateRequest represents the payload to the getLedgerState API call.
+type GetLedgerStateRequest struct {
+	Command     string `mapstructure:"command"`
+	TargetIndex uint32 `mapstructure:"targetIndex"`
+}
+
+// GetLedgerStateResponse defines the response of a getLedgerState API call.
+type GetLedgerStateResponse struct {
+	Balances       map[trinary.Hash]uint64 `json:"balances"`
+	MilestoneIndex uint32                  `json:"milestoneIndex"`
+	Duration       int                     `json:"duration"`
+}
+
+// GetLedgerDiffExtRequest represents the payload to the getLedgerDiffExt API call.
+type GetLedgerDiffExtRequest struct {
+	Command        string `mapstructure:"command"`
+	MilestoneIndex uint32 `mapstructure:"milestoneIndex"`
+}
+
+// GetLedgerDiffExtResponse defines the response of a getLedgerDiffExt API call.
+type GetLedgerDiffExtResponse struct {
+	ConfirmedTxWithValue      []*TxHashWithValue     `json:"confirmedTxWithValue"`
+	ConfirmedBundlesWithValue []*BundleWithValue     `json:"confirmedBundlesWithValue"`
+	Diff                      map[trinary.Hash]int64 `json:"diff"`
+	MilestoneIndex            uint32                 `json:"milestoneIndex"`
+	Duration                  int                    `json:"duration"`
+}
+
+// TxHashWithValue is a value transaction confirmed by a milestone.
+type TxHashWithValue struct {
+	TxHash     trinary.Hash `json:"txHash"`
+	TailTxHash trinary.Hash `json:"tailTxHash"`
+	BundleHash trinary.Hash `json:"bundleHash"`
+	Address    trinary.Hash `json:"address"`
+	Value      int64        `json:"value"`
+}
+
+// BundleWithValue is a bundle containing value transactions confirmed by a milestone.
+type BundleWithValue struct {
+	BundleHash trinary.Hash   `json:"bundleHash"`
+	TailTxHash trinary.Hash   `json:"tailTxHash"`
+	LastIndex  uint64         `json:"lastIndex"`
+	Txs        []*TxWithValue `json:"txs"`
+}
+
+// TxWithValue is a value transaction within a BundleWithValue.
+type TxWithValue struct {
+	TxHash  trinary.Hash `json:"txHash"`
+	Address trinary.Hash `json:"address"`
+	Index   uint64       `json:"index"`
+	Value   int64        `json:"value"`
+}
+
+func getLedgerState(i interface{}, c echo.Context) error {
+	start := time.Now()
+
+	request := &GetLedgerStateRequest{}
+	if err := mapstructure.Decode(i, request); err != nil {
+		e := httpapi.ErrorReturn{
+			Error: fmt.Sprintf("invalid request: %s", err),
+		}
+		return c.JSON(http.StatusBadRequest, e)
+	}
+
+	data.RLock()
+	defer data.RUnlock()
+
+	if request.TargetIndex == 0 || request.TargetIndex > data.latestMilestoneIndex {
+		e := httpapi.ErrorReturn{
+			Error: fmt.Sprintf("target index %d is not within the confirmed milestones 1 to %d", request.TargetIndex, data.latestMilestoneIndex),
+		}
+		return c.JSON(http.StatusBadRequest, e)
+	}
+
+	balances := make(map[trinary.Hash]int64, len(data.genesis))
+	for addr, balance := range data.genesis {
+		balances[addr] = int64(balance)
+	}
+	for _, ms := range data.milestones[1 : request.TargetIndex+1] {
+		for addr, change := range ms.ledgerDiff.Diff {
+			balances[addr] += change
+		}
+	}
+
+	res := GetLedgerStateResponse{
+		Balances:       make(map[trinary.Hash]uint64, len(balances)),
+		MilestoneIndex: request.TargetIndex,
+	}
+	for addr, balance := range balances {
+		if balance > 0 {
+			res.Balances[addr] = uint64(balance)
+		}
+	}
+	res.Duration = int(time.Since(start).Milliseconds())
+	return c.JSON(http.StatusOK, res)
+}
+
+func getLedgerDiffExt(i interface{}, c echo.Context) error {
+	start := time.Now()
+
+	request := &GetLedgerDiffExtRequest{}
+	if err := mapstructure.Decode(i, request); err != nil {
+		e := httpapi.ErrorReturn{
+			Error: fmt.Sprintf("invalid request: %s", err),
+		}
+		return c.JSON(http.StatusBadRequest, e)
+	}
+
+	profile := faults.Match(GetLedgerDiffExtCommand, request.MilestoneIndex)
+
+	data.RLock()
+	defer data.RUnlock()
+
+	if profile.MissingMilestone || request.MilestoneIndex == 0 || request.MilestoneIndex > data.latestMilestoneIndex {
+		e := httpapi.ErrorReturn{
+			Error: fmt.Sprintf("milestone not found for ledger diff at %d", request.MilestoneIndex),
+		}
+		return c.JSON(http.StatusBadRequest, e)
+	}
+
+	res := data.milestones[request.MilestoneIndex].ledgerDiff
+	res.Duration = int(time.Since(start).Milliseconds())
+	return c.JSON(http.StatusOK, res)
+}
+
+// createGenesis returns the balances of the configured genesis or, if none is configured,
+// funds the inputs of the configured migrations.
+func createGenesis(cfg config.WhiteFlagConfig) (map[trinary.Hash]uint64, error) {
+	balances := cfg.Genesis
+	if len(balances) == 0 {
+		for _, migrations := range cfg.Migrations {
+			for _, migration := range migrations {
+				balances = append(balances, config.GenesisBalance{
+					Index:    migration.Index,
+					Security: migration.Security,
+					Balance:  migration.Balance,
+				})
+			}
+		}
+	}
+
+	genesis := make(map[trinary.Hash]uint64, len(balances))
+	for _, balance := range balances {
+		addr, err := address.GenerateAddress(cfg.Seed, balance.Index, balance.Security)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate genesis address: %w", err)
+		}
+		genesis[addr] += balance.Balance
+	}
+	return genesis, nil
+}
+
+// createLedgerDiff creates the extended ledger diff of the milestone with the given index including the given bundles.
+func createLedgerDiff(index uint32, includedBundles [][]trinary.Trytes) (GetLedgerDiffExtResponse, error) {
+	ledgerDiff := GetLedgerDiffExtResponse{
+		ConfirmedTxWithValue:      make([]*TxHashWithValue, 0),
+		ConfirmedBundlesWithValue: make([]*BundleWithValue, 0),
+		Diff:                      make(map[trinary.Hash]int64),
+		MilestoneIndex:            index,
+	}
+
+	for _, bundleTrytes := range includedBundles {
+		txs, err := transaction.AsTransactionObjects(bundleTrytes, nil)
+		if err != nil {
+			return ledgerDiff, fmt.Errorf("failed to parse bundle: %w", err)
+		}
+
+		tail := &txs[0]
+		bndl := &BundleWithValue{
+			BundleHash: tail.Bundle,
+			TailTxHash: tail.Hash,
+			LastIndex:  tail.LastIndex,
+		}
+		for i := range txs {
+			tx := &txs[i]
+			if tx.Value == 0 {
+				continue
+			}
+			bndl.Txs = append(bndl.Txs, &TxWithValue{TxHash: tx.Hash, Address: tx.Address, Index: tx.CurrentIndex, Value: tx.Value})
+			ledgerDiff.ConfirmedTxWithValue = append(ledgerDiff.ConfirmedTxWithValue, &TxHashWithValue{
+				TxHash:     tx.Hash,
+				TailTxHash: tail.Hash,
+				BundleHash: tx.Bundle,
+				Address:    tx.Address,
+				Value:      tx.Value,
+			})
+			ledgerDiff.Diff[tx.Address] += tx.Value
+		}
+		if len(bndl.Txs) > 0 {
+			ledgerDiff.ConfirmedBundlesWithValue = append(ledgerDiff.ConfirmedBundlesWithValue, bndl)
+		}
+	}
+
+	for addr, change := range ledgerDiff.Diff {
+		if change == 0 {
+			delete(ledgerDiff.Diff, addr)
+		}
+	}
+	return ledgerDiff, nil
+}
+
+// projectBundle applies the given bundle to the balances after all issued and scheduled milestones
+// and returns a function restoring the previous balances.
+// Returns an error if the bundle spends more than or only a part of the balance of an input address.
+// Must be called while holding issueMu.
+func (d *whiteFlagData) projectBundle(bundleTrytes []trinary.Trytes) (func(), error) {
+	txs, err := transaction.AsTransactionObjects(bundleTrytes, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse bundle: %w", err)
+	}
+
+	changes := make(map[trinary.Hash]int64)
+	for i := range txs {
+		changes[txs[i].Address] += txs[i].Value
+	}
+	for addr, change := range changes {
+		if change < 0 && d.projectedBalances[addr] != uint64(-change) {
+			return nil, fmt.Errorf("input address %s holds %d but the bundle spends %d", addr, d.projectedBalances[addr], -change)
+		}
+	}
+
+	previous := make(map[trinary.Hash]uint64, len(changes))
+	for addr, change := range changes {
+		if balance, has := d.projectedBalances[addr]; has {
+			previous[addr] = balance
+		}
+		d.projectedBalances[addr] = uint64(int64(d.projectedBalances[addr]) + change)
+	}
+	return func() {
+		for addr := range changes {
+			if balance, has := previous[addr]; has {
+				d.projectedBalances[addr] = balance
+				continue
+			}
+			delete(d.projectedBalances, addr)
+		}
+	}, nil
+}
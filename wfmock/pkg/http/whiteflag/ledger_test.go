@@ -0,0 +1,160 @@
+package whiteflag
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/config"
+	httpapi "github.com/iotaledger/chrysalis-tools/wfmock/pkg/http"
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serves the given JSON request by the given command handler.
+func serveCommand(t *testing.T, handler httpapi.HandlerFunc, request string) *httptest.ResponseRecorder {
+	decoded := make(map[string]interface{})
+	require.NoError(t, json.Unmarshal([]byte(request), &decoded))
+
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(request)), rec)
+	require.NoError(t, handler(decoded, c))
+	return rec
+}
+
+func testMigrationAddress(t *testing.T) trinary.Hash {
+	addr, err := generateMigrationAddress(testEd25519Address)
+	require.NoError(t, err)
+	return withoutChecksum(addr)
+}
+
+func TestProjectBundle(t *testing.T) {
+	var tests = []struct {
+		name      string
+		migration config.Migration
+		expErr    bool
+	}{
+		{name: "whole balance", migration: config.Migration{Balance: 3_000_000, Index: 2, Security: 1}},
+		{name: "part of the balance", migration: config.Migration{Balance: 1_000_000, Index: 3, Security: 1}, expErr: true},
+		{name: "more than the balance", migration: config.Migration{Balance: 5_000_000, Index: 3, Security: 1}, expErr: true},
+		{name: "spent input", migration: config.Migration{Balance: 1_000_000, Index: 0, Security: 1}, expErr: true},
+		{name: "unfunded input", migration: config.Migration{Balance: 1_000_000, Index: 5, Security: 1}, expErr: true},
+	}
+
+	d := newTestData(t, newTestConfig())
+	migrationAddr := testMigrationAddress(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.migration.Ed25519Address = testEd25519Address
+			bndl, err := createMigrationBundle(testWhiteFlagSeed, tt.migration, 1)
+			require.NoError(t, err)
+
+			inputAddr := testAddress(t, tt.migration.Index)
+			inputBalance, migrated := d.projectedBalances[inputAddr], d.projectedBalances[migrationAddr]
+
+			undo, err := d.projectBundle(bndl)
+			if tt.expErr {
+				require.Error(t, err)
+				assert.Equal(t, inputBalance, d.projectedBalances[inputAddr])
+				assert.Equal(t, migrated, d.projectedBalances[migrationAddr])
+				return
+			}
+			require.NoError(t, err)
+			assert.Zero(t, d.projectedBalances[inputAddr])
+			assert.Equal(t, migrated+tt.migration.Balance, d.projectedBalances[migrationAddr])
+
+			undo()
+			assert.Equal(t, inputBalance, d.projectedBalances[inputAddr])
+			assert.Equal(t, migrated, d.projectedBalances[migrationAddr])
+		})
+	}
+}
+
+func TestCreateLedgerDiff(t *testing.T) {
+	d := newTestData(t, newTestConfig())
+	bundles := d.milestones[2].includedMigrationBundles
+
+	ledgerDiff, err := createLedgerDiff(2, bundles)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, ledgerDiff.MilestoneIndex)
+	assert.Equal(t, map[trinary.Hash]int64{
+		testAddress(t, 0):       -1_000_000,
+		testAddress(t, 1):       -2_000_000,
+		testMigrationAddress(t): 3_000_000,
+	}, ledgerDiff.Diff)
+
+	// a migration with security level 1 consists of the output and the input
+	assert.Len(t, ledgerDiff.ConfirmedTxWithValue, 4)
+	require.Len(t, ledgerDiff.ConfirmedBundlesWithValue, 2)
+	for i, bndl := range ledgerDiff.ConfirmedBundlesWithValue {
+		tail := mustTail(bundles[i])
+		assert.Equal(t, tail.Hash, bndl.TailTxHash)
+		assert.Equal(t, tail.Bundle, bndl.BundleHash)
+		assert.Len(t, bndl.Txs, 2)
+	}
+
+	// empty milestones have an empty, but non-nil diff
+	ledgerDiff, err = createLedgerDiff(1, nil)
+	require.NoError(t, err)
+	assert.NotNil(t, ledgerDiff.ConfirmedTxWithValue)
+	assert.NotNil(t, ledgerDiff.ConfirmedBundlesWithValue)
+	assert.Empty(t, ledgerDiff.Diff)
+}
+
+func TestGetLedgerState(t *testing.T) {
+	newTestData(t, newTestConfig())
+	genesis := map[trinary.Hash]uint64{
+		testAddress(t, 0): 1_000_000,
+		testAddress(t, 1): 2_000_000,
+		testAddress(t, 2): 3_000_000,
+		testAddress(t, 3): 4_000_000,
+	}
+
+	var tests = []struct {
+		targetIndex uint32
+		expStatus   int
+		expBalances map[trinary.Hash]uint64
+	}{
+		{targetIndex: 0, expStatus: http.StatusBadRequest},
+		{targetIndex: 1, expStatus: http.StatusOK, expBalances: genesis},
+		{targetIndex: 2, expStatus: http.StatusOK, expBalances: map[trinary.Hash]uint64{
+			testAddress(t, 2):       3_000_000,
+			testAddress(t, 3):       4_000_000,
+			testMigrationAddress(t): 3_000_000,
+		}},
+		{targetIndex: 3, expStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		rec := serveCommand(t, getLedgerState, fmt.Sprintf(`{"command": "getLedgerState", "targetIndex": %d}`, tt.targetIndex))
+		require.Equal(t, tt.expStatus, rec.Code, "target index %d", tt.targetIndex)
+		if tt.expStatus != http.StatusOK {
+			continue
+		}
+
+		res := &GetLedgerStateResponse{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), res))
+		assert.Equal(t, tt.targetIndex, res.MilestoneIndex)
+		assert.Equal(t, tt.expBalances, res.Balances, "target index %d", tt.targetIndex)
+	}
+}
+
+func TestGetLedgerDiffExt(t *testing.T) {
+	d := newTestData(t, newTestConfig())
+
+	rec := serveCommand(t, getLedgerDiffExt, `{"command": "getLedgerDiffExt", "milestoneIndex": 2}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	res := &GetLedgerDiffExtResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), res))
+	res.Duration = 0
+	assert.Equal(t, d.milestones[2].ledgerDiff, *res)
+
+	rec = serveCommand(t, getLedgerDiffExt, `{"command": "getLedgerDiffExt", "milestoneIndex": 3}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,321 @@
+package whiteflag
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	httpapi "github.com/iotaledger/chrysalis-tools/wfmock/pkg/http"
+	"github.com/iotaledger/iota.go/bundle"
+	"github.com/iotaledger/iota.go/consts"
+	"github.com/iotaledger/iota.go/guards"
+	"github.com/iotaledger/iota.go/pow"
+	"github.com/iotaledger/iota.go/transaction"
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/labstack/echo/v4"
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	// GetBalancesCommand defines the command for the getBalances API call.
+	GetBalancesCommand = "getBalances"
+	// WereAddressesSpentFromCommand defines the command for the wereAddressesSpentFrom API call.
+	WereAddressesSpentFromCommand = "wereAddressesSpentFrom"
+	// FindTransactionsCommand defines the command for the findTransactions API call.
+	FindTransactionsCommand = "findTransactions"
+	// GetTrytesCommand defines the command for the getTrytes API call.
+	GetTrytesCommand = "getTrytes"
+	// GetInclusionStatesCommand defines the command for the getInclusionStates API call.
+	GetInclusionStatesCommand = "getInclusionStates"
+	// GetTransactionsToApproveCommand defines the command for the getTransactionsToApprove API call.
+	GetTransactionsToApproveCommand = "getTransactionsToApprove"
+	// AttachToTangleCommand defines the command for the attachToTangle API call.
+	AttachToTangleCommand = "attachToTangle"
+	// StoreTransactionsCommand defines the command for the storeTransactions API call.
+	StoreTransactionsCommand = "storeTransactions"
+	// BroadcastTransactionsCommand defines the command for the broadcastTransactions API call.
+	BroadcastTransactionsCommand = "broadcastTransactions"
+)
+
+// the trytes returned for unknown transactions.
+var nullTransactionTrytes = strings.Repeat("9", consts.TransactionTrinarySize/consts.TritsPerTryte)
+
+// AddressesRequest represents the payload to the getBalances and wereAddressesSpentFrom API calls.
+type AddressesRequest struct {
+	Command   string         `mapstructure:"command"`
+	Addresses trinary.Hashes `mapstructure:"addresses"`
+}
+
+// GetBalancesResponse defines the response of a getBalances API call.
+type GetBalancesResponse struct {
+	Balances       []string     `json:"balances"`
+	Milestone      trinary.Hash `json:"milestone"`
+	MilestoneIndex uint32       `json:"milestoneIndex"`
+	Duration       int          `json:"duration"`
+}
+
+// StatesResponse defines the response of the wereAddressesSpentFrom and getInclusionStates API calls.
+type StatesResponse struct {
+	States []bool `json:"states"`
+}
+
+// FindTransactionsRequest represents the payload to the findTransactions API call.
+type FindTransactionsRequest struct {
+	Command   string           `mapstructure:"command"`
+	Addresses trinary.Hashes   `mapstructure:"addresses"`
+	Bundles   trinary.Hashes   `mapstructure:"bundles"`
+	Tags      []trinary.Trytes `mapstructure:"tags"`
+	Approvees trinary.Hashes   `mapstructure:"approvees"`
+}
+
+// FindTransactionsResponse defines the response of a findTransactions API call.
+type FindTransactionsResponse struct {
+	Hashes trinary.Hashes `json:"hashes"`
+}
+
+// GetTrytesRequest represents the payload to the getTrytes API call.
+type GetTrytesRequest struct {
+	Command string         `mapstructure:"command"`
+	Hashes  trinary.Hashes `mapstructure:"hashes"`
+}
+
+// TrytesResponse defines the response of the getTrytes and attachToTangle API calls.
+type TrytesResponse struct {
+	Trytes []trinary.Trytes `json:"trytes"`
+}
+
+// GetInclusionStatesRequest represents the payload to the getInclusionStates API call.
+type GetInclusionStatesRequest struct {
+	Command      string         `mapstructure:"command"`
+	Transactions trinary.Hashes `mapstructure:"transactions"`
+}
+
+// GetTransactionsToApproveResponse defines the response of a getTransactionsToApprove API call.
+type GetTransactionsToApproveResponse struct {
+	TrunkTransaction  trinary.Hash `json:"trunkTransaction"`
+	BranchTransaction trinary.Hash `json:"branchTransaction"`
+	Duration          int          `json:"duration"`
+}
+
+// AttachToTangleRequest represents the payload to the attachToTangle API call.
+type AttachToTangleRequest struct {
+	Command            string           `mapstructure:"command"`
+	TrunkTransaction   trinary.Hash     `mapstructure:"trunkTransaction"`
+	BranchTransaction  trinary.Hash     `mapstructure:"branchTransaction"`
+	MinWeightMagnitude uint64           `mapstructure:"minWeightMagnitude"`
+	Trytes             []trinary.Trytes `mapstructure:"trytes"`
+}
+
+// StoreTransactionsRequest represents the payload to the storeTransactions and broadcastTransactions API calls.
+type StoreTransactionsRequest struct {
+	Command string           `mapstructure:"command"`
+	Trytes  []trinary.Trytes `mapstructure:"trytes"`
+}
+
+// returns the error response of a request which couldn't be decoded.
+func invalidRequest(err error) httpapi.ErrorReturn {
+	return httpapi.ErrorReturn{
+		Error: fmt.Sprintf("invalid request: %s", err),
+	}
+}
+
+func getBalances(i interface{}, c echo.Context) error {
+	start := time.Now()
+
+	request := &AddressesRequest{}
+	if err := mapstructure.Decode(i, request); err != nil {
+		return c.JSON(http.StatusBadRequest, invalidRequest(err))
+	}
+
+	data.RLock()
+	defer data.RUnlock()
+
+	res := GetBalancesResponse{
+		Balances:       make([]string, len(request.Addresses)),
+		Milestone:      data.latestMilestoneHash,
+		MilestoneIndex: data.latestMilestoneIndex,
+	}
+	for j, addr := range request.Addresses {
+		res.Balances[j] = strconv.FormatUint(data.tangle.balances[withoutChecksum(addr)], 10)
+	}
+	res.Duration = int(time.Since(start).Milliseconds())
+	return c.JSON(http.StatusOK, res)
+}
+
+func wereAddressesSpentFrom(i interface{}, c echo.Context) error {
+	request := &AddressesRequest{}
+	if err := mapstructure.Decode(i, request); err != nil {
+		return c.JSON(http.StatusBadRequest, invalidRequest(err))
+	}
+
+	data.RLock()
+	defer data.RUnlock()
+
+	res := StatesResponse{States: make([]bool, len(request.Addresses))}
+	for j, addr := range request.Addresses {
+		_, res.States[j] = data.tangle.spentAddresses[withoutChecksum(addr)]
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func findTransactions(i interface{}, c echo.Context) error {
+	request := &FindTransactionsRequest{}
+	if err := mapstructure.Decode(i, request); err != nil {
+		return c.JSON(http.StatusBadRequest, invalidRequest(err))
+	}
+	if len(request.Addresses)+len(request.Bundles)+len(request.Tags)+len(request.Approvees) == 0 {
+		e := httpapi.ErrorReturn{
+			Error: "no search criteria given",
+		}
+		return c.JSON(http.StatusBadRequest, e)
+	}
+
+	data.RLock()
+	defer data.RUnlock()
+
+	res := FindTransactionsResponse{
+		Hashes: data.tangle.find(request.Addresses, request.Bundles, request.Tags, request.Approvees),
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func getTrytes(i interface{}, c echo.Context) error {
+	request := &GetTrytesRequest{}
+	if err := mapstructure.Decode(i, request); err != nil {
+		return c.JSON(http.StatusBadRequest, invalidRequest(err))
+	}
+
+	data.RLock()
+	defer data.RUnlock()
+
+	res := TrytesResponse{Trytes: make([]trinary.Trytes, len(request.Hashes))}
+	for j, txHash := range request.Hashes {
+		tx, has := data.tangle.txs[txHash]
+		if !has {
+			res.Trytes[j] = nullTransactionTrytes
+			continue
+		}
+		res.Trytes[j] = transaction.MustTransactionToTrytes(tx)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func getInclusionStates(i interface{}, c echo.Context) error {
+	request := &GetInclusionStatesRequest{}
+	if err := mapstructure.Decode(i, request); err != nil {
+		return c.JSON(http.StatusBadRequest, invalidRequest(err))
+	}
+
+	data.RLock()
+	defer data.RUnlock()
+
+	res := StatesResponse{States: make([]bool, len(request.Transactions))}
+	for j, txHash := range request.Transactions {
+		_, res.States[j] = data.tangle.confirmedBy[txHash]
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func getTransactionsToApprove(_ interface{}, c echo.Context) error {
+	data.RLock()
+	defer data.RUnlock()
+
+	// every transaction approves the latest milestone, which confirms all previously broadcast bundles anyway
+	tip := data.latestMilestoneHash
+	if len(tip) == 0 {
+		tip = consts.NullHashTrytes
+	}
+	res := GetTransactionsToApproveResponse{
+		TrunkTransaction:  tip,
+		BranchTransaction: tip,
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func attachToTangle(i interface{}, c echo.Context) error {
+	request := &AttachToTangleRequest{}
+	if err := mapstructure.Decode(i, request); err != nil {
+		return c.JSON(http.StatusBadRequest, invalidRequest(err))
+	}
+	if !guards.IsTransactionHash(request.TrunkTransaction) || !guards.IsTransactionHash(request.BranchTransaction) {
+		e := httpapi.ErrorReturn{
+			Error: "invalid trunk or branch transaction",
+		}
+		return c.JSON(http.StatusBadRequest, e)
+	}
+	for _, trytes := range request.Trytes {
+		if err := transaction.ValidTransactionTrytes(trytes); err != nil {
+			e := httpapi.ErrorReturn{
+				Error: fmt.Sprintf("invalid transaction trytes: %s", err),
+			}
+			return c.JSON(http.StatusBadRequest, e)
+		}
+	}
+
+	attached, err := pow.DoPoW(request.TrunkTransaction, request.BranchTransaction, request.Trytes, request.MinWeightMagnitude, powFunc)
+	if err != nil {
+		e := httpapi.ErrorReturn{
+			Error: fmt.Sprintf("failed to do PoW: %s", err),
+		}
+		return c.JSON(http.StatusInternalServerError, e)
+	}
+	return c.JSON(http.StatusOK, TrytesResponse{Trytes: attached})
+}
+
+// storeTransactions stores the given transactions and includes every bundle they complete into the next milestone.
+func storeTransactions(i interface{}, c echo.Context) error {
+	request := &StoreTransactionsRequest{}
+	if err := mapstructure.Decode(i, request); err != nil {
+		return c.JSON(http.StatusBadRequest, invalidRequest(err))
+	}
+
+	mwm := uint64(data.cfg.MWM)
+	txs := make([]transaction.Transaction, len(request.Trytes))
+	for j, trytes := range request.Trytes {
+		tx, err := transaction.AsTransactionObject(trytes)
+		if err == nil && !transaction.HasValidNonce(tx, mwm) {
+			err = consts.ErrInvalidHash
+		}
+		if err != nil {
+			e := httpapi.ErrorReturn{
+				Error: fmt.Sprintf("invalid transaction at %d: %s", j, err),
+			}
+			return c.JSON(http.StatusBadRequest, e)
+		}
+		txs[j] = *tx
+	}
+
+	data.Lock()
+	added := data.tangle.add(txs)
+	var completed []bundle.Bundle
+	seen := make(map[trinary.Hash]struct{})
+	for _, tx := range added {
+		if _, has := seen[tx.Bundle]; has {
+			continue
+		}
+		seen[tx.Bundle] = struct{}{}
+		if bndl := data.tangle.bundleOf(tx.Bundle); bndl != nil {
+			completed = append(completed, bndl)
+		}
+	}
+	data.Unlock()
+
+	for _, bndl := range completed {
+		tailHash := bndl[0].Hash
+		if err := bundle.ValidBundle(bndl); err != nil {
+			log.Printf("ignoring invalid bundle with tail %s: %s", tailHash, err)
+			continue
+		}
+		msIndex, err := data.includeBundle(transaction.MustTransactionsToTrytes(bndl))
+		if err != nil {
+			log.Printf("ignoring bundle with tail %s: %s", tailHash, err)
+			continue
+		}
+		log.Printf("bundle with tail %s gets included by milestone %d", tailHash, msIndex)
+	}
+
+	return c.JSON(http.StatusOK, struct{}{})
+}
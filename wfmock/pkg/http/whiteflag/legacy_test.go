@@ -0,0 +1,130 @@
+package whiteflag
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	httpapi "github.com/iotaledger/chrysalis-tools/wfmock/pkg/http"
+	"github.com/iotaledger/iota.go/address"
+	"github.com/iotaledger/iota.go/consts"
+	"github.com/iotaledger/iota.go/transaction"
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serves the given request by the given command handler and decodes its response into res.
+func serveLegacyCommand(t *testing.T, handler httpapi.HandlerFunc, request string, res interface{}) {
+	t.Helper()
+	rec := serveCommand(t, handler, request)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), res))
+}
+
+// returns the given hashes as a JSON array.
+func jsonHashes(hashes ...trinary.Hash) string {
+	return `["` + strings.Join(hashes, `", "`) + `"]`
+}
+
+func TestGetBalances(t *testing.T) {
+	d := newTestData(t, newTestConfig())
+	addrWithChecksum, err := address.GenerateAddress(testWhiteFlagSeed, 2, 1, true)
+	require.NoError(t, err)
+
+	res := &GetBalancesResponse{}
+	serveLegacyCommand(t, getBalances, fmt.Sprintf(`{"command": "getBalances", "addresses": %s}`,
+		jsonHashes(testAddress(t, 0), addrWithChecksum, testMigrationAddress(t), testAddress(t, 5))), res)
+	assert.Equal(t, []string{"0", "3000000", "3000000", "0"}, res.Balances)
+	assert.Equal(t, d.latestMilestoneHash, res.Milestone)
+	assert.EqualValues(t, 2, res.MilestoneIndex)
+}
+
+func TestWereAddressesSpentFrom(t *testing.T) {
+	newTestData(t, newTestConfig())
+
+	res := &StatesResponse{}
+	serveLegacyCommand(t, wereAddressesSpentFrom, fmt.Sprintf(`{"command": "wereAddressesSpentFrom", "addresses": %s}`,
+		jsonHashes(testAddress(t, 0), testAddress(t, 1), testAddress(t, 2), testMigrationAddress(t))), res)
+	assert.Equal(t, []bool{true, true, false, false}, res.States)
+}
+
+func TestFindTransactionsAndGetTrytes(t *testing.T) {
+	d := newTestData(t, newTestConfig())
+	bundleTrytes := d.milestones[2].includedMigrationBundles[0]
+	tail := mustTail(bundleTrytes)
+
+	found := &FindTransactionsResponse{}
+	serveLegacyCommand(t, findTransactions, fmt.Sprintf(`{"command": "findTransactions", "bundles": %s}`, jsonHashes(tail.Bundle)), found)
+	require.Len(t, found.Hashes, len(bundleTrytes))
+
+	trytes := &TrytesResponse{}
+	serveLegacyCommand(t, getTrytes, fmt.Sprintf(`{"command": "getTrytes", "hashes": %s}`, jsonHashes(tail.Hash, consts.NullHashTrytes)), trytes)
+	require.Len(t, trytes.Trytes, 2)
+	assert.Equal(t, bundleTrytes[0], trytes.Trytes[0])
+	assert.Equal(t, nullTransactionTrytes, trytes.Trytes[1])
+
+	rec := serveCommand(t, findTransactions, `{"command": "findTransactions"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestGetInclusionStates(t *testing.T) {
+	d := newTestData(t, newTestConfig())
+	tail := mustTail(d.milestones[2].includedMigrationBundles[0])
+	msTail := mustTail(d.milestones[1].milestoneBundle)
+
+	res := &StatesResponse{}
+	serveLegacyCommand(t, getInclusionStates, fmt.Sprintf(`{"command": "getInclusionStates", "transactions": %s}`,
+		jsonHashes(tail.Hash, msTail.Hash, consts.NullHashTrytes)), res)
+	assert.Equal(t, []bool{true, true, false}, res.States)
+}
+
+func TestGetTransactionsToApprove(t *testing.T) {
+	d := newTestData(t, newTestConfig())
+
+	res := &GetTransactionsToApproveResponse{}
+	serveLegacyCommand(t, getTransactionsToApprove, `{"command": "getTransactionsToApprove", "depth": 3}`, res)
+	assert.Equal(t, d.latestMilestoneHash, res.TrunkTransaction)
+	assert.Equal(t, d.latestMilestoneHash, res.BranchTransaction)
+}
+
+func TestAttachToTangleInvalidRequest(t *testing.T) {
+	newTestData(t, newTestConfig())
+
+	for _, request := range []string{
+		fmt.Sprintf(`{"command": "attachToTangle", "trunkTransaction": "A", "branchTransaction": "%s", "trytes": []}`, consts.NullHashTrytes),
+		fmt.Sprintf(`{"command": "attachToTangle", "trunkTransaction": "%s", "branchTransaction": "%s", "trytes": ["ABC"]}`, consts.NullHashTrytes, consts.NullHashTrytes),
+	} {
+		rec := serveCommand(t, attachToTangle, request)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestStoreTransactions(t *testing.T) {
+	d := newTestData(t, newTestConfig())
+	txs := newTestMigrationBundle(t, 2, 3_000_000)
+	bundleTrytes := transaction.MustTransactionsToTrytes(txs)
+
+	// the bundle is only included once all its transactions are stored
+	serveLegacyCommand(t, storeTransactions, fmt.Sprintf(`{"command": "storeTransactions", "trytes": %s}`, jsonHashes(bundleTrytes[1:]...)), &struct{}{})
+	assert.EqualValues(t, 2, d.latestIndex())
+
+	serveLegacyCommand(t, storeTransactions, fmt.Sprintf(`{"command": "broadcastTransactions", "trytes": %s}`, jsonHashes(bundleTrytes...)), &struct{}{})
+	assert.EqualValues(t, 3, d.latestIndex())
+	assert.Equal(t, [][]trinary.Trytes{bundleTrytes}, d.milestones[3].includedMigrationBundles)
+
+	balances := &GetBalancesResponse{}
+	serveLegacyCommand(t, getBalances, fmt.Sprintf(`{"command": "getBalances", "addresses": %s}`, jsonHashes(testAddress(t, 2))), balances)
+	assert.Equal(t, []string{"0"}, balances.Balances)
+
+	// transactions without a valid nonce are rejected
+	invalid := txs[0]
+	for nonce := int64(1); transaction.HasValidNonce(&invalid, 1); nonce++ {
+		invalid.Nonce = trinary.IntToTrytes(nonce, consts.NonceTrinarySize/consts.TritsPerTryte)
+		invalid.Hash = transaction.TransactionHash(&invalid)
+	}
+	rec := serveCommand(t, storeTransactions, fmt.Sprintf(`{"command": "storeTransactions", "trytes": %s}`, jsonHashes(transaction.MustTransactionToTrytes(&invalid))))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,154 @@
+package whiteflag
+
+import (
+	"sort"
+
+	"github.com/iotaledger/iota.go/bundle"
+	"github.com/iotaledger/iota.go/consts"
+	"github.com/iotaledger/iota.go/transaction"
+	"github.com/iotaledger/iota.go/trinary"
+)
+
+// tangle holds all transactions known to the mock and the indexes to look them up.
+type tangle struct {
+	txs        map[trinary.Hash]*transaction.Transaction
+	byAddress  map[trinary.Hash][]trinary.Hash
+	byBundle   map[trinary.Hash][]trinary.Hash
+	byTag      map[trinary.Trytes][]trinary.Hash
+	byApprovee map[trinary.Hash][]trinary.Hash
+
+	// the index of the milestone confirming a transaction
+	confirmedBy map[trinary.Hash]uint32
+	// the addresses used as inputs by confirmed bundles
+	spentAddresses map[trinary.Hash]struct{}
+	// the balances as of the latest milestone
+	balances map[trinary.Hash]uint64
+}
+
+func newTangle(genesis map[trinary.Hash]uint64) *tangle {
+	t := &tangle{
+		txs:            make(map[trinary.Hash]*transaction.Transaction),
+		byAddress:      make(map[trinary.Hash][]trinary.Hash),
+		byBundle:       make(map[trinary.Hash][]trinary.Hash),
+		byTag:          make(map[trinary.Trytes][]trinary.Hash),
+		byApprovee:     make(map[trinary.Hash][]trinary.Hash),
+		confirmedBy:    make(map[trinary.Hash]uint32),
+		spentAddresses: make(map[trinary.Hash]struct{}),
+		balances:       make(map[trinary.Hash]uint64, len(genesis)),
+	}
+	for addr, balance := range genesis {
+		t.balances[addr] = balance
+	}
+	return t
+}
+
+// add adds the given transactions to the tangle and returns the ones which were not yet known.
+func (t *tangle) add(txs []transaction.Transaction) []*transaction.Transaction {
+	var added []*transaction.Transaction
+	for i := range txs {
+		tx := txs[i]
+		if _, has := t.txs[tx.Hash]; has {
+			continue
+		}
+		t.txs[tx.Hash] = &tx
+		t.byAddress[tx.Address] = append(t.byAddress[tx.Address], tx.Hash)
+		t.byBundle[tx.Bundle] = append(t.byBundle[tx.Bundle], tx.Hash)
+		t.byTag[tx.Tag] = append(t.byTag[tx.Tag], tx.Hash)
+		t.byApprovee[tx.TrunkTransaction] = append(t.byApprovee[tx.TrunkTransaction], tx.Hash)
+		if tx.BranchTransaction != tx.TrunkTransaction {
+			t.byApprovee[tx.BranchTransaction] = append(t.byApprovee[tx.BranchTransaction], tx.Hash)
+		}
+		added = append(added, &tx)
+	}
+	return added
+}
+
+// confirm adds the given milestone and its included bundles to the tangle and applies the ledger diff.
+func (t *tangle) confirm(index uint32, msBundle []trinary.Trytes, includedBundles [][]trinary.Trytes, ledgerDiff *GetLedgerDiffExtResponse) {
+	for _, bundleTrytes := range append([][]trinary.Trytes{msBundle}, includedBundles...) {
+		txs, err := transaction.AsTransactionObjects(bundleTrytes, nil)
+		if err != nil {
+			// only bundles which were parsed before are confirmed
+			continue
+		}
+		t.add(txs)
+		for i := range txs {
+			t.confirmedBy[txs[i].Hash] = index
+			if txs[i].Value < 0 {
+				t.spentAddresses[txs[i].Address] = struct{}{}
+			}
+		}
+	}
+
+	for addr, change := range ledgerDiff.Diff {
+		t.balances[addr] = uint64(int64(t.balances[addr]) + change)
+	}
+}
+
+// bundleOf returns the transactions of the complete bundle with the given hash ordered by their index,
+// or nil if not all of its transactions are known.
+func (t *tangle) bundleOf(bundleHash trinary.Hash) bundle.Bundle {
+	byIndex := make(map[trinary.Hash]*transaction.Transaction)
+	var tail *transaction.Transaction
+	for _, txHash := range t.byBundle[bundleHash] {
+		tx := t.txs[txHash]
+		byIndex[tx.Hash] = tx
+		if tx.CurrentIndex == 0 {
+			tail = tx
+		}
+	}
+	if tail == nil {
+		return nil
+	}
+
+	// follow the trunk of the tail as multiple attachments of the same bundle could be known
+	bndl := bundle.Bundle{*tail}
+	for current := tail; current.CurrentIndex < current.LastIndex; {
+		next, has := byIndex[current.TrunkTransaction]
+		if !has || next.CurrentIndex != current.CurrentIndex+1 {
+			return nil
+		}
+		bndl = append(bndl, *next)
+		current = next
+	}
+	return bndl
+}
+
+// find returns the hashes of the transactions matching all given non-empty criteria.
+func (t *tangle) find(addresses trinary.Hashes, bundles trinary.Hashes, tags []trinary.Trytes, approvees trinary.Hashes) trinary.Hashes {
+	var result map[trinary.Hash]struct{}
+	intersect := func(keys []string, index map[string][]trinary.Hash, normalize func(string) string) {
+		if len(keys) == 0 {
+			return
+		}
+		matches := make(map[trinary.Hash]struct{})
+		for _, key := range keys {
+			for _, txHash := range index[normalize(key)] {
+				if _, has := result[txHash]; result == nil || has {
+					matches[txHash] = struct{}{}
+				}
+			}
+		}
+		result = matches
+	}
+
+	intersect(addresses, t.byAddress, withoutChecksum)
+	intersect(bundles, t.byBundle, func(s string) string { return s })
+	intersect(tags, t.byTag, func(s string) string { return trinary.MustPad(s, consts.TagTrinarySize/consts.TritsPerTryte) })
+	intersect(approvees, t.byApprovee, func(s string) string { return s })
+
+	hashes := make(trinary.Hashes, 0, len(result))
+	for txHash := range result {
+		hashes = append(hashes, txHash)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// withoutChecksum strips the checksum of the given address, if it has one.
+func withoutChecksum(addr trinary.Hash) trinary.Hash {
+	if len(addr) > consts.HashTrytesSize {
+		return addr[:consts.HashTrytesSize]
+	}
+	return addr
+}
@@ -0,0 +1,86 @@
+package whiteflag
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/config"
+	"github.com/iotaledger/iota.go/address"
+	"github.com/iotaledger/iota.go/transaction"
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// creates a migration bundle spending the genesis balance of the test address with the given index.
+func newTestMigrationBundle(t *testing.T, index uint64, balance uint64) transaction.Transactions {
+	bndl, err := createMigrationBundle(testWhiteFlagSeed, config.Migration{Balance: balance, Index: index, Security: 1, Ed25519Address: testEd25519Address}, 1)
+	require.NoError(t, err)
+	txs, err := transaction.AsTransactionObjects(bndl, nil)
+	require.NoError(t, err)
+	return txs
+}
+
+func hashesOf(txs transaction.Transactions) trinary.Hashes {
+	hashes := make(trinary.Hashes, len(txs))
+	for i := range txs {
+		hashes[i] = txs[i].Hash
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+func TestTangleBundleOf(t *testing.T) {
+	tngl := newTangle(nil)
+	txs := newTestMigrationBundle(t, 2, 3_000_000)
+
+	// the tail alone is not a complete bundle
+	tngl.add(txs[:1])
+	assert.Nil(t, tngl.bundleOf(txs[0].Bundle))
+
+	// adding transactions again has no effect
+	assert.Len(t, tngl.add(txs), len(txs)-1)
+	assert.Empty(t, tngl.add(txs))
+
+	bndl := tngl.bundleOf(txs[0].Bundle)
+	require.Len(t, bndl, len(txs))
+	for i := range bndl {
+		assert.Equal(t, txs[i].Hash, bndl[i].Hash)
+	}
+	assert.Nil(t, tngl.bundleOf(txs[0].Hash))
+}
+
+func TestTangleFind(t *testing.T) {
+	tngl := newTangle(nil)
+	first, second := newTestMigrationBundle(t, 2, 3_000_000), newTestMigrationBundle(t, 3, 4_000_000)
+	tngl.add(first)
+	tngl.add(second)
+
+	migrationAddr, err := generateMigrationAddress(testEd25519Address)
+	require.NoError(t, err)
+	inputAddr, err := address.GenerateAddress(testWhiteFlagSeed, 2, 1, true)
+	require.NoError(t, err)
+
+	var tests = []struct {
+		name      string
+		addresses trinary.Hashes
+		bundles   trinary.Hashes
+		tags      []trinary.Trytes
+		approvees trinary.Hashes
+		exp       trinary.Hashes
+	}{
+		{name: "address with checksum", addresses: trinary.Hashes{inputAddr}, exp: trinary.Hashes{first[1].Hash}},
+		{name: "shared address", addresses: trinary.Hashes{migrationAddr}, exp: hashesOf(transaction.Transactions{first[0], second[0]})},
+		{name: "bundle", bundles: trinary.Hashes{second[0].Bundle}, exp: hashesOf(second)},
+		{name: "unpadded tag", tags: []trinary.Trytes{"9"}, exp: hashesOf(append(append(transaction.Transactions{}, first...), second...))},
+		{name: "approvee", approvees: trinary.Hashes{first[1].Hash}, exp: trinary.Hashes{first[0].Hash}},
+		{name: "address and bundle", addresses: trinary.Hashes{migrationAddr}, bundles: trinary.Hashes{first[0].Bundle}, exp: trinary.Hashes{first[0].Hash}},
+		{name: "no match", addresses: trinary.Hashes{inputAddr}, bundles: trinary.Hashes{second[0].Bundle}, exp: trinary.Hashes{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.exp, tngl.find(tt.addresses, tt.bundles, tt.tags, tt.approvees))
+		})
+	}
+}
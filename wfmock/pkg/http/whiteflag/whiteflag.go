@@ -2,8 +2,11 @@ package whiteflag
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 
+	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/config"
+	"github.com/iotaledger/chrysalis-tools/wfmock/pkg/faults"
 	httpapi "github.com/iotaledger/chrysalis-tools/wfmock/pkg/http"
 	"github.com/iotaledger/iota.go/trinary"
 	"github.com/labstack/echo/v4"
@@ -34,17 +37,64 @@ func getWhiteFlagConfirmation(i interface{}, c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, e)
 	}
 
-	if request.MilestoneIndex == 0 || request.MilestoneIndex >= uint32(len(data.milestones)) {
+	profile := faults.Match(GetWhiteFlagConfirmationCommand, request.MilestoneIndex)
+
+	data.RLock()
+	if profile.MissingMilestone || request.MilestoneIndex == 0 || request.MilestoneIndex >= uint32(len(data.milestones)) {
+		data.RUnlock()
 		e := httpapi.ErrorReturn{
 			Error: fmt.Sprintf("milestone not found for wf-confirmation at %d", request.MilestoneIndex),
 		}
 		return c.JSON(http.StatusBadRequest, e)
 	}
-
 	confirmation := data.milestones[request.MilestoneIndex]
+	data.RUnlock()
+
 	res := GetWhiteFlagConfirmationResponse{
 		MilestoneBundle: confirmation.milestoneBundle,
 		IncludedBundles: confirmation.includedMigrationBundles,
 	}
+
+	if profile.InvalidSignature || profile.WrongMerkleRoot {
+		msBundle, err := data.createFaultyMilestone(request.MilestoneIndex, confirmation.includedMigrationBundles, profile)
+		if err != nil {
+			e := httpapi.ErrorReturn{
+				Error: fmt.Sprintf("failed to create faulty milestone: %s", err),
+			}
+			return c.JSON(http.StatusInternalServerError, e)
+		}
+		res.MilestoneBundle = msBundle
+	}
+	switch {
+	case profile.WrongBundleOrder && len(res.IncludedBundles) < 2:
+		// the order only matters for more than one bundle, the fault is deferred to the milestones including more
+		log.Printf("skipping wrong bundle order fault for milestone %d, which includes %d bundles\n", request.MilestoneIndex, len(res.IncludedBundles))
+	case profile.WrongBundleOrder:
+		// swap the first two bundles
+		reordered := append(make([][]trinary.Trytes, 0, len(res.IncludedBundles)), res.IncludedBundles...)
+		reordered[0], reordered[1] = reordered[1], reordered[0]
+		res.IncludedBundles = reordered
+	}
 	return c.JSON(http.StatusOK, res)
 }
+
+// createFaultyMilestone re-creates the milestone bundle with the given index with the faults of the given profile:
+// an invalid signature is created using the key of a neighboring leaf of the coordinator's Merkle tree,
+// a wrong white flag Merkle tree hash by flipping the bits of the first byte of the actual one.
+func (d *whiteFlagData) createFaultyMilestone(index uint32, includedBundles [][]trinary.Trytes, profile config.FaultProfile) ([]trinary.Trytes, error) {
+	whiteFlagHash, err := computeWhiteFlagMerkleTreeHash(includedBundles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute white flag Merkle tree hash: %w", err)
+	}
+	if profile.WrongMerkleRoot {
+		whiteFlagHash[0] ^= 0xff
+	}
+
+	keyIndex := index
+	if profile.InvalidSignature {
+		keyIndex ^= 1
+	}
+
+	_, msBundle, err := signMilestone(d.cfg, d.merkleTree, index, whiteFlagHash, keyIndex)
+	return msBundle, err
+}